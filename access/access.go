@@ -0,0 +1,196 @@
+// Package access decides what a user may do with a workspace and its
+// pages. Every handler that touches a page asks the Authorizer instead of
+// filtering on ownership columns itself.
+package access
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Role is a user's permission level on a workspace or page. Roles are
+// ordered, each one includes the permissions of those below it.
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleViewer
+	RoleEditor
+	RoleAdmin
+	RoleOwner
+)
+
+var (
+	// ErrNotFound is returned when the page or workspace does not exist or
+	// the user may not even know about it
+	ErrNotFound = errors.New("not found")
+	// ErrForbidden is returned when the user can see the page or workspace
+	// but lacks the required role
+	ErrForbidden = errors.New("insufficient permissions")
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleEditor:
+		return "editor"
+	case RoleAdmin:
+		return "admin"
+	case RoleOwner:
+		return "owner"
+	default:
+		return "none"
+	}
+}
+
+// ParseRole parses a role name as stored in workspace_members and
+// page_members
+func ParseRole(s string) (Role, bool) {
+	switch s {
+	case "viewer":
+		return RoleViewer, true
+	case "editor":
+		return RoleEditor, true
+	case "admin":
+		return RoleAdmin, true
+	case "owner":
+		return RoleOwner, true
+	default:
+		return RoleNone, false
+	}
+}
+
+// Authorizer resolves roles. Pages belong to a workspace, so members of
+// the workspace get their workspace role on all of its pages; a page can
+// additionally be shared with other users through page_members. The
+// higher of the two roles wins.
+type Authorizer struct {
+	db *sql.DB
+}
+
+func NewAuthorizer(db *sql.DB) *Authorizer {
+	return &Authorizer{db: db}
+}
+
+// PageRole returns the user's role on the page. A missing page is
+// reported as ErrNotFound.
+func (a *Authorizer) PageRole(ctx context.Context, pageID, userID int) (Role, error) {
+	var workspace, member sql.NullString
+	err := a.db.QueryRowContext(ctx, `
+		SELECT wm.role, m.role
+		FROM pages p
+		LEFT JOIN workspace_members wm ON wm.workspace_id = p.workspace_id AND wm.user_id = $2
+		LEFT JOIN page_members m ON m.page_id = p.id AND m.user_id = $2
+		WHERE p.id = $1
+	`, pageID, userID).Scan(&workspace, &member)
+	if err == sql.ErrNoRows {
+		return RoleNone, ErrNotFound
+	}
+	if err != nil {
+		return RoleNone, err
+	}
+
+	role, _ := ParseRole(workspace.String)
+	if shared, ok := ParseRole(member.String); ok && shared > role {
+		role = shared
+	}
+	return role, nil
+}
+
+// WorkspaceRole returns the user's role in the workspace, RoleNone if
+// they are not a member
+func (a *Authorizer) WorkspaceRole(ctx context.Context, workspaceID, userID int) (Role, error) {
+	var name string
+	err := a.db.QueryRowContext(ctx, `
+		SELECT role FROM workspace_members WHERE workspace_id = $1 AND user_id = $2
+	`, workspaceID, userID).Scan(&name)
+	if err == sql.ErrNoRows {
+		return RoleNone, nil
+	}
+	if err != nil {
+		return RoleNone, err
+	}
+	role, _ := ParseRole(name)
+	return role, nil
+}
+
+// RequireWorkspace is Require for workspaces. Non-members get
+// ErrNotFound.
+func (a *Authorizer) RequireWorkspace(ctx context.Context, workspaceID, userID int, min Role) (Role, error) {
+	role, err := a.WorkspaceRole(ctx, workspaceID, userID)
+	if err != nil {
+		return role, err
+	}
+	if role == RoleNone {
+		return role, ErrNotFound
+	}
+	if role < min {
+		return role, ErrForbidden
+	}
+	return role, nil
+}
+
+// PersonalWorkspace returns the ID of the user's personal workspace
+func (a *Authorizer) PersonalWorkspace(ctx context.Context, userID int) (int, error) {
+	var id int
+	err := a.db.QueryRowContext(ctx, `SELECT id FROM workspaces WHERE personal AND owner_id = $1`, userID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	return id, err
+}
+
+// Require checks that the user has at least the given role. Users without
+// any role get ErrNotFound, so page IDs cannot be probed.
+func (a *Authorizer) Require(ctx context.Context, pageID, userID int, min Role) (Role, error) {
+	role, err := a.PageRole(ctx, pageID, userID)
+	if err != nil {
+		return role, err
+	}
+	if role == RoleNone {
+		return role, ErrNotFound
+	}
+	if role < min {
+		return role, ErrForbidden
+	}
+	return role, nil
+}
+
+// EnsurePersonalWorkspace returns the user's personal workspace, creating
+// it on first use
+func (a *Authorizer) EnsurePersonalWorkspace(ctx context.Context, userID int) (int, error) {
+	id, err := a.PersonalWorkspace(ctx, userID)
+	if err != ErrNotFound {
+		return id, err
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Concurrent first requests race on the unique personal index; the
+	// loser inserts nothing and reads the winner's row
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO workspaces (name, personal, owner_id) VALUES ('Personal', TRUE, $1)
+		ON CONFLICT (owner_id) WHERE personal DO NOTHING
+	`, userID)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.QueryRowContext(ctx, `SELECT id FROM workspaces WHERE personal AND owner_id = $1`, userID).Scan(&id); err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO workspace_members (workspace_id, user_id, role) VALUES ($1, $2, 'owner')
+		ON CONFLICT DO NOTHING
+	`, id, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
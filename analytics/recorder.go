@@ -0,0 +1,277 @@
+package analytics
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"tma/health"
+	"tma/metrics"
+)
+
+// View is a single page view as seen by the read path
+type View struct {
+	PageID     int
+	VisitorID  string
+	Referrer   string
+	Platform   string
+	StartParam string
+	At         time.Time
+}
+
+const (
+	bufferSize    = 1024
+	batchSize     = 100
+	flushInterval = 2 * time.Second
+	writeTimeout  = 10 * time.Second
+	// maxSeen caps the visitors remembered for deduplication. The least
+	// recently seen are forgotten first, so a flood of visitors can only
+	// let some repeat views through.
+	maxSeen = 100_000
+	// expireInterval is how often raw events past retention are deleted
+	expireInterval = time.Hour
+)
+
+var views = metrics.NewCounter("tma_page_views_total",
+	"Page views by what became of them: written, duplicate, dropped or failed.", "result")
+
+// Recorder persists page views in the background. Record never blocks:
+// when the buffer is full the view is dropped, since losing a view is
+// preferable to slowing down GetPage.
+type Recorder struct {
+	db        *sql.DB
+	window    time.Duration
+	retention time.Duration
+	views     chan View
+
+	mu     sync.Mutex
+	closed bool
+	// seen maps visitor and page to their element in order, which runs
+	// from the least to the most recently seen
+	seen  map[string]*list.Element
+	order *list.List
+
+	heartbeat health.Heartbeat
+	done      chan struct{}
+	once      sync.Once
+}
+
+type seenVisitor struct {
+	key  string
+	last time.Time
+}
+
+// NewRecorder creates a recorder that counts a visitor at most once per
+// page within window and keeps raw view events for retention. Hourly
+// rollups are kept forever.
+func NewRecorder(db *sql.DB, window, retention time.Duration) *Recorder {
+	return &Recorder{
+		db:        db,
+		window:    window,
+		retention: retention,
+		views:     make(chan View, bufferSize),
+		seen:      make(map[string]*list.Element),
+		order:     list.New(),
+		done:      make(chan struct{}),
+	}
+}
+
+// Record queues a view unless the same visitor already viewed the page
+// within the deduplication window. Views recorded after Close are dropped.
+func (r *Recorder) Record(v View) {
+	if v.At.IsZero() {
+		v.At = time.Now().UTC()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		views.Inc("dropped")
+		return
+	}
+	if r.duplicate(v) {
+		views.Inc("duplicate")
+		return
+	}
+
+	select {
+	case r.views <- v:
+	default:
+		views.Inc("dropped")
+		slog.Warn("View buffer full, dropping view", "page_id", v.PageID)
+	}
+}
+
+// duplicate must be called with r.mu held
+func (r *Recorder) duplicate(v View) bool {
+	if v.VisitorID == "" {
+		return false
+	}
+	key := v.VisitorID + ":" + strconv.Itoa(v.PageID)
+
+	if e, ok := r.seen[key]; ok {
+		visitor := e.Value.(*seenVisitor)
+		if v.At.Sub(visitor.last) < r.window {
+			return true
+		}
+		visitor.last = v.At
+		r.order.MoveToBack(e)
+		return false
+	}
+
+	r.seen[key] = r.order.PushBack(&seenVisitor{key: key, last: v.At})
+	if r.order.Len() > maxSeen {
+		r.forget(r.order.Front())
+	}
+	return false
+}
+
+// forget must be called with r.mu held
+func (r *Recorder) forget(e *list.Element) {
+	r.order.Remove(e)
+	delete(r.seen, e.Value.(*seenVisitor).key)
+}
+
+// Run consumes queued views until Close is called, writing them in batches
+func (r *Recorder) Run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]View, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := r.write(batch); err != nil {
+			views.Add(float64(len(batch)), "failed")
+			slog.Error("Failed to write views", "views", len(batch), "err", err)
+		} else {
+			views.Add(float64(len(batch)), "written")
+		}
+		batch = batch[:0]
+	}
+
+	expire := time.NewTicker(expireInterval)
+	defer expire.Stop()
+
+	for {
+		r.heartbeat.Beat()
+		select {
+		case v, ok := <-r.views:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, v)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+			r.prune()
+		case <-expire.C:
+			r.expire()
+		}
+	}
+}
+
+// Heartbeat returns when the loop last ran, for health checks
+func (r *Recorder) Heartbeat() time.Time {
+	return r.heartbeat.Last()
+}
+
+// Close stops accepting views and waits until the queue is flushed
+func (r *Recorder) Close() {
+	r.once.Do(func() {
+		r.mu.Lock()
+		r.closed = true
+		close(r.views)
+		r.mu.Unlock()
+		<-r.done
+	})
+}
+
+// prune forgets visitors whose deduplication window has passed
+func (r *Recorder) prune() {
+	cutoff := time.Now().Add(-r.window)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for e := r.order.Front(); e != nil && e.Value.(*seenVisitor).last.Before(cutoff); e = r.order.Front() {
+		r.forget(e)
+	}
+}
+
+// expire deletes raw view events older than the retention period
+func (r *Recorder) expire() {
+	if r.retention <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+
+	cutoff := time.Now().UTC().Add(-r.retention)
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM page_view_events WHERE created_at < $1`, cutoff); err != nil {
+		slog.Error("Failed to delete expired views", "err", err)
+	}
+}
+
+func (r *Recorder) write(batch []View) error {
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insertEvent, err := tx.PrepareContext(ctx, `
+		INSERT INTO page_view_events (page_id, visitor_id, referrer, platform, start_param, created_at)
+		SELECT $1, $2, $3, $4, $5, $6
+		WHERE EXISTS (SELECT 1 FROM pages WHERE id = $1)
+	`)
+	if err != nil {
+		return err
+	}
+	defer insertEvent.Close()
+
+	rollups := make(map[rollupKey]int)
+	for _, v := range batch {
+		result, err := insertEvent.ExecContext(ctx, v.PageID, v.VisitorID, v.Referrer, v.Platform, v.StartParam, v.At)
+		if err != nil {
+			return err
+		}
+		// The page may have been deleted since it was viewed
+		if n, _ := result.RowsAffected(); n == 0 {
+			continue
+		}
+		rollups[rollupKey{pageID: v.PageID, bucket: v.At.Truncate(time.Hour)}]++
+	}
+
+	for key, views := range rollups {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO page_view_rollups (page_id, bucket, views)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (page_id, bucket) DO UPDATE SET views = page_view_rollups.views + EXCLUDED.views
+		`, key.pageID, key.bucket, views)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+type rollupKey struct {
+	pageID int
+	bucket time.Time
+}
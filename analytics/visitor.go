@@ -0,0 +1,78 @@
+package analytics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// VisitorID derives a stable, non-reversible visitor identifier from the
+// client address and user agent. No raw IPs are stored.
+func VisitorID(clientIP, userAgent string) string {
+	sum := sha256.Sum256([]byte(clientIP + "|" + userAgent))
+	return hex.EncodeToString(sum[:12])
+}
+
+// FromRequest builds a View from the request metadata the Mini App sends.
+// Telegram passes the platform and start_param as tgWebApp* launch
+// parameters; clients may forward them as headers or query parameters.
+func FromRequest(r *http.Request, pageID int, clientIP string) View {
+	q := r.URL.Query()
+
+	platform := r.Header.Get("X-Telegram-Platform")
+	if platform == "" {
+		platform = q.Get("tgWebAppPlatform")
+	}
+
+	startParam := r.Header.Get("X-Telegram-Start-Param")
+	if startParam == "" {
+		startParam = firstNonEmpty(q.Get("start_param"), q.Get("tgWebAppStartParam"))
+	}
+
+	return View{
+		PageID:     pageID,
+		VisitorID:  VisitorID(clientIP, r.UserAgent()),
+		Referrer:   referrerHost(r.Referer()),
+		Platform:   truncate(platform, 32),
+		StartParam: truncate(startParam, 64),
+	}
+}
+
+// referrerHost keeps only the host of the referrer so that query strings
+// with tokens never end up in the events table
+func referrerHost(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return truncate(u.Host, 255)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// truncate cuts client-supplied text to at most n characters. Invalid
+// UTF-8 and NUL bytes are dropped too, since Postgres rejects either and
+// one bad row would fail the whole batch it is written in.
+func truncate(s string, n int) string {
+	if !utf8.ValidString(s) {
+		s = strings.ToValidUTF8(s, "")
+	}
+	s = strings.ReplaceAll(s, "\x00", "")
+	if utf8.RuneCountInString(s) > n {
+		s = string([]rune(s)[:n])
+	}
+	return s
+}
@@ -0,0 +1,25 @@
+package analytics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"tma/analytics"
+)
+
+func TestFromRequestTruncates(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/p/1?start_param=%D1%8F%FF%00ok", nil)
+	req.Header.Set("X-Telegram-Platform", strings.Repeat("я", 40))
+	view := analytics.FromRequest(req, 1, "127.0.0.1")
+
+	// Cut by characters, not in the middle of one
+	if view.Platform != strings.Repeat("я", 32) {
+		t.Errorf("Platform %q, want 32 characters", view.Platform)
+	}
+	if view.StartParam != "яok" || !utf8.ValidString(view.StartParam) {
+		t.Errorf("StartParam %q, want the invalid and NUL bytes dropped", view.StartParam)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
 	"sort"
@@ -16,6 +17,9 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// TokenTTL is how long issued tokens stay valid
+const TokenTTL = 24 * time.Hour
+
 type JWTManager struct {
 	secretKey string
 }
@@ -27,15 +31,23 @@ func NewJWTManager(secretKey string) *JWTManager {
 type Claims struct {
 	UserID     int   `json:"user_id"`
 	TelegramID int64 `json:"telegram_id"`
+	// WorkspaceID is the active workspace; 0 means the personal workspace
+	WorkspaceID int `json:"workspace_id,omitempty"`
 	jwt.RegisteredClaims
 }
 
 func (j *JWTManager) GenerateToken(user models.User) (string, error) {
+	return j.GenerateWorkspaceToken(user, 0)
+}
+
+// GenerateWorkspaceToken issues a token with the given active workspace
+func (j *JWTManager) GenerateWorkspaceToken(user models.User, workspaceID int) (string, error) {
 	claims := &Claims{
-		UserID:     user.ID,
-		TelegramID: user.TelegramID,
+		UserID:      user.ID,
+		TelegramID:  user.TelegramID,
+		WorkspaceID: workspaceID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)), // 24 hours
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 		},
@@ -46,6 +58,23 @@ func (j *JWTManager) GenerateToken(user models.User) (string, error) {
 }
 
 func (j *JWTManager) ValidateToken(tokenString string) (*Claims, error) {
+	claims, err := j.parseToken(tokenString)
+	switch {
+	case err == nil:
+		CountAttempt(KindToken, "")
+	case errors.Is(err, jwt.ErrTokenExpired):
+		CountAttempt(KindToken, "expired")
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		CountAttempt(KindToken, "malformed")
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		CountAttempt(KindToken, "invalid_signature")
+	default:
+		CountAttempt(KindToken, "invalid")
+	}
+	return claims, err
+}
+
+func (j *JWTManager) parseToken(tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -54,16 +83,13 @@ func (j *JWTManager) ValidateToken(tokenString string) (*Claims, error) {
 	})
 
 	if err != nil {
-		fmt.Printf("JWT ValidateToken: Parse error: %v\n", err)
 		return nil, err
 	}
 
 	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		fmt.Printf("JWT ValidateToken: Token is valid for user ID: %d\n", claims.UserID)
 		return claims, nil
 	}
 
-	fmt.Printf("JWT ValidateToken: Token is invalid\n")
 	return nil, fmt.Errorf("invalid token")
 }
 
@@ -80,68 +106,64 @@ type TelegramUserData struct {
 
 // ValidateTelegramInitData validates Telegram Mini App init data
 func ValidateTelegramInitData(initData, botToken string) (*models.TelegramUser, error) {
+	user, reason, err := parseInitData(initData, botToken)
+	CountAttempt(KindInitData, reason)
+	return user, err
+}
+
+// parseInitData returns the user of initData, or the reason it was
+// rejected for the auth metrics
+func parseInitData(initData, botToken string) (*models.TelegramUser, string, error) {
 	if initData == "" {
-		return nil, fmt.Errorf("init_data is empty")
+		return nil, "empty", fmt.Errorf("init_data is empty")
 	}
 
-	// Log the received init data for debugging
-	fmt.Printf("Received initData: %s\n", initData)
-
 	// Parse the init data
 	values, err := url.ParseQuery(initData)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse init_data: %w", err)
+		return nil, "malformed", fmt.Errorf("failed to parse init_data: %w", err)
 	}
 
-	// Log parsed values for debugging
-	fmt.Printf("Parsed values: %+v\n", values)
-
 	// Extract user data
 	userStr := values.Get("user")
 	if userStr == "" {
-		return nil, fmt.Errorf("user data not found in init_data")
+		return nil, "missing_user", fmt.Errorf("user data not found in init_data")
 	}
 
 	// URL decode the user string
 	decodedUserStr, err := url.QueryUnescape(userStr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode user data: %w", err)
+		return nil, "malformed", fmt.Errorf("failed to decode user data: %w", err)
 	}
 
-	fmt.Printf("Decoded user string: %s\n", decodedUserStr)
-
 	// Parse user JSON
 	var userData TelegramUserData
 	if err := json.Unmarshal([]byte(decodedUserStr), &userData); err != nil {
-		return nil, fmt.Errorf("failed to parse user JSON: %w", err)
+		return nil, "malformed", fmt.Errorf("failed to parse user JSON: %w", err)
 	}
 
-	fmt.Printf("Parsed user data: %+v\n", userData)
-
 	// Validate hash if bot token is provided
 	if botToken != "" {
 		hash := values.Get("hash")
 		if hash == "" {
-			return nil, fmt.Errorf("hash not found in init_data")
+			return nil, "missing_hash", fmt.Errorf("hash not found in init_data")
 		}
 
-		fmt.Printf("Validating hash: %s\n", hash)
 		if !validateHash(values, hash, botToken) {
-			return nil, fmt.Errorf("invalid hash")
+			return nil, "invalid_hash", fmt.Errorf("invalid hash")
 		}
-		fmt.Printf("Hash validation successful\n")
-	} else {
-		fmt.Printf("Skipping hash validation (no bot token provided)\n")
 	}
 
 	user := &models.TelegramUser{
-		ID:        userData.ID,
-		Username:  userData.Username,
-		FirstName: userData.FirstName,
-		LastName:  userData.LastName,
+		ID:              userData.ID,
+		Username:        userData.Username,
+		FirstName:       userData.FirstName,
+		LastName:        userData.LastName,
+		AllowsWriteToPM: userData.AllowsWriteToPM,
+		StartParam:      values.Get("start_param"),
 	}
 
-	return user, nil
+	return user, "", nil
 }
 
 func validateHash(values url.Values, hash, botToken string) bool {
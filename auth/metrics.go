@@ -0,0 +1,24 @@
+package auth
+
+import "tma/metrics"
+
+// Kinds of authentication attempts
+const (
+	// KindInitData is a login with Telegram init data
+	KindInitData = "init_data"
+	// KindToken is a request authenticated with a JWT
+	KindToken = "token"
+)
+
+var attempts = metrics.NewCounter("tma_auth_attempts_total",
+	"Authentication attempts by kind, result and failure reason.", "kind", "result", "reason")
+
+// CountAttempt records an authentication attempt for the metrics. An
+// empty reason means it succeeded.
+func CountAttempt(kind, reason string) {
+	if reason == "" {
+		attempts.Inc(kind, "success", "")
+		return
+	}
+	attempts.Inc(kind, "failure", reason)
+}
@@ -0,0 +1,176 @@
+package bot_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"tma/bot"
+	"tma/database"
+	"tma/telegram"
+	"tma/telegram/telegramtest"
+)
+
+// testDB opens the database in TEST_DATABASE_URL, which is migrated first
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := database.New(url, database.Options{})
+	if err != nil {
+		t.Fatalf("database.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db.DB
+}
+
+// recorder is an UpdateHandler that records the updates it sees and
+// fails with the errors queued in fail
+type recorder struct {
+	mu   sync.Mutex
+	seen []int64
+	fail []error
+}
+
+func (r *recorder) HandleUpdate(ctx context.Context, u telegram.Update) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seen = append(r.seen, u.UpdateID)
+	if len(r.fail) > 0 {
+		err := r.fail[0]
+		r.fail = r.fail[1:]
+		return err
+	}
+	return nil
+}
+
+func (r *recorder) updates() []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int64{}, r.seen...)
+}
+
+func TestDispatcherDedup(t *testing.T) {
+	db := testDB(t)
+	handler := &recorder{}
+	dispatcher := bot.NewDispatcher(db, handler)
+	ctx := context.Background()
+
+	// IDs no other run has used
+	id := time.Now().UnixNano()
+	update := telegram.Update{UpdateID: id}
+
+	for i := 0; i < 2; i++ {
+		if err := dispatcher.Dispatch(ctx, update); err != nil {
+			t.Fatalf("Dispatch %d: %v", i, err)
+		}
+	}
+	if seen := handler.updates(); len(seen) != 1 {
+		t.Fatalf("handler saw %v, want the update once", seen)
+	}
+
+	// Another dispatcher, as on another instance, skips it too
+	if err := bot.NewDispatcher(db, handler).Dispatch(ctx, update); err != nil {
+		t.Fatalf("Dispatch on another dispatcher: %v", err)
+	}
+	if seen := handler.updates(); len(seen) != 1 {
+		t.Fatalf("handler saw %v after another dispatcher, want the update once", seen)
+	}
+
+	// A failed update is retried on redelivery
+	handler.fail = []error{errors.New("temporary")}
+	failing := telegram.Update{UpdateID: id + 1}
+	if err := dispatcher.Dispatch(ctx, failing); err == nil {
+		t.Fatal("Dispatch returned no error for a failing handler")
+	}
+	if err := dispatcher.Dispatch(ctx, failing); err != nil {
+		t.Fatalf("Dispatch of the redelivered update: %v", err)
+	}
+	if seen := handler.updates(); len(seen) != 3 {
+		t.Fatalf("handler saw %v, want the failed update twice", seen)
+	}
+
+	// A permanent Bot API error is not
+	handler.fail = []error{&telegram.Error{Method: "sendMessage", Code: http.StatusForbidden, Description: "Forbidden: bot was blocked by the user"}}
+	blocked := telegram.Update{UpdateID: id + 2}
+	for i := 0; i < 2; i++ {
+		if err := dispatcher.Dispatch(ctx, blocked); err != nil {
+			t.Fatalf("Dispatch %d of an update failing permanently: %v", i, err)
+		}
+	}
+	if seen := handler.updates(); len(seen) != 4 {
+		t.Fatalf("handler saw %v, want the permanently failing update once", seen)
+	}
+}
+
+func TestPollerOffset(t *testing.T) {
+	db := testDB(t)
+	// A bot of its own, so the stored offset belongs to this run
+	server := telegramtest.NewServer(fmt.Sprintf("%d:test-token", time.Now().UnixNano()%1e12))
+	defer server.Close()
+	client := server.Client()
+	handler := &recorder{}
+	dispatcher := bot.NewDispatcher(db, handler)
+
+	// telegram_updates is shared, so update IDs must be new as well
+	first := server.PushUpdate(telegram.Update{UpdateID: time.Now().UnixNano()})
+	second := server.PushUpdate(telegram.Update{})
+
+	poller := bot.NewPoller(db, client, dispatcher)
+	go poller.Run()
+	waitFor(t, func() bool { return len(handler.updates()) == 2 })
+	poller.Close()
+
+	if seen := handler.updates(); seen[0] != first.UpdateID || seen[1] != second.UpdateID {
+		t.Fatalf("handler saw %v, want %d and %d in order", seen, first.UpdateID, second.UpdateID)
+	}
+	if len(server.Calls("deleteWebhook")) == 0 {
+		t.Error("poller did not delete the webhook")
+	}
+
+	var offset int64
+	if err := db.QueryRow(`SELECT next_offset FROM telegram_poll_state WHERE bot_id = $1`, client.BotID()).Scan(&offset); err != nil {
+		t.Fatalf("read offset: %v", err)
+	}
+	if offset != second.UpdateID+1 {
+		t.Fatalf("stored offset %d, want %d", offset, second.UpdateID+1)
+	}
+
+	// A restarted poller continues from the stored offset
+	third := server.PushUpdate(telegram.Update{})
+	before := len(server.Calls("getUpdates"))
+	poller = bot.NewPoller(db, client, dispatcher)
+	go poller.Run()
+	waitFor(t, func() bool { return len(handler.updates()) == 3 })
+	poller.Close()
+
+	var params telegram.GetUpdatesParams
+	if err := server.Calls("getUpdates")[before].Decode(&params); err != nil {
+		t.Fatalf("decode getUpdates: %v", err)
+	}
+	if params.Offset != offset {
+		t.Errorf("restarted poller asked for offset %d, want %d", params.Offset, offset)
+	}
+	if seen := handler.updates(); seen[2] != third.UpdateID {
+		t.Errorf("handler saw %v, want %d last", seen, third.UpdateID)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,216 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"tma/telegram"
+)
+
+// myPagesLimit is the number of pages /mypages lists
+const myPagesLimit = 10
+
+// pagePayloadPrefix starts deep-link payloads that open a page:
+// https://t.me/<bot>?start=page_12
+const pagePayloadPrefix = "page_"
+
+// Commands implements the bot's chat commands
+type Commands struct {
+	db     *sql.DB
+	client *telegram.Client
+	// miniAppURL is where web_app buttons point; publicURL serves the
+	// server-rendered pages used in chats where web_app buttons are not
+	// allowed
+	miniAppURL string
+	publicURL  string
+}
+
+func NewCommands(db *sql.DB, client *telegram.Client, miniAppURL, publicURL string) *Commands {
+	return &Commands{
+		db:         db,
+		client:     client,
+		miniAppURL: strings.TrimRight(miniAppURL, "/"),
+		publicURL:  strings.TrimRight(publicURL, "/"),
+	}
+}
+
+// Register adds the commands to a router
+func (c *Commands) Register(r *Router) {
+	r.Handle("start", c.start)
+	r.Handle("mypages", c.myPages)
+	r.Handle("help", c.help)
+	r.Fallback(c.unknown)
+	r.HandleInlineQuery(c.inlineQuery)
+}
+
+// BotCommands describes the commands for setMyCommands
+func (c *Commands) BotCommands() []telegram.BotCommand {
+	return []telegram.BotCommand{
+		{Command: "start", Description: "Open the app"},
+		{Command: "mypages", Description: "List your pages"},
+		{Command: "help", Description: "Show available commands"},
+	}
+}
+
+func (c *Commands) start(ctx context.Context, cmd Command) error {
+	// Starting the bot in a private chat allows it to send notifications
+	if cmd.Message.Chat.Type == "private" {
+		_, err := c.db.ExecContext(ctx, `UPDATE users SET allows_write_to_pm = TRUE WHERE telegram_id = $1`, senderID(cmd.Message))
+		if err != nil {
+			return err
+		}
+	}
+
+	if strings.HasPrefix(cmd.Args, pagePayloadPrefix) {
+		if pageID, err := strconv.Atoi(strings.TrimPrefix(cmd.Args, pagePayloadPrefix)); err == nil {
+			return c.openPage(ctx, cmd.Message, pageID)
+		}
+	}
+
+	params := telegram.SendMessageParams{
+		ChatID: cmd.Message.Chat.ID,
+		Text:   "Welcome! Create pages and share them right in Telegram.",
+	}
+	if button, ok := c.appButton(cmd.Message, "Open app", 0); ok {
+		params.ReplyMarkup = keyboard(button)
+	}
+	_, err := c.client.SendMessage(ctx, params)
+	return err
+}
+
+// openPage answers a /start deep link with a button opening the page
+func (c *Commands) openPage(ctx context.Context, msg *telegram.Message, pageID int) error {
+	var title string
+	err := c.db.QueryRowContext(ctx, `SELECT title FROM pages WHERE id = $1`, pageID).Scan(&title)
+	if err == sql.ErrNoRows {
+		_, err := c.client.SendMessage(ctx, telegram.SendMessageParams{
+			ChatID: msg.Chat.ID,
+			Text:   "This page no longer exists.",
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	params := telegram.SendMessageParams{ChatID: msg.Chat.ID, Text: title}
+	if button, ok := c.appButton(msg, "Open page", pageID); ok {
+		params.ReplyMarkup = keyboard(button)
+	}
+	_, err = c.client.SendMessage(ctx, params)
+	return err
+}
+
+func (c *Commands) myPages(ctx context.Context, cmd Command) error {
+	var userID int
+	err := c.db.QueryRowContext(ctx, `SELECT id FROM users WHERE telegram_id = $1`, senderID(cmd.Message)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		_, err := c.client.SendMessage(ctx, telegram.SendMessageParams{
+			ChatID: cmd.Message.Chat.ID,
+			Text:   "You have no pages yet. Open the app to create one.",
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	rows, err := c.db.QueryContext(ctx, `
+		SELECT p.id, p.title
+		FROM pages p
+		WHERE p.workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = $1)
+			OR p.id IN (SELECT page_id FROM page_members WHERE user_id = $1)
+		ORDER BY p.updated_at DESC
+		LIMIT $2
+	`, userID, myPagesLimit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var buttons [][]telegram.InlineKeyboardButton
+	for rows.Next() {
+		var id int
+		var title string
+		if err := rows.Scan(&id, &title); err != nil {
+			return err
+		}
+		if button, ok := c.appButton(cmd.Message, title, id); ok {
+			buttons = append(buttons, []telegram.InlineKeyboardButton{button})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	params := telegram.SendMessageParams{ChatID: cmd.Message.Chat.ID}
+	if len(buttons) == 0 {
+		params.Text = "You have no pages yet. Open the app to create one."
+	} else {
+		params.Text = fmt.Sprintf("Your recent pages (%d):", len(buttons))
+		params.ReplyMarkup = &telegram.InlineKeyboardMarkup{InlineKeyboard: buttons}
+	}
+	_, err = c.client.SendMessage(ctx, params)
+	return err
+}
+
+func (c *Commands) help(ctx context.Context, cmd Command) error {
+	var b strings.Builder
+	b.WriteString("Available commands:\n")
+	for _, bc := range c.BotCommands() {
+		fmt.Fprintf(&b, "/%s - %s\n", bc.Command, bc.Description)
+	}
+	_, err := c.client.SendMessage(ctx, telegram.SendMessageParams{
+		ChatID: cmd.Message.Chat.ID,
+		Text:   b.String(),
+	})
+	return err
+}
+
+func (c *Commands) unknown(ctx context.Context, cmd Command) error {
+	// Groups see commands meant for other bots; only answer in private
+	if cmd.Message.Chat.Type != "private" {
+		return nil
+	}
+	_, err := c.client.SendMessage(ctx, telegram.SendMessageParams{
+		ChatID: cmd.Message.Chat.ID,
+		Text:   "Unknown command. Send /help to see what I can do.",
+	})
+	return err
+}
+
+// appButton links to the Mini App, or to a page in it when pageID is set.
+// web_app buttons only work in private chats, elsewhere the
+// server-rendered page is linked instead.
+func (c *Commands) appButton(msg *telegram.Message, text string, pageID int) (telegram.InlineKeyboardButton, bool) {
+	button := telegram.InlineKeyboardButton{Text: text}
+
+	if c.miniAppURL != "" && msg.Chat.Type == "private" {
+		u := c.miniAppURL
+		if pageID != 0 {
+			u += "?page=" + url.QueryEscape(strconv.Itoa(pageID))
+		}
+		button.WebApp = &telegram.WebAppInfo{URL: u}
+		return button, true
+	}
+	if c.publicURL != "" && pageID != 0 {
+		button.URL = fmt.Sprintf("%s/p/%d", c.publicURL, pageID)
+		return button, true
+	}
+	return button, false
+}
+
+func keyboard(button telegram.InlineKeyboardButton) *telegram.InlineKeyboardMarkup {
+	return &telegram.InlineKeyboardMarkup{InlineKeyboard: [][]telegram.InlineKeyboardButton{{button}}}
+}
+
+func senderID(msg *telegram.Message) int64 {
+	if msg.From != nil {
+		return msg.From.ID
+	}
+	return msg.Chat.ID
+}
@@ -0,0 +1,98 @@
+// Package bot processes updates sent to the Telegram bot. Updates arrive
+// through the webhook handler or the long-polling runner and all go
+// through a Dispatcher, which drops duplicates before handing them on.
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"tma/metrics"
+	"tma/telegram"
+)
+
+const (
+	// dedupRetention is how long processed update IDs are remembered.
+	// Telegram gives up redelivering an update long before that.
+	dedupRetention = 24 * time.Hour
+	// UpdateTimeout bounds the processing of a single update. Telegram
+	// redelivers updates whose webhook request does not complete in time.
+	UpdateTimeout = 30 * time.Second
+)
+
+var updates = metrics.NewCounter("tma_bot_updates_total",
+	"Bot updates by result: handled, duplicate, dropped after a permanent error, or failed.", "result")
+
+// UpdateHandler processes a single update
+type UpdateHandler interface {
+	HandleUpdate(ctx context.Context, u telegram.Update) error
+}
+
+// Dispatcher hands every update to the handler exactly once, even when
+// Telegram redelivers it or several instances receive it. Processed
+// update IDs are recorded in telegram_updates.
+type Dispatcher struct {
+	db      *sql.DB
+	handler UpdateHandler
+
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+func NewDispatcher(db *sql.DB, handler UpdateHandler) *Dispatcher {
+	return &Dispatcher{db: db, handler: handler}
+}
+
+// Dispatch processes an update unless it was seen before. When the
+// handler fails the update is forgotten again, so a redelivery retries it,
+// unless the failure is a Bot API error that a retry cannot fix.
+func (d *Dispatcher) Dispatch(ctx context.Context, u telegram.Update) error {
+	result, err := d.db.ExecContext(ctx, `
+		INSERT INTO telegram_updates (update_id) VALUES ($1)
+		ON CONFLICT (update_id) DO NOTHING
+	`, u.UpdateID)
+	if err != nil {
+		return fmt.Errorf("record update %d: %w", u.UpdateID, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		updates.Inc("duplicate")
+		return nil
+	}
+
+	d.prune(ctx)
+
+	if err := d.handler.HandleUpdate(ctx, u); err != nil {
+		if telegram.IsPermanent(err) {
+			updates.Inc("dropped")
+			slog.Error("Failed to handle update", "update_id", u.UpdateID, "err", err)
+			return nil
+		}
+		if _, ferr := d.db.ExecContext(context.Background(), `DELETE FROM telegram_updates WHERE update_id = $1`, u.UpdateID); ferr != nil {
+			slog.Error("Failed to forget update", "update_id", u.UpdateID, "err", ferr)
+		}
+		updates.Inc("failed")
+		return fmt.Errorf("handle update %d: %w", u.UpdateID, err)
+	}
+	updates.Inc("handled")
+	return nil
+}
+
+// prune drops old update IDs at most once an hour
+func (d *Dispatcher) prune(ctx context.Context) {
+	d.mu.Lock()
+	if time.Since(d.lastPrune) < time.Hour {
+		d.mu.Unlock()
+		return
+	}
+	d.lastPrune = time.Now()
+	d.mu.Unlock()
+
+	_, err := d.db.ExecContext(ctx, `DELETE FROM telegram_updates WHERE received_at < $1`, time.Now().Add(-dedupRetention))
+	if err != nil {
+		slog.Error("Failed to prune processed updates", "err", err)
+	}
+}
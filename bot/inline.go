@@ -0,0 +1,108 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"tma/telegram"
+)
+
+const (
+	// inlineResultsLimit is the number of pages per inline query answer;
+	// Telegram accepts at most 50
+	inlineResultsLimit = 20
+	// inlineCacheTime is how long Telegram may reuse an answer, in seconds.
+	// Answers are personal, so this only saves repeated keystrokes.
+	inlineCacheTime = 10
+)
+
+// PageArticle is how a page appears when shared into a chat: its title and
+// description, a link to the public page, whose preview renders a card,
+// and the given button
+func PageArticle(pageID int, title, description, publicURL string, button *telegram.InlineKeyboardButton) telegram.InlineQueryResultArticle {
+	text := title
+	if description != "" {
+		text += "\n\n" + description
+	}
+
+	article := telegram.InlineQueryResultArticle{
+		Type:        "article",
+		ID:          strconv.Itoa(pageID),
+		Title:       title,
+		Description: description,
+	}
+	if publicURL != "" {
+		pageURL := fmt.Sprintf("%s/p/%d", publicURL, pageID)
+		text += "\n\n" + pageURL
+		article.ThumbnailURL = pageURL + "/preview.png"
+	}
+	article.InputMessageContent = telegram.InputTextMessageContent{MessageText: text}
+	if button != nil {
+		article.ReplyMarkup = keyboard(*button)
+	}
+	return article
+}
+
+// inlineQuery searches the pages of the user by title and description.
+// The results are posted into chats the bot is not part of, where web_app
+// buttons are not allowed, so they link to the public page instead.
+func (c *Commands) inlineQuery(ctx context.Context, q *telegram.InlineQuery) error {
+	offset, _ := strconv.Atoi(q.Offset)
+	answer := telegram.AnswerInlineQueryParams{
+		InlineQueryID: q.ID,
+		Results:       []telegram.InlineQueryResultArticle{},
+		CacheTime:     inlineCacheTime,
+		IsPersonal:    true,
+	}
+	if c.miniAppURL != "" && offset == 0 {
+		answer.Button = &telegram.InlineQueryResultsButton{
+			Text:   "Open app",
+			WebApp: &telegram.WebAppInfo{URL: c.miniAppURL},
+		}
+	}
+
+	rows, err := c.db.QueryContext(ctx, `
+		SELECT p.id, p.title, COALESCE(p.description, '')
+		FROM pages p
+		JOIN users u ON u.telegram_id = $1
+		WHERE (p.workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = u.id)
+				OR p.id IN (SELECT page_id FROM page_members WHERE user_id = u.id))
+			AND (p.title ILIKE $2 OR p.description ILIKE $2)
+		ORDER BY p.updated_at DESC, p.id DESC
+		LIMIT $3 OFFSET $4
+	`, q.From.ID, likePattern(q.Query), inlineResultsLimit, offset)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var title, description string
+		if err := rows.Scan(&id, &title, &description); err != nil {
+			return err
+		}
+
+		var button *telegram.InlineKeyboardButton
+		if c.publicURL != "" {
+			button = &telegram.InlineKeyboardButton{Text: "Open page", URL: fmt.Sprintf("%s/p/%d", c.publicURL, id)}
+		}
+		answer.Results = append(answer.Results, PageArticle(id, title, description, c.publicURL, button))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(answer.Results) == inlineResultsLimit {
+		answer.NextOffset = strconv.Itoa(offset + inlineResultsLimit)
+	}
+	return c.client.AnswerInlineQuery(ctx, answer)
+}
+
+// likePattern matches text containing s, with LIKE wildcards in s escaped
+func likePattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.TrimSpace(s))
+	return "%" + s + "%"
+}
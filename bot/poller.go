@@ -0,0 +1,162 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"tma/health"
+	"tma/telegram"
+)
+
+const (
+	// pollTimeout is the long-polling timeout passed to getUpdates, in seconds
+	pollTimeout = 50
+	pollLimit   = 100
+	// maxUpdateAttempts bounds how often a failing update is retried before
+	// the poller moves past it
+	maxUpdateAttempts = 3
+	maxPollBackoff    = 30 * time.Second
+	// offsetTimeout bounds saving the offset, which outlives the poller
+	// context so the last update processed before shutdown is recorded
+	offsetTimeout = 10 * time.Second
+)
+
+// Poller fetches updates with getUpdates and feeds them to the same
+// Dispatcher the webhook uses. It is meant for development, where
+// Telegram cannot reach a webhook. The offset is stored in
+// telegram_poll_state so a restart neither loses nor replays updates.
+type Poller struct {
+	db         *sql.DB
+	client     *telegram.Client
+	dispatcher *Dispatcher
+
+	heartbeat health.Heartbeat
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+func NewPoller(db *sql.DB, client *telegram.Client, dispatcher *Dispatcher) *Poller {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Poller{
+		db:         db,
+		client:     client,
+		dispatcher: dispatcher,
+		ctx:        ctx,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+}
+
+// Run polls until Close is called
+func (p *Poller) Run() {
+	defer close(p.done)
+
+	// getUpdates is refused while a webhook is set
+	if err := p.client.DeleteWebhook(p.ctx, false); err != nil {
+		slog.Warn("Failed to delete webhook", "err", err)
+	}
+
+	offset, err := p.loadOffset()
+	if err != nil {
+		slog.Error("Failed to load polling offset", "err", err)
+	}
+
+	slog.Info("Polling for updates", "offset", offset)
+
+	backoff := time.Second
+	attempts := 0
+	for p.ctx.Err() == nil {
+		p.heartbeat.Beat()
+		updates, err := p.client.GetUpdates(p.ctx, telegram.GetUpdatesParams{
+			Offset:  offset,
+			Limit:   pollLimit,
+			Timeout: pollTimeout,
+		})
+		if err != nil {
+			if p.ctx.Err() != nil {
+				break
+			}
+			slog.Warn("Failed to get updates, retrying", "backoff", backoff.String(), "err", err)
+			p.sleep(backoff)
+			backoff = min(backoff*2, maxPollBackoff)
+			continue
+		}
+		backoff = time.Second
+
+		for _, u := range updates {
+			if p.ctx.Err() != nil {
+				break
+			}
+			p.heartbeat.Beat()
+			if err := p.dispatch(u); err != nil {
+				attempts++
+				if attempts < maxUpdateAttempts {
+					// Fetch the update again on the next poll
+					slog.Warn("Failed to handle update, retrying", "attempt", attempts, "err", err)
+					p.sleep(time.Duration(attempts) * time.Second)
+					break
+				}
+				slog.Error("Giving up on update", "err", err)
+			}
+			attempts = 0
+			offset = u.UpdateID + 1
+		}
+
+		if err := p.saveOffset(offset); err != nil {
+			slog.Error("Failed to save polling offset", "err", err)
+		}
+	}
+}
+
+// Heartbeat returns when the loop last ran, for health checks
+func (p *Poller) Heartbeat() time.Time {
+	return p.heartbeat.Last()
+}
+
+// Close stops polling after the update being processed, if any, is done
+func (p *Poller) Close() {
+	p.cancel()
+	<-p.done
+}
+
+// dispatch processes one update. It does not use the poller context, so
+// shutting down lets the current update finish.
+func (p *Poller) dispatch(u telegram.Update) error {
+	ctx, cancel := context.WithTimeout(context.Background(), UpdateTimeout)
+	defer cancel()
+	return p.dispatcher.Dispatch(ctx, u)
+}
+
+func (p *Poller) sleep(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-p.ctx.Done():
+	case <-timer.C:
+	}
+}
+
+func (p *Poller) loadOffset() (int64, error) {
+	var offset int64
+	err := p.db.QueryRowContext(p.ctx, `SELECT next_offset FROM telegram_poll_state WHERE bot_id = $1`, p.client.BotID()).Scan(&offset)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return offset, err
+}
+
+func (p *Poller) saveOffset(offset int64) error {
+	if offset == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), offsetTimeout)
+	defer cancel()
+	_, err := p.db.ExecContext(ctx, `
+		INSERT INTO telegram_poll_state (bot_id, next_offset, updated_at) VALUES ($1, $2, CURRENT_TIMESTAMP)
+		ON CONFLICT (bot_id) DO UPDATE SET next_offset = EXCLUDED.next_offset, updated_at = EXCLUDED.updated_at
+	`, p.client.BotID(), offset)
+	return err
+}
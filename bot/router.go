@@ -0,0 +1,126 @@
+package bot
+
+import (
+	"context"
+	"strings"
+
+	"tma/telegram"
+)
+
+// Command is a bot command received in a message, such as
+// "/start page_12" which has Name "start" and Args "page_12"
+type Command struct {
+	Name    string
+	Args    string
+	Message *telegram.Message
+}
+
+// CommandHandler handles one command
+type CommandHandler func(ctx context.Context, cmd Command) error
+
+// InlineQueryHandler answers inline queries ("@bot query" in any chat)
+type InlineQueryHandler func(ctx context.Context, q *telegram.InlineQuery) error
+
+// PreCheckoutQueryHandler confirms or rejects a payment about to be made
+type PreCheckoutQueryHandler func(ctx context.Context, q *telegram.PreCheckoutQuery) error
+
+// PaymentHandler handles the service messages about successful and
+// refunded payments
+type PaymentHandler func(ctx context.Context, msg *telegram.Message) error
+
+// Router is an UpdateHandler that routes message commands, inline queries
+// and payments to handlers. Other updates, and messages that are not
+// commands, are ignored.
+type Router struct {
+	// Username is the bot's username. Commands addressed to another bot
+	// ("/help@other_bot") are ignored; when empty all are accepted.
+	Username string
+
+	commands map[string]CommandHandler
+	fallback CommandHandler
+	inline   InlineQueryHandler
+	checkout PreCheckoutQueryHandler
+	payment  PaymentHandler
+}
+
+func NewRouter() *Router {
+	return &Router{commands: make(map[string]CommandHandler)}
+}
+
+// Handle registers the handler of a command, given without the slash
+func (r *Router) Handle(name string, h CommandHandler) {
+	r.commands[strings.ToLower(name)] = h
+}
+
+// Fallback registers the handler of unknown commands
+func (r *Router) Fallback(h CommandHandler) {
+	r.fallback = h
+}
+
+// HandleInlineQuery registers the handler of inline queries
+func (r *Router) HandleInlineQuery(h InlineQueryHandler) {
+	r.inline = h
+}
+
+// HandlePreCheckoutQuery registers the handler of pre-checkout queries
+func (r *Router) HandlePreCheckoutQuery(h PreCheckoutQueryHandler) {
+	r.checkout = h
+}
+
+// HandlePayment registers the handler of payment service messages
+func (r *Router) HandlePayment(h PaymentHandler) {
+	r.payment = h
+}
+
+func (r *Router) HandleUpdate(ctx context.Context, u telegram.Update) error {
+	if u.InlineQuery != nil && r.inline != nil {
+		return r.inline(ctx, u.InlineQuery)
+	}
+	if u.PreCheckoutQuery != nil && r.checkout != nil {
+		return r.checkout(ctx, u.PreCheckoutQuery)
+	}
+	if u.Message == nil {
+		return nil
+	}
+	if u.Message.SuccessfulPayment != nil || u.Message.RefundedPayment != nil {
+		if r.payment != nil {
+			return r.payment(ctx, u.Message)
+		}
+		return nil
+	}
+
+	cmd, ok := r.parse(u.Message)
+	if !ok {
+		return nil
+	}
+
+	if h, ok := r.commands[cmd.Name]; ok {
+		return h(ctx, cmd)
+	}
+	if r.fallback != nil {
+		return r.fallback(ctx, cmd)
+	}
+	return nil
+}
+
+// parse extracts the command of a message that starts with one
+func (r *Router) parse(msg *telegram.Message) (Command, bool) {
+	if !strings.HasPrefix(msg.Text, "/") {
+		return Command{}, false
+	}
+
+	head, args, _ := strings.Cut(msg.Text, " ")
+	name, target, addressed := strings.Cut(head[1:], "@")
+	if name == "" {
+		return Command{}, false
+	}
+	if addressed && r.Username != "" && !strings.EqualFold(target, r.Username) {
+		return Command{}, false
+	}
+
+	return Command{
+		Name:    strings.ToLower(name),
+		Args:    strings.TrimSpace(args),
+		Message: msg,
+	}, true
+}
@@ -0,0 +1,40 @@
+// Command s3-fake runs the fake S3 bucket from package s3test as a
+// standalone server, so the S3 storage backend can be developed without
+// MinIO or AWS. Objects are kept in memory. Point the API server at it
+// with STORAGE_BACKEND=s3, S3_PATH_STYLE=true and the endpoint, bucket and
+// keys it prints.
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"tma/storage/s3test"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9000", "listen address")
+	bucket := flag.String("bucket", "assets", "bucket name")
+	flag.Parse()
+
+	server, err := s3test.NewServerAt(*bucket, *addr)
+	if err != nil {
+		slog.Error("Failed to start fake S3", "err", err)
+		os.Exit(1)
+	}
+	defer server.Close()
+
+	slog.Info("Fake S3 listening",
+		"S3_ENDPOINT", server.URL,
+		"S3_REGION", server.Region,
+		"S3_BUCKET", server.Bucket,
+		"S3_ACCESS_KEY_ID", server.AccessKeyID,
+		"S3_SECRET_ACCESS_KEY", server.SecretAccessKey)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+}
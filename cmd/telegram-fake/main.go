@@ -0,0 +1,42 @@
+// Command telegram-fake runs the fake Bot API from package telegramtest as
+// a standalone server, so the bot can be developed without Telegram.
+// Point the API server at it with TELEGRAM_API_URL and
+// TELEGRAM_UPDATES_MODE=polling, then queue updates with
+//
+//	curl localhost:8081/updates -d '{"message":{"text":"/help","chat":{"id":1,"type":"private"},"from":{"id":1,"first_name":"Dev"}}}'
+//
+// Every call the bot makes is printed.
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"tma/telegram/telegramtest"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8081", "listen address")
+	token := flag.String("token", "123456:fake-token", "bot token the server accepts")
+	flag.Parse()
+
+	server, err := telegramtest.NewServerAt(*token, *addr)
+	if err != nil {
+		slog.Error("Failed to start fake Bot API", "err", err)
+		os.Exit(1)
+	}
+	defer server.Close()
+
+	server.OnCall = func(c telegramtest.Call) {
+		slog.Info("Call", "method", c.Method, "params", string(c.Params))
+	}
+
+	slog.Info("Fake Bot API listening", "url", server.URL, "bot_token", *token)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+}
@@ -0,0 +1,190 @@
+package collab
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Timestamp is a Lamport timestamp. Ties between clients are broken by
+// client ID, so every replica orders any two writes the same way.
+type Timestamp struct {
+	Counter  int64  `json:"c"`
+	ClientID string `json:"id"`
+}
+
+// After reports whether t is ordered after u
+func (t Timestamp) After(u Timestamp) bool {
+	if t.Counter != u.Counter {
+		return t.Counter > u.Counter
+	}
+	return t.ClientID > u.ClientID
+}
+
+// Operation kinds
+const (
+	OpSet    = "set"
+	OpDelete = "delete"
+)
+
+// Operation writes or removes the value at Path in the json_data tree.
+// Path elements are object keys; arrays are treated as opaque values, so
+// clients should model reorderable content (such as blocks) as objects
+// keyed by a stable ID.
+type Operation struct {
+	Kind  string          `json:"kind"`
+	Path  []string        `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+	TS    Timestamp       `json:"ts"`
+}
+
+var errInvalidOp = errors.New("invalid operation")
+
+func (op Operation) validate() error {
+	switch op.Kind {
+	case OpSet:
+		if !json.Valid(op.Value) {
+			return errInvalidOp
+		}
+	case OpDelete:
+		if len(op.Path) == 0 {
+			return errInvalidOp
+		}
+	default:
+		return errInvalidOp
+	}
+	for _, p := range op.Path {
+		if p == "" || strings.ContainsRune(p, 0) {
+			return errInvalidOp
+		}
+	}
+	if op.TS.Counter < 1 || op.TS.ClientID == "" {
+		return errInvalidOp
+	}
+	return nil
+}
+
+// Document is a last-writer-wins register per path over a JSON tree.
+// A write is kept unless the same path or one of its ancestors was written
+// with a later timestamp. The visible state is the surviving writes applied
+// in timestamp order, which makes the result independent of the order in
+// which operations arrive: replicas that saw the same set of operations
+// converge to the same document.
+type Document struct {
+	writes map[string]Operation
+	clock  int64
+}
+
+// NewDocument creates a document whose initial content is treated as a
+// root write older than any client operation
+func NewDocument(initial json.RawMessage) *Document {
+	if len(initial) == 0 || string(initial) == "null" {
+		initial = json.RawMessage("{}")
+	}
+	return &Document{
+		writes: map[string]Operation{
+			"": {Kind: OpSet, Path: nil, Value: initial},
+		},
+	}
+}
+
+// Clock returns the highest Lamport counter seen so far
+func (d *Document) Clock() int64 {
+	return d.clock
+}
+
+// Apply merges op into the document. It reports whether the operation
+// changed anything; superseded operations are ignored. A counter more than
+// one past the clock is lowered to that first, so that no client can
+// claim a timestamp every later write loses to.
+func (d *Document) Apply(op *Operation) (bool, error) {
+	if err := op.validate(); err != nil {
+		return false, err
+	}
+	if op.TS.Counter > d.clock+1 {
+		op.TS.Counter = d.clock + 1
+	}
+	if op.TS.Counter > d.clock {
+		d.clock = op.TS.Counter
+	}
+
+	key := pathKey(op.Path)
+	for i := 0; i <= len(op.Path); i++ {
+		if w, ok := d.writes[pathKey(op.Path[:i])]; ok && !op.TS.After(w.TS) {
+			return false, nil
+		}
+	}
+
+	// Older writes below this path are now invisible, forget them
+	for k, w := range d.writes {
+		if isDescendant(k, key) && op.TS.After(w.TS) {
+			delete(d.writes, k)
+		}
+	}
+	d.writes[key] = *op
+	return true, nil
+}
+
+// JSON materializes the current document
+func (d *Document) JSON() (json.RawMessage, error) {
+	ops := make([]Operation, 0, len(d.writes))
+	for _, w := range d.writes {
+		ops = append(ops, w)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[j].TS.After(ops[i].TS) })
+
+	var root interface{}
+	for _, op := range ops {
+		var value interface{}
+		if op.Kind == OpSet {
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return nil, err
+			}
+		}
+		root = apply(root, op.Path, op.Kind, value)
+	}
+	if root == nil {
+		root = map[string]interface{}{}
+	}
+	return json.Marshal(root)
+}
+
+// apply writes value at path below node, creating or replacing
+// intermediate objects as needed, and returns the new node
+func apply(node interface{}, path []string, kind string, value interface{}) interface{} {
+	if len(path) == 0 {
+		if kind == OpDelete {
+			return nil
+		}
+		return value
+	}
+
+	obj, ok := node.(map[string]interface{})
+	if !ok {
+		if kind == OpDelete {
+			return node
+		}
+		obj = map[string]interface{}{}
+	}
+
+	if len(path) == 1 && kind == OpDelete {
+		delete(obj, path[0])
+		return obj
+	}
+	obj[path[0]] = apply(obj[path[0]], path[1:], kind, value)
+	return obj
+}
+
+// pathKey joins path elements with a separator that cannot appear in
+// JSON-decoded keys without escaping
+func pathKey(path []string) string {
+	return strings.Join(path, "\x00")
+}
+
+func isDescendant(key, ancestor string) bool {
+	if ancestor == "" {
+		return key != ""
+	}
+	return strings.HasPrefix(key, ancestor+"\x00")
+}
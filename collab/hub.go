@@ -0,0 +1,497 @@
+package collab
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"tma/ws"
+)
+
+const (
+	flushInterval = 5 * time.Second
+	flushTimeout  = 10 * time.Second
+	pingInterval  = 30 * time.Second
+	readTimeout   = 75 * time.Second
+	sendBuffer    = 64
+)
+
+// Presence states reported by clients
+const (
+	StateViewing = "viewing"
+	StateEditing = "editing"
+)
+
+// Participant describes a connected client in presence broadcasts
+type Participant struct {
+	ClientID   string `json:"client_id"`
+	UserID     int    `json:"user_id"`
+	TelegramID int64  `json:"telegram_id"`
+	State      string `json:"state"`
+	ReadOnly   bool   `json:"read_only,omitempty"`
+}
+
+// Message is the envelope for everything sent over the socket
+type Message struct {
+	Type         string          `json:"type"`
+	Op           *Operation      `json:"op,omitempty"`
+	From         string          `json:"from,omitempty"`
+	State        string          `json:"state,omitempty"`
+	Doc          json.RawMessage `json:"doc,omitempty"`
+	Clock        int64           `json:"clock,omitempty"`
+	ClientID     string          `json:"client_id,omitempty"`
+	Participants []Participant   `json:"participants,omitempty"`
+	Error        string          `json:"error,omitempty"`
+}
+
+// Hub owns one room per page being edited. Rooms live only while clients
+// are connected; their merged document is written back to pages.json_data
+// periodically and when the last client leaves. A write only succeeds if
+// the page is unchanged since the room loaded or last wrote it; otherwise
+// the room's unsaved operations are replayed on top of the stored page,
+// whether a REST update or another instance's room changed it.
+type Hub struct {
+	db *sql.DB
+
+	mu     sync.Mutex
+	rooms  map[int]*room
+	closed bool
+	once   sync.Once
+}
+
+func NewHub(db *sql.DB) *Hub {
+	return &Hub{
+		db:    db,
+		rooms: make(map[int]*room),
+	}
+}
+
+// Serve runs a client connection for the given page until it disconnects.
+// Read-only clients receive the document and presence but cannot send ops.
+// ctx only bounds loading the page.
+func (h *Hub) Serve(ctx context.Context, conn *ws.Conn, pageID, userID int, telegramID int64, readOnly bool) {
+	r, err := h.join(ctx, pageID)
+	if err != nil {
+		slog.Error("Failed to open collab page", "page_id", pageID, "err", err)
+		conn.WriteClose(ws.CloseGoingAway, "failed to load page")
+		conn.Close()
+		return
+	}
+
+	c := &client{
+		conn: conn,
+		send: make(chan []byte, sendBuffer),
+		participant: Participant{
+			ClientID:   newClientID(),
+			UserID:     userID,
+			TelegramID: telegramID,
+			State:      StateViewing,
+			ReadOnly:   readOnly,
+		},
+	}
+
+	// Deferred, so a panic in the read loop still releases the room
+	defer h.leave(pageID, r)
+
+	go c.writeLoop()
+	r.add(c)
+	defer func() {
+		r.remove(c)
+		close(c.send)
+	}()
+	c.readLoop(r)
+}
+
+// Close disconnects every client and persists the open rooms. The server
+// does not track hijacked connections, so its shutdown leaves them open.
+// Later connections are refused. Concurrent calls return once it is done.
+func (h *Hub) Close() {
+	h.once.Do(func() {
+		h.mu.Lock()
+		h.closed = true
+		rooms := make([]*room, 0, len(h.rooms))
+		for _, r := range h.rooms {
+			rooms = append(rooms, r)
+		}
+		h.mu.Unlock()
+
+		for _, r := range rooms {
+			r.disconnect()
+			r.stop()
+		}
+	})
+}
+
+func (h *Hub) join(ctx context.Context, pageID int) (*room, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, errors.New("hub is closed")
+	}
+	if r, ok := h.rooms[pageID]; ok {
+		r.refs++
+		return r, nil
+	}
+
+	var data sql.NullString
+	var version sql.NullTime
+	if err := h.db.QueryRowContext(ctx, `SELECT json_data, updated_at FROM pages WHERE id = $1`, pageID).Scan(&data, &version); err != nil {
+		return nil, err
+	}
+
+	r := newRoom(h.db, pageID, json.RawMessage(data.String), version)
+	r.refs = 1
+	h.rooms[pageID] = r
+	go r.flushLoop()
+	return r, nil
+}
+
+func (h *Hub) leave(pageID int, r *room) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r.refs--
+	if r.refs == 0 {
+		delete(h.rooms, pageID)
+		// Flush while holding the hub lock, so a client rejoining right
+		// away loads the merged document rather than a stale one
+		r.stop()
+	}
+}
+
+type room struct {
+	db     *sql.DB
+	pageID int
+	refs   int // guarded by Hub.mu
+
+	mu      sync.Mutex
+	doc     *Document
+	dirty   bool
+	clients map[*client]struct{}
+	// version is the updated_at of the page as last loaded or written,
+	// and pending the operations applied since
+	version sql.NullTime
+	pending []Operation
+
+	quit     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func newRoom(db *sql.DB, pageID int, data json.RawMessage, version sql.NullTime) *room {
+	return &room{
+		db:      db,
+		pageID:  pageID,
+		doc:     NewDocument(data),
+		version: version,
+		clients: make(map[*client]struct{}),
+		quit:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+func (r *room) add(c *client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.clients[c] = struct{}{}
+
+	doc, err := r.doc.JSON()
+	if err != nil {
+		slog.Error("Failed to materialize collab page", "page_id", r.pageID, "err", err)
+	}
+	c.sendMessage(Message{Type: "snapshot", Doc: doc, Clock: r.doc.Clock(), ClientID: c.participant.ClientID})
+	r.broadcastPresence()
+}
+
+func (r *room) remove(c *client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.clients, c)
+	r.broadcastPresence()
+}
+
+func (r *room) handle(c *client, msg Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch msg.Type {
+	case "op":
+		if c.participant.ReadOnly {
+			c.sendMessage(Message{Type: "error", Error: "read-only access"})
+			return
+		}
+		if msg.Op == nil {
+			c.sendMessage(Message{Type: "error", Error: "op is required"})
+			return
+		}
+		// Ties are broken by the ID the server gave the client, not one
+		// it picked
+		op := *msg.Op
+		op.TS.ClientID = c.participant.ClientID
+		changed, err := r.doc.Apply(&op)
+		if err != nil {
+			c.sendMessage(Message{Type: "error", Error: err.Error()})
+			return
+		}
+		if !changed {
+			return
+		}
+		r.dirty = true
+		r.pending = append(r.pending, op)
+		// Echo to the sender too, so it knows the op was accepted and the
+		// timestamp it was given
+		r.broadcast(Message{Type: "op", Op: &op, From: c.participant.ClientID, Clock: r.doc.Clock()})
+
+	case "presence":
+		if msg.State != StateViewing && msg.State != StateEditing {
+			c.sendMessage(Message{Type: "error", Error: "unknown presence state"})
+			return
+		}
+		if msg.State == StateEditing && c.participant.ReadOnly {
+			c.sendMessage(Message{Type: "error", Error: "read-only access"})
+			return
+		}
+		c.participant.State = msg.State
+		r.broadcastPresence()
+
+	default:
+		c.sendMessage(Message{Type: "error", Error: "unknown message type"})
+	}
+}
+
+// broadcastPresence must be called with r.mu held
+func (r *room) broadcastPresence() {
+	participants := make([]Participant, 0, len(r.clients))
+	for c := range r.clients {
+		participants = append(participants, c.participant)
+	}
+	r.broadcast(Message{Type: "presence", Participants: participants})
+}
+
+// broadcast must be called with r.mu held
+func (r *room) broadcast(msg Message) {
+	for c := range r.clients {
+		c.sendMessage(msg)
+	}
+}
+
+func (r *room) flushLoop() {
+	defer close(r.stopped)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.flush()
+		case <-r.quit:
+			r.flush()
+			return
+		}
+	}
+}
+
+// disconnect closes every client connection. Clients reconnect, possibly
+// to another instance, and load the document persisted by stop.
+func (r *room) disconnect() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closeClients("server shutting down")
+}
+
+// closeClients must be called with r.mu held
+func (r *room) closeClients(reason string) {
+	for c := range r.clients {
+		c.conn.WriteClose(ws.CloseGoingAway, reason)
+		c.conn.Close()
+	}
+}
+
+// stop ends the flush loop after a final flush
+func (r *room) stop() {
+	r.stopOnce.Do(func() { close(r.quit) })
+	<-r.stopped
+}
+
+// flush writes the document back. If the page changed in the meantime,
+// the document is rebuilt on top of it and written once more.
+func (r *room) flush() {
+	for attempt := 0; attempt < 2; attempt++ {
+		if !r.write() || !r.reload() {
+			return
+		}
+	}
+}
+
+// write persists the document if it has unsaved operations and reports
+// whether the page changed since the room loaded or last wrote it
+func (r *room) write() (conflict bool) {
+	r.mu.Lock()
+	if !r.dirty {
+		r.mu.Unlock()
+		return false
+	}
+	doc, err := r.doc.JSON()
+	version := r.version
+	written := len(r.pending)
+	r.dirty = false
+	r.mu.Unlock()
+
+	if err != nil {
+		slog.Error("Failed to materialize collab page", "page_id", r.pageID, "err", err)
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	var stored sql.NullTime
+	err = r.db.QueryRowContext(ctx, `
+		UPDATE pages SET json_data = $1, updated_at = $2
+		WHERE id = $3 AND updated_at IS NOT DISTINCT FROM $4
+		RETURNING updated_at
+	`, string(doc), time.Now(), r.pageID, version).Scan(&stored)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err != nil {
+		r.dirty = true
+		if errors.Is(err, sql.ErrNoRows) {
+			return true
+		}
+		slog.Error("Failed to persist collab page", "page_id", r.pageID, "err", err)
+		return false
+	}
+	r.version = stored
+	// Operations applied while writing stay pending. Only the flush loop
+	// replaces r.pending, so the first written ones are those written.
+	r.pending = r.pending[written:]
+	return false
+}
+
+// reload rebuilds the document from the stored page with the pending
+// operations replayed on top, and sends it to every client. It reports
+// whether the room can go on writing the page.
+func (r *room) reload() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	var data sql.NullString
+	var version sql.NullTime
+	err := r.db.QueryRowContext(ctx, `SELECT json_data, updated_at FROM pages WHERE id = $1`, r.pageID).Scan(&data, &version)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if errors.Is(err, sql.ErrNoRows) {
+		// The page is gone, there is nothing to edit any more
+		r.dirty = false
+		r.pending = nil
+		r.closeClients("page deleted")
+		return false
+	}
+	if err != nil {
+		slog.Error("Failed to reload collab page", "page_id", r.pageID, "err", err)
+		return false
+	}
+
+	doc := NewDocument(json.RawMessage(data.String))
+	doc.clock = r.doc.clock
+	for i := range r.pending {
+		doc.Apply(&r.pending[i])
+	}
+	r.doc = doc
+	r.version = version
+	r.dirty = len(r.pending) > 0
+
+	merged, err := doc.JSON()
+	if err != nil {
+		slog.Error("Failed to materialize collab page", "page_id", r.pageID, "err", err)
+		return false
+	}
+	r.broadcast(Message{Type: "snapshot", Doc: merged, Clock: doc.Clock()})
+	return true
+}
+
+type client struct {
+	conn        *ws.Conn
+	send        chan []byte
+	participant Participant // guarded by room.mu
+}
+
+// sendMessage queues msg without blocking. A client that cannot keep up
+// is disconnected rather than stalling the whole room.
+func (c *client) sendMessage(msg Message) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	select {
+	case c.send <- data:
+	default:
+		c.conn.Close()
+	}
+}
+
+func (c *client) readLoop(r *room) {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		opcode, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if opcode != ws.OpText {
+			continue
+		}
+
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			r.mu.Lock()
+			c.sendMessage(Message{Type: "error", Error: "invalid message"})
+			r.mu.Unlock()
+			continue
+		}
+		r.handle(c, msg)
+	}
+}
+
+func (c *client) writeLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	defer c.conn.Close()
+
+	for {
+		select {
+		case data, ok := <-c.send:
+			if !ok {
+				c.conn.WriteClose(ws.CloseNormal, "")
+				return
+			}
+			if err := c.conn.WriteMessage(ws.OpText, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteMessage(ws.OpPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func newClientID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -1,9 +1,14 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	_ "github.com/lib/pq"
 )
@@ -12,27 +17,63 @@ type Database struct {
 	DB *sql.DB
 }
 
-func New(databaseURL string) (*Database, error) {
-	db, err := sql.Open("postgres", databaseURL)
+// Options configures the connection pool. Zero values keep the defaults
+// of database/sql.
+type Options struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// StatementTimeout is the statement_timeout of every connection, so
+	// Postgres aborts statements nobody will wait for. 0 disables it.
+	StatementTimeout time.Duration
+}
+
+// New connects to the database and applies pending migrations
+func New(databaseURL string, opts Options) (*Database, error) {
+	d, err := Open(databaseURL, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to database: %w", err)
+		return nil, err
 	}
 
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+	migrator, err := NewMigrator(d.DB)
+	if err != nil {
+		d.Close()
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+	applied, err := migrator.Up(context.Background())
+	if err != nil {
+		d.Close()
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
 	}
+	slog.Info("Database schema is up to date", "applied", applied)
 
-	log.Println("Successfully connected to PostgreSQL database")
+	return d, nil
+}
 
-	// Initialize database tables
-	if err := initTables(db); err != nil {
-		return nil, fmt.Errorf("failed to initialize tables: %w", err)
+// Open connects to the database without touching its schema
+func Open(databaseURL string, opts Options) (*Database, error) {
+	if opts.StatementTimeout > 0 {
+		databaseURL = withParam(databaseURL, "statement_timeout", strconv.FormatInt(opts.StatementTimeout.Milliseconds(), 10))
 	}
 
-	// Run migrations
-	if err := runMigrations(db); err != nil {
-		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	if opts.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(opts.MaxIdleConns)
 	}
+	db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	slog.Info("Connected to PostgreSQL database")
 
 	return &Database{DB: db}, nil
 }
@@ -41,81 +82,18 @@ func (d *Database) Close() error {
 	return d.DB.Close()
 }
 
-func initTables(db *sql.DB) error {
-	// Create users table
-	createUsersTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		id SERIAL PRIMARY KEY,
-		telegram_id BIGINT UNIQUE NOT NULL,
-		username VARCHAR(255),
-		first_name VARCHAR(255),
-		last_name VARCHAR(255),
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);`
-
-	// Create user_sessions table for JWT tokens
-	createSessionsTable := `
-	CREATE TABLE IF NOT EXISTS user_sessions (
-		id SERIAL PRIMARY KEY,
-		user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
-		token_hash VARCHAR(255) NOT NULL,
-		expires_at TIMESTAMP NOT NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);`
-
-	// Create pages table (example for your app)
-	createPagesTable := `
-	CREATE TABLE IF NOT EXISTS pages (
-		id SERIAL PRIMARY KEY,
-		user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
-		title VARCHAR(255) NOT NULL,
-		json_data JSONB,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);`
-
-	tables := []string{createUsersTable, createSessionsTable, createPagesTable}
-
-	for _, table := range tables {
-		if _, err := db.Exec(table); err != nil {
-			return fmt.Errorf("failed to create table: %w", err)
+// withParam adds a connection parameter to a database URL or key=value
+// connection string. lib/pq passes parameters it does not know to the
+// server as run-time settings.
+func withParam(databaseURL, key, value string) string {
+	if strings.HasPrefix(databaseURL, "postgres://") || strings.HasPrefix(databaseURL, "postgresql://") {
+		u, err := url.Parse(databaseURL)
+		if err == nil {
+			q := u.Query()
+			q.Set(key, value)
+			u.RawQuery = q.Encode()
+			return u.String()
 		}
 	}
-
-	log.Println("Database tables initialized successfully")
-	return nil
-}
-
-func runMigrations(db *sql.DB) error {
-	log.Println("Running database migrations...")
-
-	// Migration 1: Add json_data column to pages table if it doesn't exist
-	addJSONDataColumn := `
-	DO $$ 
-	BEGIN 
-		-- Check if pages table exists
-		IF EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'pages') THEN
-			-- Check if json_data column exists
-			IF NOT EXISTS (
-				SELECT 1 FROM information_schema.columns 
-				WHERE table_name = 'pages' AND column_name = 'json_data'
-			) THEN 
-				ALTER TABLE pages ADD COLUMN json_data JSONB;
-				RAISE NOTICE 'Added json_data column to pages table';
-			ELSE 
-				RAISE NOTICE 'json_data column already exists in pages table';
-			END IF;
-		ELSE
-			RAISE NOTICE 'pages table does not exist, will be created by initTables';
-		END IF;
-	END $$;
-	`
-
-	if _, err := db.Exec(addJSONDataColumn); err != nil {
-		return fmt.Errorf("failed to add json_data column: %w", err)
-	}
-
-	log.Println("Database migrations completed successfully")
-	return nil
+	return databaseURL + " " + key + "=" + value
 }
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+// StatusClientClosedRequest is the nginx status for requests whose client
+// went away before the response was written
+const StatusClientClosedRequest = 499
+
+// HTTPStatus maps the error of a database call made for a request to the
+// status of its response: 499 when the client went away, 504 when the
+// request or statement ran out of time, 503 when the database cannot be
+// reached, and 500 for everything else. ctx is the request context; a
+// cancelled query reports a server error, so its cause is read from ctx.
+func HTTPStatus(ctx context.Context, err error) int {
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled), errors.Is(err, context.Canceled):
+		return StatusClientClosedRequest
+	case errors.Is(ctx.Err(), context.DeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch {
+		case pqErr.Code == "57014": // query_canceled, also raised by statement_timeout
+			return http.StatusGatewayTimeout
+		case pqErr.Code.Class() == "08", // connection_exception
+			pqErr.Code == "53300", // too_many_connections
+			pqErr.Code == "57P01", // admin_shutdown
+			pqErr.Code == "57P03": // cannot_connect_now
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusInternalServerError
+	}
+
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.As(err, &netErr) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync"
+	"time"
+
+	"tma/metrics"
+)
+
+// The number of pages is counted at most once per pageCountInterval,
+// however often metrics are scraped, and each count is bounded by
+// pageCountTimeout
+const (
+	pageCountInterval = time.Minute
+	pageCountTimeout  = 2 * time.Second
+)
+
+// RegisterMetrics exposes the connection pool statistics of db and the
+// number of pages. It must be called once.
+func RegisterMetrics(db *sql.DB) {
+	metrics.NewGaugeFunc("tma_db_connections", "Open connections by state.", []string{"state"},
+		func(emit func(float64, ...string)) {
+			stats := db.Stats()
+			emit(float64(stats.InUse), "in_use")
+			emit(float64(stats.Idle), "idle")
+		})
+	metrics.NewGaugeFunc("tma_db_max_open_connections", "Maximum number of open connections, 0 is unlimited.", nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(db.Stats().MaxOpenConnections))
+		})
+	metrics.NewCounterFunc("tma_db_wait_total", "Connections waited for because the pool was exhausted.", nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(db.Stats().WaitCount))
+		})
+	metrics.NewCounterFunc("tma_db_wait_seconds_total", "Time spent waiting for a connection.", nil,
+		func(emit func(float64, ...string)) {
+			emit(db.Stats().WaitDuration.Seconds())
+		})
+	metrics.NewCounterFunc("tma_db_connections_closed_total", "Connections closed by the pool by reason.", []string{"reason"},
+		func(emit func(float64, ...string)) {
+			stats := db.Stats()
+			emit(float64(stats.MaxIdleClosed), "max_idle")
+			emit(float64(stats.MaxIdleTimeClosed), "max_idle_time")
+			emit(float64(stats.MaxLifetimeClosed), "max_lifetime")
+		})
+
+	pages := &pageCounter{db: db}
+	metrics.NewGaugeFunc("tma_pages", "Number of pages, counted at most once a minute.", nil,
+		func(emit func(float64, ...string)) {
+			if count, ok := pages.get(); ok {
+				emit(float64(count))
+			}
+		})
+}
+
+// pageCounter caches the number of pages between scrapes
+type pageCounter struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	count     int64
+	countedAt time.Time
+}
+
+// get returns the number of pages, counting them again when the last
+// count is too old. A failed count keeps the previous one.
+func (p *pageCounter) get() (int64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if time.Since(p.countedAt) < pageCountInterval {
+		return p.count, true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pageCountTimeout)
+	defer cancel()
+
+	var count int64
+	if err := p.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM pages`).Scan(&count); err != nil {
+		slog.Error("Failed to count pages", "err", err)
+		return p.count, !p.countedAt.IsZero()
+	}
+	p.count, p.countedAt = count, time.Now()
+	return count, true
+}
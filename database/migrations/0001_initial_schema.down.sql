@@ -0,0 +1,19 @@
+DROP TABLE IF EXISTS
+	purchases,
+	notification_preferences,
+	notification_outbox,
+	telegram_poll_state,
+	telegram_updates,
+	page_invites,
+	page_members,
+	workspace_members,
+	workspaces,
+	page_changes,
+	asset_variants,
+	page_assets,
+	assets,
+	page_view_rollups,
+	page_view_events,
+	pages,
+	user_sessions,
+	users;
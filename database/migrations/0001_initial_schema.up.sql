@@ -0,0 +1,200 @@
+-- Tables of the schema before migrations were versioned. Every statement
+-- is idempotent, so databases created by earlier releases adopt it as is.
+
+-- Create users table
+CREATE TABLE IF NOT EXISTS users (
+	id SERIAL PRIMARY KEY,
+	telegram_id BIGINT UNIQUE NOT NULL,
+	username VARCHAR(255),
+	first_name VARCHAR(255),
+	last_name VARCHAR(255),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create user_sessions table for JWT tokens
+CREATE TABLE IF NOT EXISTS user_sessions (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+	token_hash VARCHAR(255) NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create pages table (example for your app)
+CREATE TABLE IF NOT EXISTS pages (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+	title VARCHAR(255) NOT NULL,
+	json_data JSONB,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+-- The first releases created pages without json_data
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS json_data JSONB;
+
+-- Append-only log of page views, written by the analytics recorder
+CREATE TABLE IF NOT EXISTS page_view_events (
+	id BIGSERIAL PRIMARY KEY,
+	page_id INTEGER REFERENCES pages(id) ON DELETE CASCADE,
+	visitor_id VARCHAR(64) NOT NULL,
+	referrer VARCHAR(255) NOT NULL DEFAULT '',
+	platform VARCHAR(32) NOT NULL DEFAULT '',
+	start_param VARCHAR(64) NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS page_view_events_page_id_created_at_idx
+	ON page_view_events (page_id, created_at);
+
+-- Hourly view counters maintained alongside page_view_events
+CREATE TABLE IF NOT EXISTS page_view_rollups (
+	page_id INTEGER REFERENCES pages(id) ON DELETE CASCADE,
+	bucket TIMESTAMP NOT NULL,
+	views BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (page_id, bucket)
+);
+
+-- Uploaded files; the content itself lives in the storage backend
+CREATE TABLE IF NOT EXISTS assets (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+	storage_key VARCHAR(255) UNIQUE NOT NULL,
+	filename VARCHAR(255) NOT NULL DEFAULT '',
+	content_type VARCHAR(100) NOT NULL,
+	size BIGINT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS page_assets (
+	page_id INTEGER REFERENCES pages(id) ON DELETE CASCADE,
+	asset_id INTEGER REFERENCES assets(id) ON DELETE CASCADE,
+	PRIMARY KEY (page_id, asset_id)
+);
+
+-- Resized copies of image assets produced by the imaging processor
+CREATE TABLE IF NOT EXISTS asset_variants (
+	id SERIAL PRIMARY KEY,
+	asset_id INTEGER REFERENCES assets(id) ON DELETE CASCADE,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	storage_key VARCHAR(255) UNIQUE NOT NULL,
+	content_type VARCHAR(100) NOT NULL,
+	size BIGINT NOT NULL,
+	UNIQUE (asset_id, width)
+);
+
+-- Change feed for SSE clients, filled by the pages trigger. There are
+-- no foreign keys: events must outlive the pages they describe.
+CREATE TABLE IF NOT EXISTS page_changes (
+	id BIGSERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	page_id INTEGER NOT NULL,
+	kind VARCHAR(16) NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS page_changes_user_id_id_idx ON page_changes (user_id, id);
+
+-- Workspaces own pages. Every user has exactly one personal workspace.
+CREATE TABLE IF NOT EXISTS workspaces (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	personal BOOLEAN NOT NULL DEFAULT FALSE,
+	owner_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS workspaces_personal_owner_idx ON workspaces (owner_id) WHERE personal;
+
+CREATE TABLE IF NOT EXISTS workspace_members (
+	workspace_id INTEGER REFERENCES workspaces(id) ON DELETE CASCADE,
+	user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+	role VARCHAR(16) NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (workspace_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS workspace_members_user_id_idx ON workspace_members (user_id);
+
+-- Users a page is shared with outside of its workspace
+CREATE TABLE IF NOT EXISTS page_members (
+	page_id INTEGER REFERENCES pages(id) ON DELETE CASCADE,
+	user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+	role VARCHAR(16) NOT NULL,
+	invited_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (page_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS page_members_user_id_idx ON page_members (user_id);
+
+-- Shareable invite links. max_uses NULL means unlimited.
+CREATE TABLE IF NOT EXISTS page_invites (
+	id SERIAL PRIMARY KEY,
+	page_id INTEGER REFERENCES pages(id) ON DELETE CASCADE,
+	token VARCHAR(64) UNIQUE NOT NULL,
+	role VARCHAR(16) NOT NULL,
+	created_by INTEGER REFERENCES users(id) ON DELETE CASCADE,
+	expires_at TIMESTAMP,
+	max_uses INTEGER,
+	uses INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Update IDs already processed by the bot, so redelivered updates are
+-- handled once
+CREATE TABLE IF NOT EXISTS telegram_updates (
+	update_id BIGINT PRIMARY KEY,
+	received_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- getUpdates offset of the long-polling runner, per bot
+CREATE TABLE IF NOT EXISTS telegram_poll_state (
+	bot_id BIGINT PRIMARY KEY,
+	next_offset BIGINT NOT NULL,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Notifications waiting to be sent by the bot. Rows are kept for a
+-- while after sending so a restart never sends a message twice.
+CREATE TABLE IF NOT EXISTS notification_outbox (
+	id BIGSERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	kind VARCHAR(32) NOT NULL,
+	text TEXT NOT NULL,
+	page_id INTEGER REFERENCES pages(id) ON DELETE SET NULL,
+	status VARCHAR(16) NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	send_after TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	claimed_at TIMESTAMP,
+	sent_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS notification_outbox_pending_idx ON notification_outbox (send_after) WHERE status IN ('pending', 'sending');
+CREATE INDEX IF NOT EXISTS notification_outbox_user_id_idx ON notification_outbox (user_id, status);
+
+-- How each user wants to receive each kind of notification. Kinds
+-- without a row are delivered instantly.
+CREATE TABLE IF NOT EXISTS notification_preferences (
+	user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+	kind VARCHAR(32) NOT NULL,
+	delivery VARCHAR(16) NOT NULL,
+	PRIMARY KEY (user_id, kind)
+);
+
+-- Purchases of paid pages in Telegram Stars. Rows outlive their page
+-- and buyer, the ledger keeps what was sold and for how much.
+CREATE TABLE IF NOT EXISTS purchases (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	telegram_user_id BIGINT NOT NULL,
+	page_id INTEGER REFERENCES pages(id) ON DELETE SET NULL,
+	title VARCHAR(255) NOT NULL,
+	amount INTEGER NOT NULL,
+	currency VARCHAR(8) NOT NULL,
+	status VARCHAR(16) NOT NULL DEFAULT 'pending',
+	payload VARCHAR(64) UNIQUE NOT NULL,
+	telegram_charge_id VARCHAR(255) UNIQUE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	paid_at TIMESTAMP,
+	refunded_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS purchases_user_id_idx ON purchases (user_id, page_id);
+CREATE INDEX IF NOT EXISTS purchases_page_id_idx ON purchases (page_id);
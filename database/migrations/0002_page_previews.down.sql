@@ -0,0 +1,2 @@
+ALTER TABLE pages DROP COLUMN IF EXISTS description;
+ALTER TABLE pages DROP COLUMN IF EXISTS cover_image;
@@ -0,0 +1,3 @@
+-- Link preview metadata for pages
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS cover_image TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS assets_status_idx;
+ALTER TABLE assets DROP COLUMN IF EXISTS processing_started_at;
+ALTER TABLE assets DROP COLUMN IF EXISTS status;
+ALTER TABLE assets DROP COLUMN IF EXISTS dominant_color;
+ALTER TABLE assets DROP COLUMN IF EXISTS blurhash;
+ALTER TABLE assets DROP COLUMN IF EXISTS height;
+ALTER TABLE assets DROP COLUMN IF EXISTS width;
@@ -0,0 +1,9 @@
+-- Image processing results for assets. Existing assets start out pending,
+-- so the processor backfills them.
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS width INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS height INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS blurhash VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS dominant_color VARCHAR(7) NOT NULL DEFAULT '';
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'pending';
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS processing_started_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS assets_status_idx ON assets (status) WHERE status IN ('pending', 'processing');
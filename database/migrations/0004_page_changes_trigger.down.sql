@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS pages_record_delete ON pages;
+DROP TRIGGER IF EXISTS pages_record_change ON pages;
+DROP FUNCTION IF EXISTS record_page_change();
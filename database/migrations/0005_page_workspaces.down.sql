@@ -0,0 +1,42 @@
+-- Pages stay with the users who created them. Workspaces themselves
+-- belong to the initial schema and are kept.
+--
+-- The page_changes trigger of 0004 reads pages.workspace_id, so it goes
+-- back to notifying the page's creator and members before the column is
+-- dropped.
+CREATE OR REPLACE FUNCTION record_page_change() RETURNS trigger AS $$
+DECLARE
+	changed RECORD;
+	change_kind TEXT;
+	recipient INTEGER;
+BEGIN
+	IF TG_OP = 'INSERT' THEN
+		changed := NEW;
+		change_kind := 'created';
+	ELSIF TG_OP = 'UPDATE' THEN
+		changed := NEW;
+		change_kind := 'updated';
+	ELSE
+		changed := OLD;
+		change_kind := 'deleted';
+	END IF;
+
+	FOR recipient IN
+		SELECT changed.user_id WHERE changed.user_id IS NOT NULL
+		UNION
+		SELECT m.user_id FROM page_members m WHERE m.page_id = changed.id
+	LOOP
+		INSERT INTO page_changes (user_id, page_id, kind)
+		VALUES (recipient, changed.id, change_kind);
+		PERFORM pg_notify('page_changes', recipient::text);
+	END LOOP;
+
+	IF TG_WHEN = 'BEFORE' THEN
+		RETURN OLD;
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP INDEX IF EXISTS pages_workspace_id_idx;
+ALTER TABLE pages DROP COLUMN IF EXISTS workspace_id;
@@ -0,0 +1,16 @@
+-- Move pages from users into workspaces. Every existing user gets a
+-- personal workspace that takes over their pages.
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS workspace_id INTEGER REFERENCES workspaces(id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS pages_workspace_id_idx ON pages (workspace_id);
+
+INSERT INTO workspaces (name, personal, owner_id)
+SELECT 'Personal', TRUE, u.id FROM users u
+WHERE NOT EXISTS (SELECT 1 FROM workspaces w WHERE w.personal AND w.owner_id = u.id);
+
+INSERT INTO workspace_members (workspace_id, user_id, role)
+SELECT id, owner_id, 'owner' FROM workspaces WHERE personal
+ON CONFLICT DO NOTHING;
+
+UPDATE pages p SET workspace_id = w.id
+FROM workspaces w
+WHERE p.workspace_id IS NULL AND w.personal AND w.owner_id = p.user_id;
@@ -0,0 +1 @@
+ALTER TABLE users DROP COLUMN IF EXISTS allows_write_to_pm;
@@ -0,0 +1,3 @@
+-- Whether the bot may message a user, as reported by allows_write_to_pm
+-- in init_data
+ALTER TABLE users ADD COLUMN IF NOT EXISTS allows_write_to_pm BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,2 @@
+-- Price of paid pages in Telegram Stars, 0 for free pages
+ALTER TABLE pages ADD COLUMN IF NOT EXISTS price_stars INTEGER NOT NULL DEFAULT 0;
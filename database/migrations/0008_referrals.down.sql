@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS users_referred_by_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS signup_campaign;
+ALTER TABLE users DROP COLUMN IF EXISTS referred_by;
+ALTER TABLE users DROP COLUMN IF EXISTS referral_code;
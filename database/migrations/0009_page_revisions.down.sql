@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS pages_record_revision ON pages;
+DROP FUNCTION IF EXISTS record_page_revision();
+DROP TABLE IF EXISTS page_revisions;
@@ -0,0 +1,50 @@
+-- Earlier content of pages. The trigger below keeps the content a page had
+-- before an edit, at most once per 5 minutes so that the collaborative
+-- editor flushing every few seconds does not flood the table, and only
+-- the newest 50 revisions of each page.
+CREATE TABLE IF NOT EXISTS page_revisions (
+	id BIGSERIAL PRIMARY KEY,
+	page_id INTEGER NOT NULL REFERENCES pages(id) ON DELETE CASCADE,
+	title VARCHAR(255) NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	cover_image TEXT NOT NULL DEFAULT '',
+	json_data JSONB,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS page_revisions_page_id_idx ON page_revisions (page_id, created_at);
+
+CREATE OR REPLACE FUNCTION record_page_revision() RETURNS trigger AS $$
+BEGIN
+	IF NEW.title IS NOT DISTINCT FROM OLD.title
+		AND NEW.description IS NOT DISTINCT FROM OLD.description
+		AND NEW.cover_image IS NOT DISTINCT FROM OLD.cover_image
+		AND NEW.json_data IS NOT DISTINCT FROM OLD.json_data THEN
+		RETURN NULL;
+	END IF;
+
+	IF EXISTS (
+		SELECT 1 FROM page_revisions
+		WHERE page_id = OLD.id AND created_at > CURRENT_TIMESTAMP - INTERVAL '5 minutes'
+	) THEN
+		RETURN NULL;
+	END IF;
+
+	INSERT INTO page_revisions (page_id, title, description, cover_image, json_data, created_at)
+	VALUES (OLD.id, OLD.title, OLD.description, OLD.cover_image, OLD.json_data, COALESCE(OLD.updated_at, CURRENT_TIMESTAMP));
+
+	DELETE FROM page_revisions
+	WHERE page_id = OLD.id AND id NOT IN (
+		SELECT id FROM page_revisions WHERE page_id = OLD.id
+		ORDER BY created_at DESC, id DESC
+		LIMIT 50
+	);
+
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS pages_record_revision ON pages;
+CREATE TRIGGER pages_record_revision
+	AFTER UPDATE ON pages
+	FOR EACH ROW EXECUTE PROCEDURE record_page_revision();
@@ -0,0 +1,36 @@
+-- Back to recording every write, as 0004 does
+DROP INDEX IF EXISTS page_changes_page_id_created_at_idx;
+
+CREATE OR REPLACE FUNCTION record_page_change() RETURNS trigger AS $$
+DECLARE
+	changed RECORD;
+	change_kind TEXT;
+	recipient INTEGER;
+BEGIN
+	IF TG_OP = 'INSERT' THEN
+		changed := NEW;
+		change_kind := 'created';
+	ELSIF TG_OP = 'UPDATE' THEN
+		changed := NEW;
+		change_kind := 'updated';
+	ELSE
+		changed := OLD;
+		change_kind := 'deleted';
+	END IF;
+
+	FOR recipient IN
+		SELECT wm.user_id FROM workspace_members wm WHERE wm.workspace_id = changed.workspace_id
+		UNION
+		SELECT m.user_id FROM page_members m WHERE m.page_id = changed.id
+	LOOP
+		INSERT INTO page_changes (user_id, page_id, kind)
+		VALUES (recipient, changed.id, change_kind);
+		PERFORM pg_notify('page_changes', recipient::text);
+	END LOOP;
+
+	IF TG_WHEN = 'BEFORE' THEN
+		RETURN OLD;
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,69 @@
+-- Make page_changes safe to read with "id > last seen id".
+--
+-- IDs come from a sequence when the row is inserted, not when it commits,
+-- so a transaction that took a lower ID could commit after a reader had
+-- already moved past it. Writers now hold a transaction advisory lock
+-- (0x746d6170, "tmap") from before taking an ID until they commit, so IDs
+-- become visible in order.
+--
+-- Updates that change nothing visible are not recorded, and an update of
+-- a page its recipient got an "updated" event for within the last minute
+-- replaces that event, so a page being edited collaboratively does not
+-- add a row per member every few seconds.
+CREATE INDEX IF NOT EXISTS page_changes_page_id_created_at_idx ON page_changes (page_id, created_at);
+
+CREATE OR REPLACE FUNCTION record_page_change() RETURNS trigger AS $$
+DECLARE
+	changed RECORD;
+	change_kind TEXT;
+	recipients INTEGER[];
+	recipient INTEGER;
+BEGIN
+	IF TG_OP = 'INSERT' THEN
+		changed := NEW;
+		change_kind := 'created';
+	ELSIF TG_OP = 'UPDATE' THEN
+		IF NEW.title IS NOT DISTINCT FROM OLD.title
+			AND NEW.description IS NOT DISTINCT FROM OLD.description
+			AND NEW.cover_image IS NOT DISTINCT FROM OLD.cover_image
+			AND NEW.json_data IS NOT DISTINCT FROM OLD.json_data
+			AND NEW.price_stars IS NOT DISTINCT FROM OLD.price_stars
+			AND NEW.workspace_id IS NOT DISTINCT FROM OLD.workspace_id THEN
+			RETURN NULL;
+		END IF;
+		changed := NEW;
+		change_kind := 'updated';
+	ELSE
+		changed := OLD;
+		change_kind := 'deleted';
+	END IF;
+
+	PERFORM pg_advisory_xact_lock(x'746d6170'::int);
+
+	SELECT array_agg(r.user_id) INTO recipients FROM (
+		SELECT wm.user_id FROM workspace_members wm WHERE wm.workspace_id = changed.workspace_id
+		UNION
+		SELECT m.user_id FROM page_members m WHERE m.page_id = changed.id
+	) r;
+
+	IF change_kind = 'updated' THEN
+		DELETE FROM page_changes
+		WHERE page_id = changed.id
+			AND kind = 'updated'
+			AND created_at > CURRENT_TIMESTAMP - INTERVAL '1 minute'
+			AND user_id = ANY(recipients);
+	END IF;
+
+	FOREACH recipient IN ARRAY COALESCE(recipients, '{}')
+	LOOP
+		INSERT INTO page_changes (user_id, page_id, kind)
+		VALUES (recipient, changed.id, change_kind);
+		PERFORM pg_notify('page_changes', recipient::text);
+	END LOOP;
+
+	IF TG_WHEN = 'BEFORE' THEN
+		RETURN OLD;
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS purchases_pending_idx;
+ALTER TABLE purchases DROP COLUMN IF EXISTS invoice_link;
+DROP TRIGGER IF EXISTS pages_keep_purchase_workspace ON pages;
+DROP FUNCTION IF EXISTS keep_purchase_workspace();
+ALTER TABLE purchases DROP COLUMN IF EXISTS workspace_id;
@@ -0,0 +1,28 @@
+-- Purchases outlive their pages. The workspace of a deleted page is kept
+-- on its purchases so that the workspace admins can still refund them.
+-- There is no foreign key: the workspace may be deleted as well.
+ALTER TABLE purchases ADD COLUMN IF NOT EXISTS workspace_id INTEGER;
+
+CREATE OR REPLACE FUNCTION keep_purchase_workspace() RETURNS trigger AS $$
+BEGIN
+	UPDATE purchases SET workspace_id = OLD.workspace_id WHERE page_id = OLD.id;
+	RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS pages_keep_purchase_workspace ON pages;
+CREATE TRIGGER pages_keep_purchase_workspace
+	BEFORE DELETE ON pages
+	FOR EACH ROW EXECUTE PROCEDURE keep_purchase_workspace();
+
+-- An open invoice is offered again instead of a new one, so a user has at
+-- most one pending purchase of a page. Older duplicates were never paid.
+ALTER TABLE purchases ADD COLUMN IF NOT EXISTS invoice_link TEXT;
+
+DELETE FROM purchases a
+USING purchases b
+WHERE a.status = 'pending' AND b.status = 'pending'
+	AND a.user_id = b.user_id AND a.page_id = b.page_id AND a.id < b.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS purchases_pending_idx
+	ON purchases (user_id, page_id) WHERE status = 'pending';
@@ -0,0 +1,190 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"tma/health"
+)
+
+// Channel is the Postgres NOTIFY channel the pages trigger publishes on.
+// The payload is the ID of the user whose pages changed.
+const Channel = "page_changes"
+
+const (
+	// Retention is how long change events are kept for Last-Event-ID resume
+	Retention      = 7 * 24 * time.Hour
+	pruneInterval  = time.Hour
+	pruneTimeout   = time.Minute
+	listenerPing   = 90 * time.Second
+	minReconnect   = time.Second
+	maxReconnect   = time.Minute
+	subscriberWake = 1
+)
+
+// Broker fans change notifications out to subscribers on this instance.
+// Every instance listens on the same channel, so a change committed
+// through any instance reaches all connected clients. Notifications only
+// wake subscribers; the events themselves are always read from the
+// page_changes table, whose IDs become visible in order, which keeps
+// delivery ordered and gap-free.
+type Broker struct {
+	db       *sql.DB
+	listener *pq.Listener
+
+	mu   sync.Mutex
+	subs map[int]map[*Subscription]struct{}
+
+	heartbeat health.Heartbeat
+	stop      chan struct{}
+	done      chan struct{}
+	once      sync.Once
+}
+
+// Subscription receives a signal whenever the user's pages may have changed
+type Subscription struct {
+	UserID int
+	C      chan struct{}
+}
+
+func NewBroker(db *sql.DB, databaseURL string) *Broker {
+	listener := pq.NewListener(databaseURL, minReconnect, maxReconnect, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Warn("Page change listener event", "event", int(ev), "err", err)
+		}
+	})
+
+	return &Broker{
+		db:       db,
+		listener: listener,
+		subs:     make(map[int]map[*Subscription]struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Run listens for notifications until Close is called
+func (b *Broker) Run() {
+	defer close(b.done)
+
+	if err := b.listener.Listen(Channel); err != nil {
+		slog.Error("Failed to listen for page changes", "channel", Channel, "err", err)
+	}
+
+	ping := time.NewTicker(listenerPing)
+	defer ping.Stop()
+	prune := time.NewTicker(pruneInterval)
+	defer prune.Stop()
+
+	for {
+		b.heartbeat.Beat()
+		select {
+		case <-b.stop:
+			return
+		case n := <-b.listener.Notify:
+			if n == nil {
+				// The connection was re-established and notifications may
+				// have been lost meanwhile, so everyone has to catch up
+				b.wakeAll()
+				continue
+			}
+			userID, err := strconv.Atoi(n.Extra)
+			if err != nil {
+				continue
+			}
+			b.wake(userID)
+		case <-ping.C:
+			if err := b.listener.Ping(); err != nil {
+				slog.Warn("Page change listener ping failed", "err", err)
+			}
+		case <-prune.C:
+			b.prune()
+		}
+	}
+}
+
+// Heartbeat returns when the loop last ran, for health checks
+func (b *Broker) Heartbeat() time.Time {
+	return b.heartbeat.Last()
+}
+
+// Close stops listening. Subscriptions are left to their handlers, which
+// should end their streams once Done is closed.
+func (b *Broker) Close() {
+	b.once.Do(func() {
+		close(b.stop)
+		<-b.done
+		b.listener.Close()
+	})
+}
+
+// Done is closed when Close is called
+func (b *Broker) Done() <-chan struct{} {
+	return b.stop
+}
+
+func (b *Broker) Subscribe(userID int) *Subscription {
+	sub := &Subscription{UserID: userID, C: make(chan struct{}, subscriberWake)}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subs[userID] == nil {
+		b.subs[userID] = make(map[*Subscription]struct{})
+	}
+	b.subs[userID][sub] = struct{}{}
+	return sub
+}
+
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subs[sub.UserID], sub)
+	if len(b.subs[sub.UserID]) == 0 {
+		delete(b.subs, sub.UserID)
+	}
+}
+
+func (b *Broker) wake(userID int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs[userID] {
+		signal(sub)
+	}
+}
+
+func (b *Broker) wakeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, subs := range b.subs {
+		for sub := range subs {
+			signal(sub)
+		}
+	}
+}
+
+// signal never blocks: a pending wake-up already covers this one
+func signal(sub *Subscription) {
+	select {
+	case sub.C <- struct{}{}:
+	default:
+	}
+}
+
+func (b *Broker) prune() {
+	ctx, cancel := context.WithTimeout(context.Background(), pruneTimeout)
+	defer cancel()
+	retention := strconv.Itoa(int(Retention.Seconds())) + " seconds"
+	if _, err := b.db.ExecContext(ctx, `DELETE FROM page_changes WHERE created_at < CURRENT_TIMESTAMP - $1::interval`, retention); err != nil {
+		slog.Error("Failed to prune page changes", "err", err)
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+
+	"tma/access"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authorizePage checks that the user has at least the given role on the
+// page. It writes the error response itself and reports whether to continue.
+func authorizePage(c *gin.Context, authz *access.Authorizer, pageID, userID int, min access.Role) (access.Role, bool) {
+	role, err := authz.Require(c.Request.Context(), pageID, userID, min)
+	switch err {
+	case nil:
+		return role, true
+	case access.ErrNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Page not found"})
+	case access.ErrForbidden:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Requires " + min.String() + " role"})
+	default:
+		dbError(c, err, "Failed to check page access")
+	}
+	return role, false
+}
+
+// authorizeWorkspace is authorizePage for workspaces
+func authorizeWorkspace(c *gin.Context, authz *access.Authorizer, workspaceID, userID int, min access.Role) (access.Role, bool) {
+	role, err := authz.RequireWorkspace(c.Request.Context(), workspaceID, userID, min)
+	switch err {
+	case nil:
+		return role, true
+	case access.ErrNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+	case access.ErrForbidden:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Requires " + min.String() + " role"})
+	default:
+		dbError(c, err, "Failed to check workspace access")
+	}
+	return role, false
+}
+
+// activeWorkspace returns the workspace selected by WorkspaceMiddleware,
+// checking that the user has at least the given role in it
+func activeWorkspace(c *gin.Context, min access.Role) (int, bool) {
+	workspaceID := c.GetInt("workspace_id")
+	role, _ := c.Get("workspace_role")
+	if workspaceID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return 0, false
+	}
+	if r, _ := role.(access.Role); r < min {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Requires " + min.String() + " role"})
+		return 0, false
+	}
+	return workspaceID, true
+}
@@ -0,0 +1,362 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"tma/access"
+	"tma/imaging"
+	"tma/models"
+	"tma/repository"
+	"tma/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// allowedAssetTypes maps sniffed content types to the file extension used
+// for storage keys. Anything else is rejected.
+var allowedAssetTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+func assetURL(id int) string {
+	return "/api/v1/assets/" + strconv.Itoa(id)
+}
+
+// withURLs fills in the URLs the asset and its variants are served at
+func withURLs(asset *models.Asset) *models.Asset {
+	asset.URL = assetURL(asset.ID)
+	for i := range asset.Variants {
+		asset.Variants[i].URL = asset.URL + "?w=" + strconv.Itoa(asset.Variants[i].Width)
+	}
+	return asset
+}
+
+type AssetsHandler struct {
+	assets    repository.AssetRepository
+	authz     *access.Authorizer
+	store     storage.Storage
+	processor *imaging.Processor
+	maxSize   int64
+	quota     int64
+}
+
+func NewAssetsHandler(assets repository.AssetRepository, authz *access.Authorizer, store storage.Storage, processor *imaging.Processor, maxSize, quota int64) *AssetsHandler {
+	return &AssetsHandler{
+		assets:    assets,
+		authz:     authz,
+		store:     store,
+		processor: processor,
+		maxSize:   maxSize,
+		quota:     quota,
+	}
+}
+
+// UploadAsset stores a multipart "file" upload. An optional "page_id" form
+// field links the asset to a page the user can edit, so that deleting the
+// page also deletes the asset.
+func (h *AssetsHandler) UploadAsset(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	// Leave room for the multipart envelope around the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxSize+1<<20)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file field is required"})
+		return
+	}
+	if fileHeader.Size > h.maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File exceeds the %d byte limit", h.maxSize)})
+		return
+	}
+
+	var pageID int
+	if v := c.PostForm("page_id"); v != "" {
+		if pageID, err = strconv.Atoi(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page ID"})
+			return
+		}
+	}
+
+	f, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read upload"})
+		return
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, h.maxSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read upload"})
+		return
+	}
+	if int64(len(data)) > h.maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File exceeds the %d byte limit", h.maxSize)})
+		return
+	}
+
+	// Trust the bytes, not the client-supplied Content-Type
+	contentType := http.DetectContentType(data)
+	ext, ok := allowedAssetTypes[contentType]
+	if !ok {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Unsupported file type: " + contentType})
+		return
+	}
+
+	// Checked before the quota transaction: authorization uses its own
+	// connection, and holding the user lock while waiting for one can
+	// starve the pool
+	if pageID != 0 {
+		if _, ok := authorizePage(c, h.authz, pageID, userID, access.RoleEditor); !ok {
+			return
+		}
+	}
+
+	key, err := newAssetKey(userID, ext)
+	if err != nil {
+		dbError(c, err, "Failed to store asset")
+		return
+	}
+	if err := h.store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), contentType); err != nil {
+		logger(c).Error("Failed to write object", "key", key, "err", err)
+		dbError(c, err, "Failed to store asset")
+		return
+	}
+
+	asset, err := h.assets.CreateAsset(ctx, repository.NewAsset{
+		UserID:      userID,
+		StorageKey:  key,
+		Filename:    sanitizeFilename(fileHeader.Filename),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		PageID:      pageID,
+	}, h.quota)
+	if err != nil {
+		h.deleteObjects([]string{key})
+		var quotaErr *repository.QuotaError
+		switch {
+		case errors.As(err, &quotaErr):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Storage quota exceeded", "used": quotaErr.Used, "quota": h.quota})
+		case errors.Is(err, repository.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Page not found"})
+		default:
+			logger(c).Error("Failed to insert asset", "err", err)
+			dbError(c, err, "Failed to store asset")
+		}
+		return
+	}
+
+	// Variants and placeholders are produced in the background
+	h.processor.Enqueue()
+
+	c.JSON(http.StatusCreated, withURLs(asset))
+}
+
+// GetAsset streams an asset's content. Assets are public, like pages.
+// With ?w= the smallest variant at least that wide is served instead.
+// Uploads may carry EXIF data (including GPS) until they are processed,
+// so nothing is served before that: 202 while the asset is waiting and
+// 404 if it could not be processed.
+func (h *AssetsHandler) GetAsset(c *gin.Context) {
+	ctx := c.Request.Context()
+	assetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid asset ID"})
+		return
+	}
+
+	asset, err := h.assets.Asset(ctx, assetID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+			return
+		}
+		dbError(c, err, "Failed to fetch asset")
+		return
+	}
+
+	switch asset.Status {
+	case imaging.StatusReady:
+	case imaging.StatusPending, imaging.StatusProcessing:
+		c.Header("Retry-After", "5")
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusAccepted, gin.H{"error": "Asset is being processed", "status": asset.Status})
+		return
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+		return
+	}
+
+	key, size, contentType := asset.StorageKey, asset.Size, asset.ContentType
+	if v := c.Query("w"); v != "" {
+		width, err := strconv.Atoi(v)
+		if err != nil || width <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid width"})
+			return
+		}
+		variant, err := h.assets.Variant(ctx, asset.ID, width)
+		switch {
+		case err == nil:
+			key, size, contentType = variant.StorageKey, variant.Size, variant.ContentType
+		case errors.Is(err, repository.ErrNotFound):
+			// Images narrower than width are served as they are
+		default:
+			dbError(c, err, "Failed to fetch asset")
+			return
+		}
+	}
+
+	r, err := h.store.Open(c.Request.Context(), key)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+			return
+		}
+		logger(c).Error("Failed to open object", "key", key, "err", err)
+		dbError(c, err, "Failed to fetch asset")
+		return
+	}
+	defer r.Close()
+
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.DataFromReader(http.StatusOK, size, contentType, r, nil)
+}
+
+// GetAssetInfo returns an asset record with its variants and placeholders
+func (h *AssetsHandler) GetAssetInfo(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	assetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid asset ID"})
+		return
+	}
+
+	asset, err := h.assets.Asset(ctx, assetID)
+	if errors.Is(err, repository.ErrNotFound) || err == nil && asset.UserID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+		return
+	}
+	if err != nil {
+		dbError(c, err, "Failed to fetch asset")
+		return
+	}
+
+	c.JSON(http.StatusOK, withURLs(asset))
+}
+
+// ListAssets returns the authenticated user's assets and quota usage
+func (h *AssetsHandler) ListAssets(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	assets, err := h.assets.AssetsByUser(ctx, userID)
+	if err != nil {
+		dbError(c, err, "Failed to fetch assets")
+		return
+	}
+
+	// The same as CreateAsset counts against the quota
+	var used int64
+	for i := range assets {
+		used += assets[i].Size
+		for _, v := range assets[i].Variants {
+			used += v.Size
+		}
+		withURLs(&assets[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{"assets": assets, "used": used, "quota": h.quota})
+}
+
+// DeleteAsset removes an asset owned by the authenticated user
+func (h *AssetsHandler) DeleteAsset(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	assetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid asset ID"})
+		return
+	}
+
+	keys, err := h.assets.DeleteAsset(ctx, assetID, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Asset not found"})
+			return
+		}
+		dbError(c, err, "Failed to delete asset")
+		return
+	}
+
+	h.deleteObjects(keys)
+	c.JSON(http.StatusOK, gin.H{"message": "Asset deleted successfully"})
+}
+
+// deleteObjects removes objects from storage after their rows are gone.
+// Failures only leave orphaned blobs behind, so they are logged, not returned.
+func (h *AssetsHandler) deleteObjects(keys []string) {
+	deleteObjects(h.store, keys)
+}
+
+func deleteObjects(store storage.Storage, keys []string) {
+	for _, key := range keys {
+		if err := store.Delete(context.Background(), key); err != nil {
+			slog.Error("Failed to delete object", "key", key, "err", err)
+		}
+	}
+}
+
+func newAssetKey(userID int, ext string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("assets/%d/%s%s", userID, hex.EncodeToString(buf), ext), nil
+}
+
+func sanitizeFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" {
+		return ""
+	}
+	// The column holds 255 characters; keep the end with the extension
+	name = strings.ToValidUTF8(name, "")
+	if runes := []rune(name); len(runes) > 255 {
+		name = string(runes[len(runes)-255:])
+	}
+	return name
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	long := strings.Repeat("ф", 300) + ".png"
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"photo.png", "photo.png"},
+		{`C:\Users\me\photo.png`, "photo.png"},
+		{"../../etc/passwd", "passwd"},
+		{"/", ""},
+		{long, strings.Repeat("ф", 251) + ".png"},
+		{"bad\xffname.png", "badname.png"},
+	}
+	for _, tt := range tests {
+		got := sanitizeFilename(tt.name)
+		if got != tt.want {
+			t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+		if !utf8.ValidString(got) || utf8.RuneCountInString(got) > 255 {
+			t.Errorf("sanitizeFilename(%q) = %q does not fit the column", tt.name, got)
+		}
+	}
+}
@@ -1,26 +1,36 @@
 package handlers
 
 import (
-	"database/sql"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"tma/auth"
+	"tma/logging"
 	"tma/models"
+	"tma/referral"
+	"tma/repository"
 )
 
 type AuthHandler struct {
-	db         *sql.DB
+	users      repository.UserRepository
+	sessions   repository.SessionRepository
 	jwtManager *auth.JWTManager
 	botToken   string
+	signer     *referral.Signer
 }
 
-func NewAuthHandler(db *sql.DB, jwtManager *auth.JWTManager, botToken string) *AuthHandler {
+func NewAuthHandler(users repository.UserRepository, sessions repository.SessionRepository, jwtManager *auth.JWTManager, botToken string, signer *referral.Signer) *AuthHandler {
 	return &AuthHandler{
-		db:         db,
+		users:      users,
+		sessions:   sessions,
 		jwtManager: jwtManager,
 		botToken:   botToken,
+		signer:     signer,
 	}
 }
 
@@ -50,22 +60,27 @@ func (h *AuthHandler) Auth(c *gin.Context) {
 	}
 
 	// Get or create user
-	user, err := h.getOrCreateUser(telegramUser)
+	startParam := h.decodeStartParam(telegramUser.StartParam)
+	user, err := h.getOrCreateUser(c.Request.Context(), telegramUser, startParam)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process user: " + err.Error()})
+		logger(c).Error("Failed to process user", "err", err)
+		dbError(c, err, "Failed to process user")
 		return
 	}
 
 	// Generate JWT token
 	token, err := h.jwtManager.GenerateToken(*user)
 	if err != nil {
+		logger(c).Error("Failed to generate token", "err", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
+	h.recordSession(c.Request.Context(), user.ID, token)
 
 	response := models.AuthResponse{
-		Token: token,
-		User:  *user,
+		Token:      token,
+		User:       *user,
+		StartParam: startParam,
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -95,22 +110,27 @@ func (h *AuthHandler) TestAuth(c *gin.Context) {
 	}
 
 	// Get or create user
-	user, err := h.getOrCreateUser(telegramUser)
+	startParam := h.decodeStartParam(telegramUser.StartParam)
+	user, err := h.getOrCreateUser(c.Request.Context(), telegramUser, startParam)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process user: " + err.Error()})
+		logger(c).Error("Failed to process user", "err", err)
+		dbError(c, err, "Failed to process user")
 		return
 	}
 
 	// Generate JWT token
 	token, err := h.jwtManager.GenerateToken(*user)
 	if err != nil {
+		logger(c).Error("Failed to generate token", "err", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
+	h.recordSession(c.Request.Context(), user.ID, token)
 
 	response := models.AuthResponse{
-		Token: token,
-		User:  *user,
+		Token:      token,
+		User:       *user,
+		StartParam: startParam,
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -127,7 +147,7 @@ func (h *AuthHandler) GetProfile(c *gin.Context) {
 		return
 	}
 
-	user, err := h.getUserByID(userID)
+	user, err := h.users.UserByID(c.Request.Context(), userID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
@@ -136,106 +156,57 @@ func (h *AuthHandler) GetProfile(c *gin.Context) {
 	c.JSON(http.StatusOK, user)
 }
 
-func (h *AuthHandler) getOrCreateUser(telegramUser *models.TelegramUser) (*models.User, error) {
+// decodeStartParam returns the fields of a signed start_param. Unsigned
+// values come from links this server did not make and are ignored.
+func (h *AuthHandler) decodeStartParam(value string) *models.StartParam {
+	if value == "" {
+		return nil
+	}
+	startParam, err := h.signer.Decode(value)
+	if err != nil {
+		return nil
+	}
+	return &startParam
+}
+
+// getOrCreateUser attributes new users to the referrer and campaign of the
+// link they signed up through. Existing users keep their attribution.
+func (h *AuthHandler) getOrCreateUser(ctx context.Context, telegramUser *models.TelegramUser, startParam *models.StartParam) (*models.User, error) {
 	// Try to get existing user
-	user, err := h.getUserByTelegramID(telegramUser.ID)
+	user, err := h.users.UserByTelegramID(ctx, telegramUser.ID)
 	if err == nil {
 		// User exists, update if needed
 		if user.Username != telegramUser.Username || 
 		   user.FirstName != telegramUser.FirstName || 
-		   user.LastName != telegramUser.LastName {
-			return h.updateUser(user.ID, telegramUser)
+		   user.LastName != telegramUser.LastName ||
+		   user.AllowsWriteToPM != telegramUser.AllowsWriteToPM {
+			return h.users.UpdateUser(ctx, user.ID, telegramUser)
 		}
 		return user, nil
 	}
 
-	if err != sql.ErrNoRows {
+	if !errors.Is(err, repository.ErrNotFound) {
 		return nil, err
 	}
 
 	// Create new user
-	return h.createUser(telegramUser)
-}
-
-func (h *AuthHandler) getUserByTelegramID(telegramID int64) (*models.User, error) {
-	query := `
-		SELECT id, telegram_id, username, first_name, last_name, created_at, updated_at
-		FROM users WHERE telegram_id = $1
-	`
-	
-	user := &models.User{}
-	err := h.db.QueryRow(query, telegramID).Scan(
-		&user.ID, &user.TelegramID, &user.Username, &user.FirstName, 
-		&user.LastName, &user.CreatedAt, &user.UpdatedAt,
-	)
-	
-	if err != nil {
-		return nil, err
+	var signup repository.Signup
+	if startParam != nil {
+		signup = repository.Signup{ReferralCode: startParam.Referral, Campaign: startParam.Campaign}
 	}
-	
-	return user, nil
+	return h.users.CreateUser(ctx, telegramUser, signup)
 }
 
-func (h *AuthHandler) getUserByID(userID int) (*models.User, error) {
-	query := `
-		SELECT id, telegram_id, username, first_name, last_name, created_at, updated_at
-		FROM users WHERE id = $1
-	`
-	
-	user := &models.User{}
-	err := h.db.QueryRow(query, userID).Scan(
-		&user.ID, &user.TelegramID, &user.Username, &user.FirstName, 
-		&user.LastName, &user.CreatedAt, &user.UpdatedAt,
-	)
-	
-	if err != nil {
-		return nil, err
-	}
-	
-	return user, nil
-}
-
-func (h *AuthHandler) createUser(telegramUser *models.TelegramUser) (*models.User, error) {
-	query := `
-		INSERT INTO users (telegram_id, username, first_name, last_name)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, telegram_id, username, first_name, last_name, created_at, updated_at
-	`
-	
-	user := &models.User{}
-	err := h.db.QueryRow(query, 
-		telegramUser.ID, telegramUser.Username, telegramUser.FirstName, telegramUser.LastName,
-	).Scan(
-		&user.ID, &user.TelegramID, &user.Username, &user.FirstName, 
-		&user.LastName, &user.CreatedAt, &user.UpdatedAt,
-	)
-	
-	if err != nil {
-		return nil, err
+// recordSession keeps a hash of an issued token in user_sessions and drops
+// the user's expired ones. Tokens are verified by signature alone, so a
+// failure here does not fail the login.
+func (h *AuthHandler) recordSession(ctx context.Context, userID int, token string) {
+	sum := sha256.Sum256([]byte(token))
+	if _, err := h.sessions.CreateSession(ctx, userID, hex.EncodeToString(sum[:]), time.Now().Add(auth.TokenTTL)); err != nil {
+		logging.FromContext(ctx).Error("Failed to record session", "user_id", userID, "err", err)
+		return
 	}
-	
-	return user, nil
-}
-
-func (h *AuthHandler) updateUser(userID int, telegramUser *models.TelegramUser) (*models.User, error) {
-	query := `
-		UPDATE users 
-		SET username = $1, first_name = $2, last_name = $3, updated_at = $4
-		WHERE id = $5
-		RETURNING id, telegram_id, username, first_name, last_name, created_at, updated_at
-	`
-	
-	user := &models.User{}
-	err := h.db.QueryRow(query, 
-		telegramUser.Username, telegramUser.FirstName, telegramUser.LastName, time.Now(), userID,
-	).Scan(
-		&user.ID, &user.TelegramID, &user.Username, &user.FirstName, 
-		&user.LastName, &user.CreatedAt, &user.UpdatedAt,
-	)
-	
-	if err != nil {
-		return nil, err
+	if _, err := h.sessions.DeleteExpiredSessions(ctx, userID); err != nil {
+		logging.FromContext(ctx).Error("Failed to delete expired sessions", "user_id", userID, "err", err)
 	}
-	
-	return user, nil
 }
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"tma/access"
+	"tma/auth"
+	"tma/collab"
+	"tma/ws"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CollabHandler struct {
+	db         *sql.DB
+	authz      *access.Authorizer
+	hub        *collab.Hub
+	jwtManager *auth.JWTManager
+}
+
+func NewCollabHandler(db *sql.DB, authz *access.Authorizer, hub *collab.Hub, jwtManager *auth.JWTManager) *CollabHandler {
+	return &CollabHandler{
+		db:         db,
+		authz:      authz,
+		hub:        hub,
+		jwtManager: jwtManager,
+	}
+}
+
+// EditPage upgrades to a WebSocket for collaborative editing of a page.
+// Viewers may join the session but only editors and owners can change it.
+func (h *CollabHandler) EditPage(c *gin.Context) {
+	pageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page ID"})
+		return
+	}
+
+	claims, ok := authenticateStream(c, h.jwtManager)
+	if !ok {
+		return
+	}
+
+	role, ok := authorizePage(c, h.authz, pageID, claims.UserID, access.RoleViewer)
+	if !ok {
+		return
+	}
+
+	conn, err := ws.Upgrade(c.Writer, c.Request)
+	if err != nil {
+		return
+	}
+
+	h.hub.Serve(c.Request.Context(), conn, pageID, claims.UserID, claims.TelegramID, role < access.RoleEditor)
+}
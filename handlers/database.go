@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"tma/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dbError writes the response for a failed database call. Timeouts and an
+// unreachable database get 504 and 503 so clients know to retry; a client
+// that went away gets a bare 499, which only shows up in the access log.
+func dbError(c *gin.Context, err error, message string) {
+	status := database.HTTPStatus(c.Request.Context(), err)
+	if status == database.StatusClientClosedRequest {
+		c.Status(status)
+		return
+	}
+	c.JSON(status, gin.H{"error": message})
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tma/auth"
+	"tma/events"
+	"tma/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	eventsBatchSize   = 100
+	eventsHeartbeat   = 25 * time.Second
+	eventsRetryMillis = 3000
+)
+
+type EventsHandler struct {
+	db         *sql.DB
+	broker     *events.Broker
+	jwtManager *auth.JWTManager
+}
+
+func NewEventsHandler(db *sql.DB, broker *events.Broker, jwtManager *auth.JWTManager) *EventsHandler {
+	return &EventsHandler{
+		db:         db,
+		broker:     broker,
+		jwtManager: jwtManager,
+	}
+}
+
+// StreamPageEvents streams created/updated/deleted events for the user's
+// own and shared pages as Server-Sent Events. Clients resume with the Last-Event-ID
+// header (or ?last_event_id=); events older than the retention period
+// cannot be replayed, in which case a "reset" event tells the client to
+// reload its pages.
+func (h *EventsHandler) StreamPageEvents(c *gin.Context) {
+	ctx := c.Request.Context()
+	claims, ok := authenticateStream(c, h.jwtManager)
+	if !ok {
+		return
+	}
+
+	lastID, err := parseLastEventID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Last-Event-ID"})
+		return
+	}
+
+	// Subscribe before replaying so nothing committed in between is missed
+	sub := h.broker.Subscribe(claims.UserID)
+	defer h.broker.Unsubscribe(sub)
+
+	// The stream outlives the server's write timeout; it ends when the
+	// client leaves or the broker closes on shutdown
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		logger(c).Warn("Failed to lift the write deadline, the stream ends at the write timeout", "err", err)
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", eventsRetryMillis)
+
+	if lastID > 0 {
+		expired, err := h.resumeExpired(ctx, lastID)
+		if err != nil {
+			logger(c).Error("Failed to check resume point", "err", err)
+			return
+		}
+		if expired {
+			fmt.Fprint(c.Writer, "event: reset\ndata: {}\n\n")
+			lastID = 0
+		}
+	}
+	if lastID == 0 {
+		// New clients start from now rather than replaying the whole log
+		if err := h.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(id), 0) FROM page_changes`).Scan(&lastID); err != nil {
+			logger(c).Error("Failed to fetch latest event", "err", err)
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		if lastID, err = h.sendSince(c, claims.UserID, lastID); err != nil {
+			logger(c).Error("Failed to send events", "err", err)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.broker.Done():
+			// Clients reconnect with Last-Event-ID to another instance
+			return
+		case <-sub.C:
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": heartbeat\n\n")
+			c.Writer.Flush()
+		}
+	}
+}
+
+// sendSince writes all events after lastID and returns the new position.
+// Paging by ID is gap-free because the page_changes trigger makes writers
+// commit in ID order (migration 0010): once an ID is visible, no lower one
+// can appear later.
+func (h *EventsHandler) sendSince(c *gin.Context, userID int, lastID int64) (int64, error) {
+	ctx := c.Request.Context()
+	for {
+		rows, err := h.db.QueryContext(ctx, `
+			SELECT id, page_id, kind, created_at
+			FROM page_changes
+			WHERE user_id = $1 AND id > $2
+			ORDER BY id
+			LIMIT $3
+		`, userID, lastID, eventsBatchSize)
+		if err != nil {
+			return lastID, err
+		}
+
+		n := 0
+		for rows.Next() {
+			var change models.PageChange
+			if err := rows.Scan(&change.ID, &change.PageID, &change.Kind, &change.CreatedAt); err != nil {
+				rows.Close()
+				return lastID, err
+			}
+			data, _ := json.Marshal(change)
+			fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", change.ID, change.Kind, data)
+			lastID = change.ID
+			n++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return lastID, err
+		}
+
+		if n > 0 {
+			c.Writer.Flush()
+		}
+		if n < eventsBatchSize {
+			return lastID, nil
+		}
+	}
+}
+
+// resumeExpired reports whether events after lastID may already have been pruned
+func (h *EventsHandler) resumeExpired(ctx context.Context, lastID int64) (bool, error) {
+	var minID sql.NullInt64
+	if err := h.db.QueryRowContext(ctx, `SELECT MIN(id) FROM page_changes`).Scan(&minID); err != nil {
+		return false, err
+	}
+	return minID.Valid && minID.Int64 > lastID+1, nil
+}
+
+func parseLastEventID(c *gin.Context) (int64, error) {
+	v := c.GetHeader("Last-Event-ID")
+	if v == "" {
+		v = c.Query("last_event_id")
+	}
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
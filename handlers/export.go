@@ -0,0 +1,552 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"tma/access"
+	"tma/imaging"
+	"tma/models"
+	"tma/repository"
+	"tma/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxImportSize limits the size of an uploaded import archive
+	maxImportSize = 32 << 20
+	// maxImportUncompressed limits what the files of an archive claim to
+	// unpack to, and maxImportEntrySize each JSON file, so that a small
+	// archive cannot expand without bound
+	maxImportUncompressed = 256 << 20
+	maxImportEntrySize    = 8 << 20
+)
+
+// assetURLPattern matches the URLs assets are served at in page content
+var assetURLPattern = regexp.MustCompile(`/api/v1/assets/(\d+)`)
+
+// ExportHandler moves the pages of a workspace in and out as zip archives
+// holding their metadata, revisions and assets
+type ExportHandler struct {
+	pages     repository.PageRepository
+	assets    repository.AssetRepository
+	store     storage.Storage
+	processor *imaging.Processor
+	// maxAssetSize and quota apply to imported assets like to uploads
+	maxAssetSize int64
+	quota        int64
+}
+
+func NewExportHandler(pages repository.PageRepository, assets repository.AssetRepository, store storage.Storage, processor *imaging.Processor, maxAssetSize, quota int64) *ExportHandler {
+	return &ExportHandler{
+		pages:        pages,
+		assets:       assets,
+		store:        store,
+		processor:    processor,
+		maxAssetSize: maxAssetSize,
+		quota:        quota,
+	}
+}
+
+// ExportPages streams all pages of the active workspace as a zip archive
+func (h *ExportHandler) ExportPages(c *gin.Context) {
+	ctx := c.Request.Context()
+	workspaceID, ok := activeWorkspace(c, access.RoleViewer)
+	if !ok {
+		return
+	}
+
+	pages, err := h.pages.PagesByWorkspace(ctx, workspaceID)
+	if err != nil {
+		dbError(c, err, "Failed to fetch pages")
+		return
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].ID < pages[j].ID })
+
+	// Everything but the asset content is read up front, so that database
+	// errors can still be reported
+	exports := make([]models.PageExport, 0, len(pages))
+	assets := make([]models.Asset, 0)
+	seen := make(map[int]bool)
+	for _, page := range pages {
+		revisions, err := h.pages.PageRevisions(ctx, page.ID)
+		if err != nil {
+			dbError(c, err, "Failed to fetch revisions")
+			return
+		}
+		linked, err := h.assets.PageAssets(ctx, page.ID)
+		if err != nil {
+			dbError(c, err, "Failed to fetch assets")
+			return
+		}
+
+		export := pageExport(page, revisions)
+		for _, asset := range linked {
+			export.Assets = append(export.Assets, asset.ID)
+			if !seen[asset.ID] {
+				seen[asset.ID] = true
+				assets = append(assets, asset)
+			}
+		}
+		exports = append(exports, export)
+	}
+
+	filename := fmt.Sprintf("pages-%s.zip", time.Now().UTC().Format("20060102-150405"))
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Content-Type", "application/zip")
+	c.Status(http.StatusOK)
+
+	// Past this point the response has started, and a failure can only
+	// cut the download short
+	if err := h.writeExportArchive(ctx, c.Writer, exports, assets); err != nil {
+		logger(c).Error("Failed to write archive", "err", err)
+		c.Abort()
+	}
+}
+
+// ImportPages restores pages from an archive produced by ExportPages into
+// the active workspace. Page and asset IDs are always reassigned. A page
+// whose title matches an existing page of the workspace is a conflict,
+// resolved according to ?on_conflict= skip (default), rename or overwrite.
+// Identical pages are always skipped.
+func (h *ExportHandler) ImportPages(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	workspaceID, ok := activeWorkspace(c, access.RoleEditor)
+	if !ok {
+		return
+	}
+
+	onConflict := c.DefaultQuery("on_conflict", repository.OnConflictSkip)
+	if onConflict != repository.OnConflictSkip && onConflict != repository.OnConflictRename && onConflict != repository.OnConflictOverwrite {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "on_conflict must be one of skip, rename, overwrite"})
+		return
+	}
+
+	data, err := readImportBody(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid archive", "details": err.Error()})
+		return
+	}
+
+	archive, err := openExportArchive(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid archive", "details": err.Error()})
+		return
+	}
+
+	// Assets come first so that the pages can point at them
+	assetIDs, err := h.importAssets(ctx, userID, archive)
+	if err != nil {
+		var quotaErr *repository.QuotaError
+		var archiveErr *archiveError
+		switch {
+		case errors.As(err, &quotaErr):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Storage quota exceeded", "used": quotaErr.Used, "quota": h.quota})
+		case errors.As(err, &archiveErr):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid archive", "details": archiveErr.Error()})
+		default:
+			logger(c).Error("Failed to import assets", "err", err)
+			dbError(c, err, "Failed to import assets")
+		}
+		return
+	}
+
+	report, err := h.pages.ImportPages(ctx, userID, workspaceID, archive.pages, repository.ImportOptions{
+		OnConflict: onConflict,
+		Assets:     assetIDs,
+		Rewrite: func(p models.PageExport) models.PageExport {
+			return rewriteAssetURLs(p, assetIDs)
+		},
+	})
+	if err != nil {
+		h.discardAssets(userID, assetIDs)
+		logger(c).Error("Failed to import pages", "err", err)
+		dbError(c, err, "Failed to import pages")
+		return
+	}
+
+	// Assets only skipped pages use are not kept
+	h.discardAssets(userID, unusedAssets(archive.pages, report, assetIDs))
+	if len(assetIDs) > 0 {
+		h.processor.Enqueue()
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// readImportBody accepts either a multipart form with a "file" field or a raw zip body
+func readImportBody(c *gin.Context) ([]byte, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("file field is required: %w", err)
+		}
+		f, err := fileHeader.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+
+	return io.ReadAll(c.Request.Body)
+}
+
+func pageExport(page models.Page, revisions []models.PageRevision) models.PageExport {
+	export := models.PageExport{
+		ID:          page.ID,
+		Title:       page.Title,
+		Description: page.Description,
+		CoverImage:  page.CoverImage,
+		JSONData:    page.JSONData,
+		PriceStars:  page.PriceStars,
+		CreatedAt:   page.CreatedAt,
+		UpdatedAt:   page.UpdatedAt,
+	}
+	for _, r := range revisions {
+		export.Revisions = append(export.Revisions, models.RevisionExport{
+			Title:       r.Title,
+			Description: r.Description,
+			CoverImage:  r.CoverImage,
+			JSONData:    r.JSONData,
+			CreatedAt:   r.CreatedAt,
+		})
+	}
+	return export
+}
+
+func (h *ExportHandler) writeExportArchive(ctx context.Context, w io.Writer, pages []models.PageExport, assets []models.Asset) error {
+	zw := zip.NewWriter(w)
+
+	manifest := models.ExportManifest{
+		Format:     models.ExportFormat,
+		Version:    models.ExportVersion,
+		ExportedAt: time.Now().UTC(),
+		Pages:      make([]models.ExportManifestItem, 0, len(pages)),
+	}
+
+	for _, page := range pages {
+		file := fmt.Sprintf("pages/%d.json", page.ID)
+		manifest.Pages = append(manifest.Pages, models.ExportManifestItem{
+			ID:    page.ID,
+			Title: page.Title,
+			File:  file,
+		})
+		if err := writeZipJSON(zw, file, page); err != nil {
+			return err
+		}
+	}
+
+	for _, asset := range assets {
+		file := fmt.Sprintf("assets/%d%s", asset.ID, path.Ext(asset.StorageKey))
+		err := h.writeZipObject(ctx, zw, file, asset.StorageKey)
+		if errors.Is(err, storage.ErrNotFound) {
+			// Pages keep pointing at the asset's old URL
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		manifest.Assets = append(manifest.Assets, models.ExportManifestAsset{
+			ID:          asset.ID,
+			Filename:    asset.Filename,
+			ContentType: asset.ContentType,
+			Size:        asset.Size,
+			File:        file,
+		})
+	}
+
+	if err := writeZipJSON(zw, "manifest.json", manifest); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+func writeZipJSON(zw *zip.Writer, name string, v interface{}) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// writeZipObject copies an object from storage into the archive. Images
+// are compressed already, so they are stored as they are.
+func (h *ExportHandler) writeZipObject(ctx context.Context, zw *zip.Writer, name, key string) error {
+	rc, err := h.store.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, rc)
+	return err
+}
+
+// archiveError is a problem with the content of an uploaded archive
+type archiveError struct {
+	msg string
+}
+
+func (e *archiveError) Error() string {
+	return e.msg
+}
+
+func invalidArchive(format string, args ...interface{}) error {
+	return &archiveError{msg: fmt.Sprintf(format, args...)}
+}
+
+// exportArchive is an uploaded archive with its pages read
+type exportArchive struct {
+	files    map[string]*zip.File
+	manifest models.ExportManifest
+	pages    []models.PageExport
+}
+
+func openExportArchive(data []byte) (*exportArchive, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &exportArchive{files: make(map[string]*zip.File, len(zr.File))}
+	var total uint64
+	for _, f := range zr.File {
+		total += f.UncompressedSize64
+		if total > maxImportUncompressed {
+			return nil, invalidArchive("archive unpacks to more than %d bytes", maxImportUncompressed)
+		}
+		archive.files[path.Clean(f.Name)] = f
+	}
+
+	if err := archive.readJSON("manifest.json", &archive.manifest); err != nil {
+		return nil, err
+	}
+	if archive.manifest.Format != models.ExportFormat {
+		return nil, invalidArchive("unknown archive format %q", archive.manifest.Format)
+	}
+	if archive.manifest.Version > models.ExportVersion {
+		return nil, invalidArchive("unsupported archive version %d", archive.manifest.Version)
+	}
+
+	archive.pages = make([]models.PageExport, 0, len(archive.manifest.Pages))
+	for _, item := range archive.manifest.Pages {
+		var page models.PageExport
+		if err := archive.readJSON(item.File, &page); err != nil {
+			return nil, err
+		}
+		archive.pages = append(archive.pages, page)
+	}
+
+	return archive, nil
+}
+
+// open returns a reader for a file of the archive that fails past limit
+// bytes, whatever the file header claims
+func (a *exportArchive) open(name string, limit int64) (io.ReadCloser, error) {
+	f, ok := a.files[path.Clean(name)]
+	if !ok {
+		return nil, invalidArchive("%s not found in archive", name)
+	}
+	if f.UncompressedSize64 > uint64(limit) {
+		return nil, invalidArchive("%s is larger than %d bytes", name, limit)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, invalidArchive("failed to open %s: %v", name, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(rc, limit+1), rc}, nil
+}
+
+func (a *exportArchive) readJSON(name string, v interface{}) error {
+	rc, err := a.open(name, maxImportEntrySize)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return invalidArchive("failed to read %s: %v", name, err)
+	}
+	if len(data) > maxImportEntrySize {
+		return invalidArchive("%s is larger than %d bytes", name, maxImportEntrySize)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return invalidArchive("failed to parse %s: %v", name, err)
+	}
+	return nil
+}
+
+// importAssets stores the assets the archive pages use as new assets of
+// the user and returns their IDs by archive ID. Nothing is kept if any
+// of them fails.
+func (h *ExportHandler) importAssets(ctx context.Context, userID int, archive *exportArchive) (map[int]int, error) {
+	used := make(map[int]bool)
+	for _, page := range archive.pages {
+		for _, id := range page.Assets {
+			used[id] = true
+		}
+	}
+
+	ids := make(map[int]int)
+	for _, item := range archive.manifest.Assets {
+		if !used[item.ID] {
+			continue
+		}
+		asset, err := h.importAsset(ctx, userID, archive, item)
+		if err != nil {
+			h.discardAssets(userID, ids)
+			return nil, err
+		}
+		ids[item.ID] = asset.ID
+	}
+	return ids, nil
+}
+
+func (h *ExportHandler) importAsset(ctx context.Context, userID int, archive *exportArchive, item models.ExportManifestAsset) (*models.Asset, error) {
+	rc, err := archive.open(item.File, h.maxAssetSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, invalidArchive("failed to read %s: %v", item.File, err)
+	}
+	if int64(len(data)) > h.maxAssetSize {
+		return nil, invalidArchive("%s is larger than %d bytes", item.File, h.maxAssetSize)
+	}
+
+	// Trust the bytes, not the manifest
+	contentType := http.DetectContentType(data)
+	ext, ok := allowedAssetTypes[contentType]
+	if !ok {
+		return nil, invalidArchive("%s has unsupported type %s", item.File, contentType)
+	}
+
+	key, err := newAssetKey(userID, ext)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), contentType); err != nil {
+		return nil, err
+	}
+
+	asset, err := h.assets.CreateAsset(ctx, repository.NewAsset{
+		UserID:      userID,
+		StorageKey:  key,
+		Filename:    sanitizeFilename(item.Filename),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+	}, h.quota)
+	if err != nil {
+		deleteObjects(h.store, []string{key})
+		return nil, err
+	}
+	return asset, nil
+}
+
+// discardAssets deletes imported assets together with their content
+func (h *ExportHandler) discardAssets(userID int, ids map[int]int) {
+	for _, id := range ids {
+		keys, err := h.assets.DeleteAsset(context.Background(), id, userID)
+		if err != nil {
+			slog.Error("Failed to delete imported asset", "asset_id", id, "err", err)
+			continue
+		}
+		deleteObjects(h.store, keys)
+	}
+}
+
+// unusedAssets returns the imported assets no written page links to
+func unusedAssets(pages []models.PageExport, report *models.ImportReport, ids map[int]int) map[int]int {
+	written := make(map[int]bool)
+	for _, p := range report.Created {
+		written[p.OldID] = true
+	}
+	for _, p := range report.Overwritten {
+		written[p.OldID] = true
+	}
+
+	unused := make(map[int]int, len(ids))
+	for archiveID, id := range ids {
+		unused[archiveID] = id
+	}
+	for _, page := range pages {
+		if !written[page.ID] {
+			continue
+		}
+		for _, archiveID := range page.Assets {
+			delete(unused, archiveID)
+		}
+	}
+	return unused
+}
+
+// rewriteAssetURLs points the asset URLs of a page and its revisions at
+// the assets imported for them
+func rewriteAssetURLs(p models.PageExport, ids map[int]int) models.PageExport {
+	rewrite := func(s string) string {
+		return assetURLPattern.ReplaceAllStringFunc(s, func(match string) string {
+			archiveID, err := strconv.Atoi(assetURLPattern.FindStringSubmatch(match)[1])
+			if err != nil {
+				return match
+			}
+			if id, ok := ids[archiveID]; ok {
+				return assetURL(id)
+			}
+			return match
+		})
+	}
+	rewriteJSON := func(data models.JSONData) models.JSONData {
+		if data == nil {
+			return nil
+		}
+		return models.JSONData(rewrite(string(data)))
+	}
+
+	p.CoverImage = rewrite(p.CoverImage)
+	p.JSONData = rewriteJSON(p.JSONData)
+	revisions := make([]models.RevisionExport, len(p.Revisions))
+	for i, r := range p.Revisions {
+		r.CoverImage = rewrite(r.CoverImage)
+		r.JSONData = rewriteJSON(r.JSONData)
+		revisions[i] = r
+	}
+	p.Revisions = revisions
+	return p
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"tma/health"
+
+	"github.com/gin-gonic/gin"
+)
+
+type HealthHandler struct {
+	live     *health.Checker
+	ready    *health.Checker
+	draining atomic.Bool
+}
+
+// NewHealthHandler serves the live checks on /livez and the ready checks
+// on /readyz
+func NewHealthHandler(live, ready *health.Checker) *HealthHandler {
+	return &HealthHandler{live: live, ready: ready}
+}
+
+// Drain makes Readyz fail from now on, so load balancers stop sending
+// requests before the server shuts down
+func (h *HealthHandler) Drain() {
+	h.draining.Store(true)
+}
+
+// Livez reports whether the process works at all. It does not depend on
+// the database, so an outage does not get healthy instances restarted.
+func (h *HealthHandler) Livez(c *gin.Context) {
+	writeReport(c, h.live.Run())
+}
+
+// Readyz reports whether the instance can serve requests
+func (h *HealthHandler) Readyz(c *gin.Context) {
+	if h.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+		return
+	}
+	writeReport(c, h.ready.Run())
+}
+
+func writeReport(c *gin.Context, report health.Report) {
+	c.Header("Cache-Control", "no-store")
+	if !report.OK() {
+		c.JSON(http.StatusServiceUnavailable, report)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
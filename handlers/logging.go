@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"log/slog"
+
+	"tma/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// logger returns the logger of the request, which carries its route and
+// user
+func logger(c *gin.Context) *slog.Logger {
+	return logging.FromContext(c.Request.Context())
+}
@@ -0,0 +1,573 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"tma/access"
+	"tma/logging"
+	"tma/models"
+	"tma/notify"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MembersHandler manages who a page is shared with: direct members and
+// invite links
+type MembersHandler struct {
+	db       *sql.DB
+	authz    *access.Authorizer
+	notifier *notify.Notifier
+}
+
+func NewMembersHandler(db *sql.DB, authz *access.Authorizer, notifier *notify.Notifier) *MembersHandler {
+	return &MembersHandler{db: db, authz: authz, notifier: notifier}
+}
+
+// ListMembers returns everyone with access to a page the user can view:
+// members of its workspace and users it was shared with directly
+func (h *MembersHandler) ListMembers(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	pageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page ID"})
+		return
+	}
+
+	if _, ok := authorizePage(c, h.authz, pageID, userID, access.RoleViewer); !ok {
+		return
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT u.id, u.telegram_id, COALESCE(u.username, ''), COALESCE(u.first_name, ''), COALESCE(u.last_name, ''),
+			wm.role, 'workspace', wm.created_at
+		FROM pages p
+		JOIN workspace_members wm ON wm.workspace_id = p.workspace_id
+		JOIN users u ON u.id = wm.user_id
+		WHERE p.id = $1
+		UNION ALL
+		SELECT u.id, u.telegram_id, COALESCE(u.username, ''), COALESCE(u.first_name, ''), COALESCE(u.last_name, ''),
+			m.role, 'page', m.created_at
+		FROM page_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.page_id = $1
+		ORDER BY 8
+	`, pageID)
+	if err != nil {
+		dbError(c, err, "Failed to fetch members")
+		return
+	}
+	defer rows.Close()
+
+	members := make([]models.PageMember, 0)
+	for rows.Next() {
+		var m models.PageMember
+		if err := rows.Scan(&m.UserID, &m.TelegramID, &m.Username, &m.FirstName, &m.LastName, &m.Role, &m.Via, &m.CreatedAt); err != nil {
+			dbError(c, err, "Failed to scan member")
+			return
+		}
+		members = append(members, m)
+	}
+	if err := rows.Err(); err != nil {
+		dbError(c, err, "Failed to fetch members")
+		return
+	}
+
+	c.JSON(http.StatusOK, members)
+}
+
+// AddMember shares a page with another user, or changes their role if the
+// page is already shared with them. Sharing requires the admin role.
+func (h *MembersHandler) AddMember(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	pageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page ID"})
+		return
+	}
+
+	var req models.AddMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.UserID == 0 && req.TelegramID == 0 && req.Username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "One of user_id, telegram_id or username is required"})
+		return
+	}
+	role, ok := parseMemberRole(c, req.Role)
+	if !ok {
+		return
+	}
+
+	if _, ok := authorizePage(c, h.authz, pageID, userID, access.RoleAdmin); !ok {
+		return
+	}
+
+	memberID, err := findUser(ctx, h.db, req)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		dbError(c, err, "Failed to find user")
+		return
+	}
+	if memberID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot share a page with yourself"})
+		return
+	}
+
+	_, err = h.db.ExecContext(ctx, `
+		INSERT INTO page_members (page_id, user_id, role, invited_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (page_id, user_id) DO UPDATE SET role = EXCLUDED.role
+	`, pageID, memberID, role.String(), userID)
+	if err != nil {
+		logger(c).Error("Failed to add member", "member_id", memberID, "page_id", pageID, "err", err)
+		dbError(c, err, "Failed to add member")
+		return
+	}
+
+	queueNotification(ctx, h.notifier, h.db, notify.Notification{
+		UserID: memberID,
+		Kind:   notify.KindPageShared,
+		Text:   fmt.Sprintf("%s shared \"%s\" with you as %s", userDisplayName(ctx, h.db, userID), pageTitle(ctx, h.db, pageID), role),
+		PageID: pageID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"user_id": memberID, "role": role.String()})
+}
+
+// UpdateMember changes the role of an existing member
+func (h *MembersHandler) UpdateMember(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	pageID, memberID, ok := memberParams(c)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	role, ok := parseMemberRole(c, req.Role)
+	if !ok {
+		return
+	}
+
+	if _, ok := authorizePage(c, h.authz, pageID, userID, access.RoleAdmin); !ok {
+		return
+	}
+
+	result, err := h.db.ExecContext(ctx, `UPDATE page_members SET role = $1 WHERE page_id = $2 AND user_id = $3`, role.String(), pageID, memberID)
+	if err != nil {
+		dbError(c, err, "Failed to update member")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user_id": memberID, "role": role.String()})
+}
+
+// RemoveMember revokes a direct member's access. Admins can remove
+// anyone; members can remove themselves to leave a shared page.
+func (h *MembersHandler) RemoveMember(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	pageID, memberID, ok := memberParams(c)
+	if !ok {
+		return
+	}
+
+	min := access.RoleAdmin
+	if memberID == userID {
+		min = access.RoleViewer
+	}
+	if _, ok := authorizePage(c, h.authz, pageID, userID, min); !ok {
+		return
+	}
+
+	result, err := h.db.ExecContext(ctx, `DELETE FROM page_members WHERE page_id = $1 AND user_id = $2`, pageID, memberID)
+	if err != nil {
+		dbError(c, err, "Failed to remove member")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
+
+// CreateInvite creates an invite link granting a role on the page
+func (h *MembersHandler) CreateInvite(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	pageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page ID"})
+		return
+	}
+
+	var req models.CreateInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	role, ok := parseMemberRole(c, req.Role)
+	if !ok {
+		return
+	}
+	if req.ExpiresIn < 0 || (req.MaxUses != nil && *req.MaxUses <= 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in and max_uses must be positive"})
+		return
+	}
+
+	if _, ok := authorizePage(c, h.authz, pageID, userID, access.RoleAdmin); !ok {
+		return
+	}
+
+	token, err := newInviteToken()
+	if err != nil {
+		dbError(c, err, "Failed to create invite")
+		return
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresIn > 0 {
+		t := time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+		expiresAt = &t
+	}
+
+	var invite models.PageInvite
+	err = scanInvite(h.db.QueryRowContext(ctx, `
+		INSERT INTO page_invites (page_id, token, role, created_by, expires_at, max_uses)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+inviteColumns,
+		pageID, token, role.String(), userID, expiresAt, req.MaxUses), &invite)
+	if err != nil {
+		logger(c).Error("Failed to create invite", "page_id", pageID, "err", err)
+		dbError(c, err, "Failed to create invite")
+		return
+	}
+
+	c.JSON(http.StatusCreated, invite)
+}
+
+// ListInvites returns the invite links of a page
+func (h *MembersHandler) ListInvites(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	pageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page ID"})
+		return
+	}
+
+	if _, ok := authorizePage(c, h.authz, pageID, userID, access.RoleAdmin); !ok {
+		return
+	}
+
+	rows, err := h.db.QueryContext(ctx, `SELECT `+inviteColumns+` FROM page_invites WHERE page_id = $1 ORDER BY created_at DESC`, pageID)
+	if err != nil {
+		dbError(c, err, "Failed to fetch invites")
+		return
+	}
+	defer rows.Close()
+
+	invites := make([]models.PageInvite, 0)
+	for rows.Next() {
+		var invite models.PageInvite
+		if err := scanInvite(rows, &invite); err != nil {
+			dbError(c, err, "Failed to scan invite")
+			return
+		}
+		invites = append(invites, invite)
+	}
+	if err := rows.Err(); err != nil {
+		dbError(c, err, "Failed to fetch invites")
+		return
+	}
+
+	c.JSON(http.StatusOK, invites)
+}
+
+// DeleteInvite revokes an invite link. Members who already joined keep
+// their access.
+func (h *MembersHandler) DeleteInvite(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	pageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page ID"})
+		return
+	}
+	inviteID, err := strconv.Atoi(c.Param("invite_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invite ID"})
+		return
+	}
+
+	if _, ok := authorizePage(c, h.authz, pageID, userID, access.RoleAdmin); !ok {
+		return
+	}
+
+	result, err := h.db.ExecContext(ctx, `DELETE FROM page_invites WHERE id = $1 AND page_id = $2`, inviteID, pageID)
+	if err != nil {
+		dbError(c, err, "Failed to delete invite")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invite deleted successfully"})
+}
+
+// AcceptInvite adds the authenticated user to the page of an invite.
+// Accepting never downgrades an existing role.
+func (h *MembersHandler) AcceptInvite(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		dbError(c, err, "Failed to accept invite")
+		return
+	}
+	defer tx.Rollback()
+
+	var invite models.PageInvite
+	err = scanInvite(tx.QueryRowContext(ctx, `SELECT `+inviteColumns+` FROM page_invites WHERE token = $1 FOR UPDATE`, c.Param("token")), &invite)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found"})
+		return
+	}
+	if err != nil {
+		dbError(c, err, "Failed to accept invite")
+		return
+	}
+	if invite.ExpiresAt != nil && time.Now().After(*invite.ExpiresAt) {
+		c.JSON(http.StatusGone, gin.H{"error": "Invite has expired"})
+		return
+	}
+	if invite.MaxUses != nil && invite.Uses >= *invite.MaxUses {
+		c.JSON(http.StatusGone, gin.H{"error": "Invite has been used up"})
+		return
+	}
+
+	current, err := h.authz.PageRole(ctx, invite.PageID, userID)
+	if err != nil {
+		dbError(c, err, "Failed to accept invite")
+		return
+	}
+	granted, _ := access.ParseRole(invite.Role)
+
+	role := current
+	if granted > current {
+		// The upsert only touches the row when the invite grants more than
+		// the user already has directly; uses counts only real changes
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO page_members (page_id, user_id, role, invited_by)
+			SELECT $1, $2, $3, created_by FROM page_invites WHERE id = $4
+			ON CONFLICT (page_id, user_id) DO UPDATE SET role = EXCLUDED.role
+			WHERE page_members.role = 'viewer' AND EXCLUDED.role = 'editor'
+		`, invite.PageID, userID, invite.Role, invite.ID)
+		if err != nil {
+			logger(c).Error("Failed to add member", "page_id", invite.PageID, "err", err)
+			dbError(c, err, "Failed to accept invite")
+			return
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			if _, err := tx.ExecContext(ctx, `UPDATE page_invites SET uses = uses + 1 WHERE id = $1`, invite.ID); err != nil {
+				dbError(c, err, "Failed to accept invite")
+				return
+			}
+			role = granted
+
+			if err := h.notifyInviteAccepted(ctx, tx, invite, userID); err != nil {
+				logger(c).Error("Failed to notify invite creator", "page_id", invite.PageID, "err", err)
+				dbError(c, err, "Failed to accept invite")
+				return
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		dbError(c, err, "Failed to accept invite")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"page_id": invite.PageID, "role": role.String()})
+}
+
+// SharedWithMe returns the pages shared with the authenticated user
+// directly, outside of their workspaces
+func (h *MembersHandler) SharedWithMe(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT p.id, p.user_id, p.workspace_id, p.title, p.description, p.cover_image, p.json_data, p.price_stars, p.created_at, p.updated_at, m.role
+		FROM page_members m
+		JOIN pages p ON p.id = m.page_id
+		WHERE m.user_id = $1
+		ORDER BY p.updated_at DESC
+	`, userID)
+	if err != nil {
+		dbError(c, err, "Failed to fetch pages")
+		return
+	}
+	defer rows.Close()
+
+	pages := make([]models.SharedPage, 0)
+	for rows.Next() {
+		var p models.SharedPage
+		err := rows.Scan(
+			&p.ID, &p.UserID, &p.WorkspaceID, &p.Title, &p.Description, &p.CoverImage,
+			&p.JSONData, &p.PriceStars, &p.CreatedAt, &p.UpdatedAt, &p.Role,
+		)
+		if err != nil {
+			dbError(c, err, "Failed to scan page")
+			return
+		}
+		pages = append(pages, p)
+	}
+	if err := rows.Err(); err != nil {
+		dbError(c, err, "Failed to fetch pages")
+		return
+	}
+
+	c.JSON(http.StatusOK, pages)
+}
+
+// notifyInviteAccepted tells the creator of an invite that userID joined
+// through it. It runs in the accepting transaction, which a failed insert
+// aborts, so errors are returned rather than logged.
+func (h *MembersHandler) notifyInviteAccepted(ctx context.Context, tx *sql.Tx, invite models.PageInvite, userID int) error {
+	var createdBy sql.NullInt64
+	if err := tx.QueryRowContext(ctx, `SELECT created_by FROM page_invites WHERE id = $1`, invite.ID).Scan(&createdBy); err != nil {
+		return err
+	}
+	if !createdBy.Valid || int(createdBy.Int64) == userID {
+		return nil
+	}
+
+	return h.notifier.Notify(ctx, tx, notify.Notification{
+		UserID: int(createdBy.Int64),
+		Kind:   notify.KindInviteAccepted,
+		Text:   fmt.Sprintf("%s joined \"%s\" through your invite link as %s", userDisplayName(ctx, tx, userID), pageTitle(ctx, tx, invite.PageID), invite.Role),
+		PageID: invite.PageID,
+	})
+}
+
+// pageTitle names a page in notification texts
+func pageTitle(ctx context.Context, q rowQuerier, pageID int) string {
+	var title string
+	if err := q.QueryRowContext(ctx, `SELECT title FROM pages WHERE id = $1`, pageID).Scan(&title); err != nil {
+		logging.FromContext(ctx).Error("Failed to look up page for a notification", "page_id", pageID, "err", err)
+	}
+	return title
+}
+
+// findUser resolves the user referenced by an AddMemberRequest
+func findUser(ctx context.Context, db *sql.DB, req models.AddMemberRequest) (int, error) {
+	var id int
+	var err error
+	switch {
+	case req.UserID != 0:
+		err = db.QueryRowContext(ctx, `SELECT id FROM users WHERE id = $1`, req.UserID).Scan(&id)
+	case req.TelegramID != 0:
+		err = db.QueryRowContext(ctx, `SELECT id FROM users WHERE telegram_id = $1`, req.TelegramID).Scan(&id)
+	default:
+		username := strings.TrimPrefix(req.Username, "@")
+		err = db.QueryRowContext(ctx, `SELECT id FROM users WHERE LOWER(username) = LOWER($1)`, username).Scan(&id)
+	}
+	return id, err
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// inviteColumns is the column list every invite query selects, in scanInvite order
+const inviteColumns = "id, page_id, token, role, expires_at, max_uses, uses, created_at"
+
+func scanInvite(row rowScanner, invite *models.PageInvite) error {
+	var expiresAt sql.NullTime
+	var maxUses sql.NullInt64
+	err := row.Scan(
+		&invite.ID, &invite.PageID, &invite.Token, &invite.Role,
+		&expiresAt, &maxUses, &invite.Uses, &invite.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if expiresAt.Valid {
+		invite.ExpiresAt = &expiresAt.Time
+	}
+	if maxUses.Valid {
+		n := int(maxUses.Int64)
+		invite.MaxUses = &n
+	}
+	return nil
+}
+
+// parseMemberRole accepts the roles that can be granted on a single page;
+// administration comes only with a workspace role
+func parseMemberRole(c *gin.Context, s string) (access.Role, bool) {
+	role, ok := access.ParseRole(s)
+	if !ok || role > access.RoleEditor {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be viewer or editor"})
+		return access.RoleNone, false
+	}
+	return role, true
+}
+
+func memberParams(c *gin.Context) (pageID, memberID int, ok bool) {
+	pageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page ID"})
+		return 0, 0, false
+	}
+	memberID, err = strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return 0, 0, false
+	}
+	return pageID, memberID, true
+}
+
+func newInviteToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+
+	"tma/logging"
+	"tma/models"
+	"tma/notify"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NotificationsHandler manages the notification preferences of the
+// authenticated user
+type NotificationsHandler struct {
+	db *sql.DB
+}
+
+func NewNotificationsHandler(db *sql.DB) *NotificationsHandler {
+	return &NotificationsHandler{db: db}
+}
+
+// GetSettings returns the delivery of every notification kind
+func (h *NotificationsHandler) GetSettings(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	settings, err := h.settings(ctx, userID)
+	if err != nil {
+		dbError(c, err, "Failed to fetch notification settings")
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateSettings changes the delivery of the given notification kinds;
+// kinds left out keep their delivery
+func (h *NotificationsHandler) UpdateSettings(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req models.UpdateNotificationSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if err := notify.ValidatePreferences(req.Delivery); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := notify.SetPreferences(ctx, h.db, userID, req.Delivery); err != nil {
+		logger(c).Error("Failed to save notification preferences", "err", err)
+		dbError(c, err, "Failed to update notification settings")
+		return
+	}
+
+	settings, err := h.settings(ctx, userID)
+	if err != nil {
+		dbError(c, err, "Failed to fetch notification settings")
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+func (h *NotificationsHandler) settings(ctx context.Context, userID int) (*models.NotificationSettings, error) {
+	settings := &models.NotificationSettings{}
+	err := h.db.QueryRowContext(ctx, `SELECT allows_write_to_pm FROM users WHERE id = $1`, userID).Scan(&settings.AllowsWriteToPM)
+	if err != nil {
+		return nil, err
+	}
+	settings.Delivery, err = notify.Preferences(ctx, h.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// rowQuerier is implemented by both *sql.DB and *sql.Tx
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// userDisplayName names a user in notification texts
+func userDisplayName(ctx context.Context, q rowQuerier, userID int) string {
+	var firstName, lastName, username sql.NullString
+	err := q.QueryRowContext(ctx, `SELECT first_name, last_name, username FROM users WHERE id = $1`, userID).Scan(&firstName, &lastName, &username)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to look up user for a notification", "notified_user_id", userID, "err", err)
+	}
+	return notify.DisplayName(firstName.String, lastName.String, username.String)
+}
+
+// queueNotification queues a notification without failing the request
+// that caused it
+func queueNotification(ctx context.Context, notifier *notify.Notifier, db notify.Execer, n notify.Notification) {
+	if err := notifier.Notify(ctx, db, n); err != nil {
+		logging.FromContext(ctx).Error("Failed to queue notification", "kind", n.Kind, "err", err)
+	}
+}
@@ -1,184 +1,120 @@
 package handlers
 
 import (
-	"database/sql"
-	"log"
+	"errors"
 	"net/http"
 	"strconv"
-	"time"
 
+	"tma/access"
+	"tma/analytics"
 	"tma/models"
+	"tma/payments"
+	"tma/repository"
+	"tma/storage"
 
 	"github.com/gin-gonic/gin"
 )
 
 type PagesHandler struct {
-	db *sql.DB
+	pages    repository.PageRepository
+	authz    *access.Authorizer
+	views    *analytics.Recorder
+	store    storage.Storage
+	payments *payments.Service
 }
 
-func NewPagesHandler(db *sql.DB) *PagesHandler {
-	return &PagesHandler{db: db}
+func NewPagesHandler(pages repository.PageRepository, authz *access.Authorizer, views *analytics.Recorder, store storage.Storage, payments *payments.Service) *PagesHandler {
+	return &PagesHandler{pages: pages, authz: authz, views: views, store: store, payments: payments}
 }
 
-// GetPages returns all pages for the authenticated user
+// GetPages returns all pages of the active workspace
 func (h *PagesHandler) GetPages(c *gin.Context) {
-	userID := c.GetInt("user_id")
-	if userID == 0 {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	workspaceID, ok := activeWorkspace(c, access.RoleViewer)
+	if !ok {
 		return
 	}
 
-	query := `
-		SELECT id, user_id, title, json_data, created_at, updated_at
-		FROM pages
-		WHERE user_id = $1 
-		ORDER BY created_at DESC
-	`
-
-	rows, err := h.db.Query(query, userID)
+	pages, err := h.pages.PagesByWorkspace(c.Request.Context(), workspaceID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pages"})
+		dbError(c, err, "Failed to fetch pages")
 		return
 	}
-	defer rows.Close()
-
-	pages := make([]models.Page, 0)
-	for rows.Next() {
-		var page models.Page
-		err := rows.Scan(
-			&page.ID, &page.UserID, &page.Title, &page.JSONData,
-			&page.CreatedAt, &page.UpdatedAt,
-		)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan page"})
-			return
-		}
-		pages = append(pages, page)
-	}
 
 	c.JSON(http.StatusOK, pages)
 }
 
 // GetPage returns a specific page by ID
 func (h *PagesHandler) GetPage(c *gin.Context) {
+	ctx := c.Request.Context()
 	pageID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page ID"})
 		return
 	}
 
-	query := `
-		SELECT id, user_id, title, json_data, created_at, updated_at
-		FROM pages
-		WHERE id = $1
-	`
-
-	var page models.Page
-	err = h.db.QueryRow(query, pageID).Scan(
-		&page.ID, &page.UserID, &page.Title, &page.JSONData,
-		&page.CreatedAt, &page.UpdatedAt,
-	)
-
+	page, err := h.pages.Page(c.Request.Context(), pageID)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, repository.ErrNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Page not found"})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch page"})
-		return
-	}
-
-	c.JSON(http.StatusOK, page)
-}
-
-// CreatePage creates a new page
-func (h *PagesHandler) CreatePage(c *gin.Context) {
-	// Log the request
-	log.Printf("CreatePage: Starting request processing")
-
-	var err error
-
-	// Check database connection
-	if err = h.db.Ping(); err != nil {
-		log.Printf("CreatePage: Database connection error: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection failed"})
+		dbError(c, err, "Failed to fetch page")
 		return
 	}
 
-	// Check if pages table exists
-	var tableExists bool
-	err = h.db.QueryRow("SELECT EXISTS (SELECT FROM information_schema.tables WHERE table_name = 'pages')").Scan(&tableExists)
-	if err != nil {
-		log.Printf("CreatePage: Error checking table existence: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database schema error"})
-		return
-	}
-
-	if !tableExists {
-		log.Printf("CreatePage: Pages table does not exist")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Pages table not found"})
-		return
+	// Paid pages are only returned to their members and buyers; everyone
+	// else gets what is needed to offer the page for sale
+	if page.PriceStars > 0 {
+		entitled, err := h.payments.Entitled(ctx, page.ID, c.GetInt("user_id"))
+		if err != nil {
+			dbError(c, err, "Failed to check access")
+			return
+		}
+		if !entitled {
+			c.JSON(http.StatusPaymentRequired, gin.H{
+				"error":       "Payment required",
+				"id":          page.ID,
+				"title":       page.Title,
+				"description": page.Description,
+				"cover_image": page.CoverImage,
+				"price_stars": page.PriceStars,
+			})
+			return
+		}
 	}
 
-	// Check table structure
-	rows, err := h.db.Query("SELECT column_name, data_type FROM information_schema.columns WHERE table_name = 'pages' ORDER BY ordinal_position")
-	if err != nil {
-		log.Printf("CreatePage: Error checking table structure: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database schema error"})
-		return
-	}
-	defer rows.Close()
+	h.views.Record(analytics.FromRequest(c.Request, page.ID, c.ClientIP()))
 
-	log.Printf("CreatePage: Pages table structure:")
-	for rows.Next() {
-		var columnName, dataType string
-		if err := rows.Scan(&columnName, &dataType); err != nil {
-			log.Printf("CreatePage: Error scanning column info: %v", err)
-			continue
-		}
-		log.Printf("  - %s: %s", columnName, dataType)
-	}
+	c.JSON(http.StatusOK, page)
+}
 
+// CreatePage creates a new page in the active workspace
+func (h *PagesHandler) CreatePage(c *gin.Context) {
 	userID := c.GetInt("user_id")
-	log.Printf("CreatePage: User ID from context: %d", userID)
-
 	if userID == 0 {
-		log.Printf("CreatePage: User not authenticated")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
 	}
 
+	workspaceID, ok := activeWorkspace(c, access.RoleEditor)
+	if !ok {
+		return
+	}
+
 	var req models.CreatePageRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		log.Printf("CreatePage: Failed to bind JSON: %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
 		return
 	}
 
-	log.Printf("CreatePage: Request data - Title: %s, JSONData: %v", req.Title, req.JSONData)
-
-	query := `
-		INSERT INTO pages (user_id, title, json_data)
-		VALUES ($1, $2, $3)
-		RETURNING id, user_id, title, json_data, created_at, updated_at
-	`
-
-	log.Printf("CreatePage: Executing SQL query with userID=%d, title='%s'", userID, req.Title)
-
-	var page models.Page
-	err = h.db.QueryRow(query, userID, req.Title, req.JSONData).Scan(
-		&page.ID, &page.UserID, &page.Title, &page.JSONData,
-		&page.CreatedAt, &page.UpdatedAt,
-	)
-
+	page, err := h.pages.CreatePage(c.Request.Context(), userID, workspaceID, req)
 	if err != nil {
-		log.Printf("CreatePage: Database error: %v", err)
-		log.Printf("CreatePage: Error type: %T", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create page", "details": err.Error()})
+		logger(c).Error("Failed to create page", "err", err)
+		dbError(c, err, "Failed to create page")
 		return
 	}
 
-	log.Printf("CreatePage: Successfully created page with ID: %d", page.ID)
+	logger(c).Debug("Created page", "page_id", page.ID)
 	c.JSON(http.StatusCreated, page)
 }
 
@@ -202,43 +138,17 @@ func (h *PagesHandler) UpdatePage(c *gin.Context) {
 		return
 	}
 
-	// Build dynamic query based on provided fields
-	query := `
-		UPDATE pages
-		SET updated_at = $1
-	`
-	args := []interface{}{time.Now()}
-	argIndex := 2
-
-	if req.Title != "" {
-		query += `, title = $` + strconv.Itoa(argIndex)
-		args = append(args, req.Title)
-		argIndex++
-	}
-
-	if req.JSONData != nil {
-		query += `, json_data = $` + strconv.Itoa(argIndex)
-		args = append(args, req.JSONData)
-		argIndex++
+	if _, ok := authorizePage(c, h.authz, pageID, userID, access.RoleEditor); !ok {
+		return
 	}
 
-	query += ` WHERE id = $` + strconv.Itoa(argIndex) + ` AND user_id = $` + strconv.Itoa(argIndex+1)
-	args = append(args, pageID, userID)
-
-	query += ` RETURNING id, user_id, title, json_data, created_at, updated_at`
-
-	var page models.Page
-	err = h.db.QueryRow(query, args...).Scan(
-		&page.ID, &page.UserID, &page.Title, &page.JSONData,
-		&page.CreatedAt, &page.UpdatedAt,
-	)
-
+	page, err := h.pages.UpdatePage(c.Request.Context(), pageID, req)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, repository.ErrNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Page not found"})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update page"})
+		dbError(c, err, "Failed to update page")
 		return
 	}
 
@@ -259,24 +169,21 @@ func (h *PagesHandler) DeletePage(c *gin.Context) {
 		return
 	}
 
-	query := `DELETE FROM pages WHERE id = $1 AND user_id = $2`
-
-	result, err := h.db.Exec(query, pageID, userID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete page"})
+	if _, ok := authorizePage(c, h.authz, pageID, userID, access.RoleAdmin); !ok {
 		return
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get affected rows"})
+	// Assets used only by this page are deleted with it
+	keys, err := h.pages.DeletePage(c.Request.Context(), pageID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Page not found"})
 		return
 	}
-
-	if rowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Page not found"})
+	if err != nil {
+		dbError(c, err, "Failed to delete page")
 		return
 	}
 
+	deleteObjects(h.store, keys)
 	c.JSON(http.StatusOK, gin.H{"message": "Page deleted successfully"})
 }
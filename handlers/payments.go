@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"tma/access"
+	"tma/models"
+	"tma/payments"
+	"tma/repository"
+	"tma/telegram"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PaymentsHandler sells access to paid pages for Telegram Stars
+type PaymentsHandler struct {
+	pages    repository.PageRepository
+	authz    *access.Authorizer
+	payments *payments.Service
+}
+
+func NewPaymentsHandler(pages repository.PageRepository, authz *access.Authorizer, payments *payments.Service) *PaymentsHandler {
+	return &PaymentsHandler{pages: pages, authz: authz, payments: payments}
+}
+
+// SetPrice makes a page paid, or free again with a price of 0. Members of
+// the page keep their access either way.
+func (h *PaymentsHandler) SetPrice(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	pageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page ID"})
+		return
+	}
+
+	var req models.SetPriceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.PriceStars < 0 || req.PriceStars > payments.MaxPriceStars {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "price_stars must be between 0 and " + strconv.Itoa(payments.MaxPriceStars)})
+		return
+	}
+
+	if _, ok := authorizePage(c, h.authz, pageID, userID, access.RoleAdmin); !ok {
+		return
+	}
+
+	err = h.pages.SetPagePrice(ctx, pageID, req.PriceStars)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Page not found"})
+		return
+	}
+	if err != nil {
+		dbError(c, err, "Failed to update price")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"page_id": pageID, "price_stars": req.PriceStars})
+}
+
+// CreateInvoice returns an invoice link for buying access to a page, to
+// be opened with openInvoice in the Mini App
+func (h *PaymentsHandler) CreateInvoice(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	pageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page ID"})
+		return
+	}
+
+	invoice, err := h.payments.CreateInvoice(c.Request.Context(), pageID, userID, c.GetInt64("telegram_id"))
+	switch {
+	case err == nil:
+	case errors.Is(err, payments.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Page not found"})
+		return
+	case errors.Is(err, payments.ErrNotForSale):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Page is not for sale"})
+		return
+	case errors.Is(err, payments.ErrAlreadyOwned):
+		c.JSON(http.StatusConflict, gin.H{"error": "You already have access to this page"})
+		return
+	case errors.Is(err, payments.ErrInvoicePending):
+		c.JSON(http.StatusConflict, gin.H{"error": "An invoice for this page is already being created"})
+		return
+	case errors.Is(err, telegram.ErrNoToken):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Telegram bot is not configured"})
+		return
+	default:
+		logger(c).Error("Failed to create invoice", "page_id", pageID, "err", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to create invoice"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, invoice)
+}
+
+// ListPurchases returns the purchases of the authenticated user
+func (h *PaymentsHandler) ListPurchases(c *gin.Context) {
+	ctx := c.Request.Context()
+	purchases, err := h.payments.ListByUser(ctx, c.GetInt("user_id"))
+	if err != nil {
+		dbError(c, err, "Failed to fetch purchases")
+		return
+	}
+	c.JSON(http.StatusOK, purchases)
+}
+
+// ListPageSales returns the purchases of a page to its admins
+func (h *PaymentsHandler) ListPageSales(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	pageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page ID"})
+		return
+	}
+
+	if _, ok := authorizePage(c, h.authz, pageID, userID, access.RoleAdmin); !ok {
+		return
+	}
+
+	purchases, err := h.payments.ListByPage(ctx, pageID)
+	if err != nil {
+		dbError(c, err, "Failed to fetch purchases")
+		return
+	}
+	c.JSON(http.StatusOK, purchases)
+}
+
+// RefundPurchase returns the Stars of a purchase to the buyer. Refunds
+// are made by the admins of the purchased page. Once the page is deleted
+// the admins of its workspace can refund it, and so can the buyer, who
+// no longer gets what they paid for.
+func (h *PaymentsHandler) RefundPurchase(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	purchaseID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid purchase ID"})
+		return
+	}
+
+	purchase, err := h.payments.Get(ctx, purchaseID)
+	if errors.Is(err, payments.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Purchase not found"})
+		return
+	}
+	if err != nil {
+		dbError(c, err, "Failed to fetch purchase")
+		return
+	}
+
+	// Buyers see a missing purchase rather than a forbidden one
+	allowed, err := h.canRefund(ctx, purchase, userID)
+	if err != nil {
+		dbError(c, err, "Failed to check access")
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Purchase not found"})
+		return
+	}
+
+	purchase, err = h.payments.Refund(c.Request.Context(), purchaseID)
+	switch {
+	case err == nil:
+	case errors.Is(err, payments.ErrNotRefundable):
+		c.JSON(http.StatusConflict, gin.H{"error": "Only paid purchases can be refunded"})
+		return
+	default:
+		logger(c).Error("Failed to refund purchase", "purchase_id", purchaseID, "err", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to refund purchase"})
+		return
+	}
+
+	c.JSON(http.StatusOK, purchase)
+}
+
+// canRefund reports whether a user may refund a purchase
+func (h *PaymentsHandler) canRefund(ctx context.Context, purchase *models.Purchase, userID int) (bool, error) {
+	if purchase.PageID != nil {
+		role, err := h.authz.PageRole(ctx, *purchase.PageID, userID)
+		if errors.Is(err, access.ErrNotFound) {
+			// Deleted since the purchase was read
+			return false, nil
+		}
+		return role >= access.RoleAdmin, err
+	}
+
+	if purchase.UserID != nil && *purchase.UserID == userID {
+		return true, nil
+	}
+	if purchase.WorkspaceID == nil {
+		return false, nil
+	}
+	role, err := h.authz.WorkspaceRole(ctx, *purchase.WorkspaceID, userID)
+	return role >= access.RoleAdmin, err
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"tma/access"
+	"tma/models"
+	"tma/referral"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReferralsHandler makes referral links and reports the signups they
+// brought in
+type ReferralsHandler struct {
+	db          *sql.DB
+	authz       *access.Authorizer
+	signer      *referral.Signer
+	miniAppLink string
+}
+
+func NewReferralsHandler(db *sql.DB, authz *access.Authorizer, signer *referral.Signer, miniAppLink string) *ReferralsHandler {
+	return &ReferralsHandler{
+		db:          db,
+		authz:       authz,
+		signer:      signer,
+		miniAppLink: strings.TrimRight(miniAppLink, "/"),
+	}
+}
+
+// CreateLink returns a signed start_param carrying the referral code of the
+// authenticated user, and optionally a page to open and a campaign
+func (h *ReferralsHandler) CreateLink(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req models.CreateReferralLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.PageID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page ID"})
+		return
+	}
+	if req.PageID > 0 {
+		if _, ok := authorizePage(c, h.authz, req.PageID, userID, access.RoleViewer); !ok {
+			return
+		}
+	}
+
+	code, err := referral.Code(c.Request.Context(), h.db, userID)
+	if err != nil {
+		dbError(c, err, "Failed to create referral code")
+		return
+	}
+
+	startParam, err := h.signer.Encode(models.StartParam{PageID: req.PageID, Referral: code, Campaign: req.Campaign})
+	switch {
+	case err == nil:
+	case errors.Is(err, referral.ErrInvalidValue):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "campaign may only contain letters and digits"})
+		return
+	case errors.Is(err, referral.ErrTooLong):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "campaign is too long"})
+		return
+	default:
+		dbError(c, err, "Failed to create link")
+		return
+	}
+
+	link := models.ReferralLink{StartParam: startParam}
+	if h.miniAppLink != "" {
+		link.Link = h.miniAppLink + "?startapp=" + url.QueryEscape(startParam)
+	}
+	c.JSON(http.StatusOK, link)
+}
+
+// GetStats returns the signups referred by the authenticated user, in total
+// and per campaign
+func (h *ReferralsHandler) GetStats(c *gin.Context) {
+	stats, err := referral.Stats(c.Request.Context(), h.db, c.GetInt("user_id"))
+	if err != nil {
+		dbError(c, err, "Failed to fetch referral stats")
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"tma/database"
+	"tma/models"
+	"tma/preview"
+	"tma/render"
+	"tma/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RenderHandler serves pages as server-rendered HTML so that shared links
+// are readable outside the Mini App and produce link previews in chats
+type RenderHandler struct {
+	pages     repository.PageRepository
+	previews  *preview.Cache
+	publicURL string
+}
+
+func NewRenderHandler(pages repository.PageRepository, previews *preview.Cache, publicURL string) *RenderHandler {
+	return &RenderHandler{
+		pages:     pages,
+		previews:  previews,
+		publicURL: strings.TrimRight(publicURL, "/"),
+	}
+}
+
+// RenderPage renders a page with Open Graph and Twitter card metadata
+func (h *RenderHandler) RenderPage(c *gin.Context) {
+	page, ok := h.loadPage(c)
+	if !ok {
+		return
+	}
+
+	theme := c.Query("theme")
+
+	// Absolute URLs are only built from PUBLIC_URL: the Host and
+	// X-Forwarded-Proto headers are client-controlled and would let anyone
+	// poison cached previews with links to another site
+	var pageURL, imageURL string
+	if h.publicURL != "" {
+		pageURL = fmt.Sprintf("%s/p/%d", h.publicURL, page.ID)
+		if theme != "" {
+			pageURL += "?theme=" + url.QueryEscape(theme)
+		}
+	}
+	imageURL = h.coverURL(page.CoverImage)
+	if imageURL == "" && h.publicURL != "" {
+		imageURL = fmt.Sprintf("%s/p/%d/preview.png", h.publicURL, page.ID)
+		if theme != "" {
+			imageURL += "?theme=" + url.QueryEscape(theme)
+		}
+	}
+
+	var buf bytes.Buffer
+	data := render.PageData{
+		Title:       page.Title,
+		Description: page.Description,
+		JSONData:    page.JSONData,
+	}
+	// Public pages have no viewer to check a purchase for; paid pages
+	// only show their title and description
+	if page.PriceStars > 0 {
+		data.JSONData = nil
+	}
+	opts := render.Options{
+		Theme:    theme,
+		URL:      pageURL,
+		ImageURL: imageURL,
+	}
+	if err := render.Page(&buf, data, opts); err != nil {
+		logger(c).Error("Failed to render page", "page_id", page.ID, "err", err)
+		c.String(http.StatusUnprocessableEntity, "Page content cannot be rendered")
+		return
+	}
+
+	c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// PreviewImage serves the generated link preview image of a page
+func (h *RenderHandler) PreviewImage(c *gin.Context) {
+	page, ok := h.loadPage(c)
+	if !ok {
+		return
+	}
+
+	doc, err := render.ParseDocument(page.JSONData)
+	if err != nil || page.PriceStars > 0 {
+		doc = &render.Document{}
+	}
+
+	themeName := doc.Theme
+	if t := c.Query("theme"); t != "" {
+		themeName = t
+	}
+	theme := render.LookupTheme(themeName)
+
+	card := preview.Card{
+		Title:    page.Title,
+		Subtitle: render.Description(page.Description, doc),
+	}
+	card.Background, _ = preview.ParseHexColor(theme.Background)
+	card.Foreground, _ = preview.ParseHexColor(theme.Text)
+	card.Hint, _ = preview.ParseHexColor(theme.Hint)
+	card.Accent, _ = preview.ParseHexColor(theme.Button)
+
+	key := fmt.Sprintf("%d:%d:%s", page.ID, page.UpdatedAt.UnixNano(), theme.Name)
+	path, err := h.previews.Path(key, card)
+	if err != nil {
+		logger(c).Error("Failed to render preview", "page_id", page.ID, "err", err)
+		c.String(http.StatusInternalServerError, "Failed to render preview")
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.File(path)
+}
+
+func (h *RenderHandler) loadPage(c *gin.Context) (*models.Page, bool) {
+	ctx := c.Request.Context()
+	pageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid page ID")
+		return nil, false
+	}
+
+	page, err := h.pages.Page(ctx, pageID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.String(http.StatusNotFound, "Page not found")
+			return nil, false
+		}
+		c.String(database.HTTPStatus(ctx, err), "Failed to fetch page")
+		return nil, false
+	}
+
+	return page, true
+}
+
+// coverURL resolves a page cover image against the public URL. Without
+// one only covers that are already absolute are used
+func (h *RenderHandler) coverURL(cover string) string {
+	if cover == "" {
+		return ""
+	}
+	ref, err := url.Parse(cover)
+	if err != nil {
+		return ""
+	}
+	if ref.IsAbs() {
+		return ref.String()
+	}
+	if h.publicURL == "" {
+		return ""
+	}
+	base, err := url.Parse(h.publicURL + "/")
+	if err != nil {
+		return ""
+	}
+	return base.ResolveReference(ref).String()
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"tma/access"
+	"tma/bot"
+	"tma/repository"
+	"tma/telegram"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ShareHandler prepares messages the Mini App lets users send into any
+// chat with shareMessage
+type ShareHandler struct {
+	pages      repository.PageRepository
+	authz      *access.Authorizer
+	client     *telegram.Client
+	miniAppURL string
+	publicURL  string
+}
+
+func NewShareHandler(pages repository.PageRepository, authz *access.Authorizer, client *telegram.Client, miniAppURL, publicURL string) *ShareHandler {
+	return &ShareHandler{
+		pages:      pages,
+		authz:      authz,
+		client:     client,
+		miniAppURL: strings.TrimRight(miniAppURL, "/"),
+		publicURL:  strings.TrimRight(publicURL, "/"),
+	}
+}
+
+// PrepareMessage saves a page card as a prepared inline message for the
+// authenticated user. The Mini App passes the returned id to
+// shareMessage, which must happen before expiration_date.
+func (h *ShareHandler) PrepareMessage(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	pageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page ID"})
+		return
+	}
+
+	if _, ok := authorizePage(c, h.authz, pageID, userID, access.RoleViewer); !ok {
+		return
+	}
+	if !h.client.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Telegram bot is not configured"})
+		return
+	}
+
+	page, err := h.pages.Page(ctx, pageID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Page not found"})
+		return
+	}
+	if err != nil {
+		dbError(c, err, "Failed to fetch page")
+		return
+	}
+
+	prepared, err := h.client.SavePreparedInlineMessage(c.Request.Context(), telegram.SavePreparedInlineMessageParams{
+		UserID:            c.GetInt64("telegram_id"),
+		Result:            bot.PageArticle(pageID, page.Title, page.Description, h.publicURL, h.pageButton(pageID)),
+		AllowUserChats:    true,
+		AllowBotChats:     true,
+		AllowGroupChats:   true,
+		AllowChannelChats: true,
+	})
+	if err != nil {
+		logger(c).Error("Failed to prepare message", "page_id", pageID, "err", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to prepare message"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": prepared.ID, "expiration_date": prepared.ExpirationDate})
+}
+
+// pageButton opens the page in the Mini App, or its public version when
+// no Mini App URL is configured
+func (h *ShareHandler) pageButton(pageID int) *telegram.InlineKeyboardButton {
+	switch {
+	case h.miniAppURL != "":
+		u := h.miniAppURL + "?page=" + url.QueryEscape(strconv.Itoa(pageID))
+		return &telegram.InlineKeyboardButton{Text: "Open page", WebApp: &telegram.WebAppInfo{URL: u}}
+	case h.publicURL != "":
+		return &telegram.InlineKeyboardButton{Text: "Open page", URL: fmt.Sprintf("%s/p/%d", h.publicURL, pageID)}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tma/access"
+	"tma/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultStatsRange = 7 * 24 * time.Hour
+	maxHourlyRange    = 31 * 24 * time.Hour
+	maxStatsRange     = 366 * 24 * time.Hour
+	topReferrersLimit = 10
+)
+
+type StatsHandler struct {
+	db    *sql.DB
+	authz *access.Authorizer
+}
+
+func NewStatsHandler(db *sql.DB, authz *access.Authorizer) *StatsHandler {
+	return &StatsHandler{db: db, authz: authz}
+}
+
+// GetPageStats returns view time series, top referrers and platforms for a
+// page the authenticated user can edit
+func (h *StatsHandler) GetPageStats(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	pageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page ID"})
+		return
+	}
+
+	to := time.Now().UTC()
+	if v := c.Query("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to, expected RFC3339"})
+			return
+		}
+		to = to.UTC()
+	}
+	from := to.Add(-defaultStatsRange)
+	if v := c.Query("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from, expected RFC3339"})
+			return
+		}
+		from = from.UTC()
+	}
+	if !from.Before(to) || to.Sub(from) > maxStatsRange {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid time range"})
+		return
+	}
+
+	granularity := c.Query("granularity")
+	if granularity == "" {
+		granularity = "day"
+		if to.Sub(from) <= 48*time.Hour {
+			granularity = "hour"
+		}
+	}
+	if granularity != "hour" && granularity != "day" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "granularity must be hour or day"})
+		return
+	}
+	if granularity == "hour" && to.Sub(from) > maxHourlyRange {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Time range too large for hourly granularity"})
+		return
+	}
+
+	if _, ok := authorizePage(c, h.authz, pageID, userID, access.RoleEditor); !ok {
+		return
+	}
+
+	stats := models.PageStats{
+		PageID:      pageID,
+		From:        from,
+		To:          to,
+		Granularity: granularity,
+	}
+
+	// Series come from the hourly rollups, with empty buckets filled in
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT s.bucket, COALESCE(SUM(r.views), 0)
+		FROM generate_series(date_trunc($4, $2::timestamp), $3::timestamp, ('1 ' || $4)::interval) AS s(bucket)
+		LEFT JOIN page_view_rollups r
+			ON r.page_id = $1 AND date_trunc($4, r.bucket) = s.bucket
+			AND r.bucket >= date_trunc('hour', $2::timestamp) AND r.bucket < $3::timestamp
+		GROUP BY s.bucket
+		ORDER BY s.bucket
+	`, pageID, from, to, granularity)
+	if err != nil {
+		dbError(c, err, "Failed to fetch stats")
+		return
+	}
+	defer rows.Close()
+
+	stats.Series = make([]models.StatsPoint, 0)
+	for rows.Next() {
+		var point models.StatsPoint
+		if err := rows.Scan(&point.Bucket, &point.Views); err != nil {
+			dbError(c, err, "Failed to scan stats")
+			return
+		}
+		stats.TotalViews += point.Views
+		stats.Series = append(stats.Series, point)
+	}
+	if err := rows.Err(); err != nil {
+		dbError(c, err, "Failed to fetch stats")
+		return
+	}
+
+	stats.TopReferrers, err = h.countEvents(ctx, pageID, from, to, "referrer")
+	if err != nil {
+		dbError(c, err, "Failed to fetch referrers")
+		return
+	}
+
+	stats.Platforms, err = h.countEvents(ctx, pageID, from, to, "platform")
+	if err != nil {
+		dbError(c, err, "Failed to fetch platforms")
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// countEvents groups raw view events by one of the whitelisted columns
+func (h *StatsHandler) countEvents(ctx context.Context, pageID int, from, to time.Time, column string) ([]models.StatsCount, error) {
+	if column != "referrer" && column != "platform" {
+		return nil, fmt.Errorf("countEvents: unsupported column %q", column)
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT `+column+`, COUNT(*)
+		FROM page_view_events
+		WHERE page_id = $1 AND created_at >= $2 AND created_at < $3 AND `+column+` <> ''
+		GROUP BY `+column+`
+		ORDER BY COUNT(*) DESC, `+column+`
+		LIMIT $4
+	`, pageID, from, to, topReferrersLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]models.StatsCount, 0)
+	for rows.Next() {
+		var count models.StatsCount
+		if err := rows.Scan(&count.Value, &count.Views); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+
+	"tma/bot"
+	"tma/telegram"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxUpdateSize limits the size of a webhook request body
+const maxUpdateSize = 1 << 20
+
+// TelegramHandler receives bot updates pushed by Telegram
+type TelegramHandler struct {
+	dispatcher *bot.Dispatcher
+	secret     string
+}
+
+func NewTelegramHandler(dispatcher *bot.Dispatcher, secret string) *TelegramHandler {
+	return &TelegramHandler{dispatcher: dispatcher, secret: secret}
+}
+
+// Webhook handles POST /telegram/webhook/:secret. The secret appears both
+// in the path and, as the secret_token passed to setWebhook, in the
+// X-Telegram-Bot-Api-Secret-Token header; both must match.
+func (h *TelegramHandler) Webhook(c *gin.Context) {
+	ctx := c.Request.Context()
+	if h.secret == "" || !secretEqual(c.Param("secret"), h.secret) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if !secretEqual(c.GetHeader("X-Telegram-Bot-Api-Secret-Token"), h.secret) {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	var update telegram.Update
+	if err := json.NewDecoder(http.MaxBytesReader(c.Writer, c.Request.Body, maxUpdateSize)).Decode(&update); err != nil {
+		// Telegram would keep redelivering an update we can never parse
+		logger(c).Warn("Failed to decode update", "err", err)
+		c.Status(http.StatusOK)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), bot.UpdateTimeout)
+	defer cancel()
+
+	if err := h.dispatcher.Dispatch(ctx, update); err != nil {
+		logger(c).Error("Failed to process update", "update_id", update.UpdateID, "err", err)
+		// A non-2xx status makes Telegram redeliver the update later
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func secretEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
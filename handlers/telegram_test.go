@@ -0,0 +1,49 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tma/bot"
+	"tma/handlers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWebhookSecret(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		configured string
+		path       string
+		header     string
+		want       int
+	}{
+		{"not configured", "", "anything", "anything", http.StatusNotFound},
+		{"wrong path secret", "s3cret", "other", "s3cret", http.StatusNotFound},
+		{"missing header", "s3cret", "s3cret", "", http.StatusUnauthorized},
+		{"wrong header", "s3cret", "s3cret", "other", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Rejected updates never reach the dispatcher or its database
+			h := handlers.NewTelegramHandler(bot.NewDispatcher(nil, bot.NewRouter()), tt.configured)
+			router := gin.New()
+			router.POST("/telegram/webhook/:secret", h.Webhook)
+
+			req := httptest.NewRequest(http.MethodPost, "/telegram/webhook/"+tt.path, strings.NewReader(`{"update_id":1}`))
+			if tt.header != "" {
+				req.Header.Set("X-Telegram-Bot-Api-Secret-Token", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"tma/auth"
+	"tma/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authenticateStream validates the JWT of a streaming request. WebSocket
+// and EventSource clients cannot set headers, so besides the usual
+// Authorization header the token is also accepted as ?token=.
+// It writes the error response itself and reports whether to continue.
+func authenticateStream(c *gin.Context, jwtManager *auth.JWTManager) (*auth.Claims, bool) {
+	tokenString := c.Query("token")
+	if authHeader := c.GetHeader("Authorization"); tokenString == "" && strings.HasPrefix(authHeader, "Bearer ") {
+		tokenString = strings.TrimPrefix(authHeader, "Bearer ")
+	}
+	if tokenString == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization required"})
+		return nil, false
+	}
+
+	claims, err := jwtManager.ValidateToken(tokenString)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return nil, false
+	}
+	c.Request = c.Request.WithContext(logging.With(c.Request.Context(), "user_id", claims.UserID))
+	return claims, true
+}
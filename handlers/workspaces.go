@@ -0,0 +1,512 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"tma/access"
+	"tma/auth"
+	"tma/models"
+	"tma/notify"
+	"tma/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WorkspacesHandler manages workspaces, their members and switching the
+// active workspace
+type WorkspacesHandler struct {
+	db         *sql.DB
+	pages      repository.PageRepository
+	authz      *access.Authorizer
+	jwtManager *auth.JWTManager
+	notifier   *notify.Notifier
+}
+
+func NewWorkspacesHandler(db *sql.DB, pages repository.PageRepository, authz *access.Authorizer, jwtManager *auth.JWTManager, notifier *notify.Notifier) *WorkspacesHandler {
+	return &WorkspacesHandler{
+		db:         db,
+		pages:      pages,
+		authz:      authz,
+		jwtManager: jwtManager,
+		notifier:   notifier,
+	}
+}
+
+// ListWorkspaces returns the workspaces the user is a member of
+func (h *WorkspacesHandler) ListWorkspaces(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	current := c.GetInt("workspace_id")
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT w.id, w.name, w.personal, m.role, w.created_at
+		FROM workspace_members m
+		JOIN workspaces w ON w.id = m.workspace_id
+		WHERE m.user_id = $1
+		ORDER BY w.personal DESC, w.name
+	`, userID)
+	if err != nil {
+		dbError(c, err, "Failed to fetch workspaces")
+		return
+	}
+	defer rows.Close()
+
+	workspaces := make([]models.Workspace, 0)
+	for rows.Next() {
+		var w models.Workspace
+		if err := rows.Scan(&w.ID, &w.Name, &w.Personal, &w.Role, &w.CreatedAt); err != nil {
+			dbError(c, err, "Failed to scan workspace")
+			return
+		}
+		w.Current = w.ID == current
+		workspaces = append(workspaces, w)
+	}
+	if err := rows.Err(); err != nil {
+		dbError(c, err, "Failed to fetch workspaces")
+		return
+	}
+
+	c.JSON(http.StatusOK, workspaces)
+}
+
+// CreateWorkspace creates a team workspace owned by the user
+func (h *WorkspacesHandler) CreateWorkspace(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+
+	var req models.CreateWorkspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		dbError(c, err, "Failed to create workspace")
+		return
+	}
+	defer tx.Rollback()
+
+	w := models.Workspace{Role: access.RoleOwner.String()}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO workspaces (name, owner_id) VALUES ($1, $2)
+		RETURNING id, name, personal, created_at
+	`, strings.TrimSpace(req.Name), userID).Scan(&w.ID, &w.Name, &w.Personal, &w.CreatedAt)
+	if err != nil {
+		logger(c).Error("Failed to create workspace", "err", err)
+		dbError(c, err, "Failed to create workspace")
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO workspace_members (workspace_id, user_id, role) VALUES ($1, $2, $3)`, w.ID, userID, w.Role)
+	if err != nil {
+		dbError(c, err, "Failed to create workspace")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		dbError(c, err, "Failed to create workspace")
+		return
+	}
+
+	c.JSON(http.StatusCreated, w)
+}
+
+// UpdateWorkspace renames a workspace
+func (h *WorkspacesHandler) UpdateWorkspace(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	workspaceID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workspace ID"})
+		return
+	}
+
+	var req models.UpdateWorkspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	role, ok := authorizeWorkspace(c, h.authz, workspaceID, userID, access.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	w := models.Workspace{Role: role.String(), Current: workspaceID == c.GetInt("workspace_id")}
+	err = h.db.QueryRowContext(ctx, `
+		UPDATE workspaces SET name = $1 WHERE id = $2
+		RETURNING id, name, personal, created_at
+	`, strings.TrimSpace(req.Name), workspaceID).Scan(&w.ID, &w.Name, &w.Personal, &w.CreatedAt)
+	if err != nil {
+		dbError(c, err, "Failed to update workspace")
+		return
+	}
+
+	c.JSON(http.StatusOK, w)
+}
+
+// DeleteWorkspace deletes an empty team workspace. Pages have to be moved
+// or deleted first, so their assets are cleaned up properly.
+func (h *WorkspacesHandler) DeleteWorkspace(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	workspaceID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workspace ID"})
+		return
+	}
+
+	if _, ok := authorizeWorkspace(c, h.authz, workspaceID, userID, access.RoleOwner); !ok {
+		return
+	}
+
+	var personal, hasPages bool
+	err = h.db.QueryRowContext(ctx, `
+		SELECT personal, EXISTS (SELECT 1 FROM pages WHERE workspace_id = $1)
+		FROM workspaces WHERE id = $1
+	`, workspaceID).Scan(&personal, &hasPages)
+	if err != nil {
+		dbError(c, err, "Failed to delete workspace")
+		return
+	}
+	if personal {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Personal workspace cannot be deleted"})
+		return
+	}
+	if hasPages {
+		c.JSON(http.StatusConflict, gin.H{"error": "Workspace still has pages"})
+		return
+	}
+
+	// Re-checked in the statement in case a page was added meanwhile
+	result, err := h.db.ExecContext(ctx, `
+		DELETE FROM workspaces
+		WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM pages WHERE workspace_id = $1)
+	`, workspaceID)
+	if err != nil {
+		dbError(c, err, "Failed to delete workspace")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Workspace still has pages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Workspace deleted successfully"})
+}
+
+// SwitchWorkspace issues a new token with the workspace as the active
+// one. Clients that prefer not to swap tokens can send the X-Workspace-ID
+// header on each request instead.
+func (h *WorkspacesHandler) SwitchWorkspace(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	workspaceID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workspace ID"})
+		return
+	}
+
+	role, ok := authorizeWorkspace(c, h.authz, workspaceID, userID, access.RoleViewer)
+	if !ok {
+		return
+	}
+
+	w := models.Workspace{Role: role.String(), Current: true}
+	err = h.db.QueryRowContext(ctx, `SELECT id, name, personal, created_at FROM workspaces WHERE id = $1`, workspaceID).
+		Scan(&w.ID, &w.Name, &w.Personal, &w.CreatedAt)
+	if err != nil {
+		dbError(c, err, "Failed to fetch workspace")
+		return
+	}
+
+	user := models.User{ID: userID}
+	if telegramID, ok := c.Get("telegram_id"); ok {
+		user.TelegramID, _ = telegramID.(int64)
+	}
+	// The personal workspace is the default, so it is not pinned in the token
+	tokenWorkspace := w.ID
+	if w.Personal {
+		tokenWorkspace = 0
+	}
+	token, err := h.jwtManager.GenerateWorkspaceToken(user, tokenWorkspace)
+	if err != nil {
+		dbError(c, err, "Failed to generate token")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SwitchWorkspaceResponse{Token: token, Workspace: w})
+}
+
+// ListWorkspaceMembers returns the members of a workspace
+func (h *WorkspacesHandler) ListWorkspaceMembers(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	workspaceID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workspace ID"})
+		return
+	}
+
+	if _, ok := authorizeWorkspace(c, h.authz, workspaceID, userID, access.RoleViewer); !ok {
+		return
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT u.id, u.telegram_id, COALESCE(u.username, ''), COALESCE(u.first_name, ''), COALESCE(u.last_name, ''),
+			m.role, m.created_at
+		FROM workspace_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.workspace_id = $1
+		ORDER BY m.created_at
+	`, workspaceID)
+	if err != nil {
+		dbError(c, err, "Failed to fetch members")
+		return
+	}
+	defer rows.Close()
+
+	members := make([]models.WorkspaceMember, 0)
+	for rows.Next() {
+		var m models.WorkspaceMember
+		if err := rows.Scan(&m.UserID, &m.TelegramID, &m.Username, &m.FirstName, &m.LastName, &m.Role, &m.CreatedAt); err != nil {
+			dbError(c, err, "Failed to scan member")
+			return
+		}
+		members = append(members, m)
+	}
+	if err := rows.Err(); err != nil {
+		dbError(c, err, "Failed to fetch members")
+		return
+	}
+
+	c.JSON(http.StatusOK, members)
+}
+
+// AddWorkspaceMember adds a user to a team workspace or changes their
+// role. Admins can grant up to admin; the owner role is never granted.
+func (h *WorkspacesHandler) AddWorkspaceMember(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	workspaceID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workspace ID"})
+		return
+	}
+
+	var req models.AddMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.UserID == 0 && req.TelegramID == 0 && req.Username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "One of user_id, telegram_id or username is required"})
+		return
+	}
+	role, ok := parseWorkspaceRole(c, req.Role)
+	if !ok {
+		return
+	}
+
+	if _, ok := authorizeWorkspace(c, h.authz, workspaceID, userID, access.RoleAdmin); !ok {
+		return
+	}
+	if !h.teamWorkspace(c, workspaceID) {
+		return
+	}
+
+	memberID, err := findUser(ctx, h.db, req)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		dbError(c, err, "Failed to find user")
+		return
+	}
+
+	// The owner's row is never overwritten
+	result, err := h.db.ExecContext(ctx, `
+		INSERT INTO workspace_members (workspace_id, user_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (workspace_id, user_id) DO UPDATE SET role = EXCLUDED.role
+		WHERE workspace_members.role <> 'owner'
+	`, workspaceID, memberID, role.String())
+	if err != nil {
+		logger(c).Error("Failed to add workspace member", "member_id", memberID, "workspace_id", workspaceID, "err", err)
+		dbError(c, err, "Failed to add member")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot change the role of the workspace owner"})
+		return
+	}
+
+	if memberID != userID {
+		var name string
+		if err := h.db.QueryRowContext(ctx, `SELECT name FROM workspaces WHERE id = $1`, workspaceID).Scan(&name); err != nil {
+			logger(c).Error("Failed to look up workspace", "workspace_id", workspaceID, "err", err)
+		}
+		queueNotification(ctx, h.notifier, h.db, notify.Notification{
+			UserID: memberID,
+			Kind:   notify.KindWorkspaceInvite,
+			Text:   fmt.Sprintf("%s added you to the workspace \"%s\" as %s", userDisplayName(ctx, h.db, userID), name, role),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user_id": memberID, "role": role.String()})
+}
+
+// UpdateWorkspaceMember changes the role of a workspace member
+func (h *WorkspacesHandler) UpdateWorkspaceMember(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	workspaceID, memberID, ok := workspaceMemberParams(c)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	role, ok := parseWorkspaceRole(c, req.Role)
+	if !ok {
+		return
+	}
+
+	if _, ok := authorizeWorkspace(c, h.authz, workspaceID, userID, access.RoleAdmin); !ok {
+		return
+	}
+
+	result, err := h.db.ExecContext(ctx, `
+		UPDATE workspace_members SET role = $1
+		WHERE workspace_id = $2 AND user_id = $3 AND role <> 'owner'
+	`, role.String(), workspaceID, memberID)
+	if err != nil {
+		dbError(c, err, "Failed to update member")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user_id": memberID, "role": role.String()})
+}
+
+// RemoveWorkspaceMember removes a member from a workspace. Admins can
+// remove anyone but the owner; members can remove themselves to leave.
+func (h *WorkspacesHandler) RemoveWorkspaceMember(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	workspaceID, memberID, ok := workspaceMemberParams(c)
+	if !ok {
+		return
+	}
+
+	min := access.RoleAdmin
+	if memberID == userID {
+		min = access.RoleViewer
+	}
+	if _, ok := authorizeWorkspace(c, h.authz, workspaceID, userID, min); !ok {
+		return
+	}
+
+	result, err := h.db.ExecContext(ctx, `
+		DELETE FROM workspace_members
+		WHERE workspace_id = $1 AND user_id = $2 AND role <> 'owner'
+	`, workspaceID, memberID)
+	if err != nil {
+		dbError(c, err, "Failed to remove member")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
+
+// MovePage moves a page to another workspace. The user needs admin on the
+// page and at least editor in the target workspace.
+func (h *WorkspacesHandler) MovePage(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	pageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page ID"})
+		return
+	}
+
+	var req models.MovePageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if _, ok := authorizePage(c, h.authz, pageID, userID, access.RoleAdmin); !ok {
+		return
+	}
+	if _, ok := authorizeWorkspace(c, h.authz, req.WorkspaceID, userID, access.RoleEditor); !ok {
+		return
+	}
+
+	page, err := h.pages.MovePage(ctx, pageID, req.WorkspaceID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Page not found"})
+		return
+	}
+	if err != nil {
+		dbError(c, err, "Failed to move page")
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// teamWorkspace rejects membership changes on personal workspaces
+func (h *WorkspacesHandler) teamWorkspace(c *gin.Context, workspaceID int) bool {
+	ctx := c.Request.Context()
+	var personal bool
+	if err := h.db.QueryRowContext(ctx, `SELECT personal FROM workspaces WHERE id = $1`, workspaceID).Scan(&personal); err != nil {
+		dbError(c, err, "Failed to fetch workspace")
+		return false
+	}
+	if personal {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Personal workspaces cannot have members; share pages instead"})
+		return false
+	}
+	return true
+}
+
+// parseWorkspaceRole accepts the roles that can be granted in a workspace
+func parseWorkspaceRole(c *gin.Context, s string) (access.Role, bool) {
+	role, ok := access.ParseRole(s)
+	if !ok || role == access.RoleOwner {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be viewer, editor or admin"})
+		return access.RoleNone, false
+	}
+	return role, true
+}
+
+func workspaceMemberParams(c *gin.Context) (workspaceID, memberID int, ok bool) {
+	workspaceID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workspace ID"})
+		return 0, 0, false
+	}
+	memberID, err = strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return 0, 0, false
+	}
+	return workspaceID, memberID, true
+}
@@ -0,0 +1,80 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"tma/database"
+)
+
+// Database pings the database
+func Database(db *sql.DB) func(ctx context.Context) error {
+	return db.PingContext
+}
+
+// Migrations fails while migrations of this build are not applied or an
+// applied one differs from its file. Migrations from a newer build are
+// fine, so old instances stay ready during a rolling deploy.
+func Migrations(migrator *database.Migrator) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		pending, modified := 0, 0
+		for _, status := range statuses {
+			switch status.State {
+			case "pending":
+				pending++
+			case "modified":
+				modified++
+			}
+		}
+		if pending > 0 || modified > 0 {
+			return fmt.Errorf("%d pending and %d modified migrations", pending, modified)
+		}
+		return nil
+	}
+}
+
+// Heartbeat is beaten by a background worker on every pass of its loop
+type Heartbeat struct {
+	last atomic.Int64
+}
+
+func (h *Heartbeat) Beat() {
+	h.last.Store(time.Now().UnixNano())
+}
+
+// Last returns when Beat was last called, or the zero time if never
+func (h *Heartbeat) Last() time.Time {
+	last := h.last.Load()
+	if last == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, last)
+}
+
+// Worker is a background worker that beats a Heartbeat
+type Worker interface {
+	Heartbeat() time.Time
+}
+
+// Alive fails when the worker has not started or has not beaten its
+// heartbeat for maxAge, which means its loop is stuck
+func Alive(worker Worker, maxAge time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		last := worker.Heartbeat()
+		if last.IsZero() {
+			return errors.New("not started")
+		}
+		if age := time.Since(last); age > maxAge {
+			return fmt.Errorf("no heartbeat for %s", age.Round(time.Second))
+		}
+		return nil
+	}
+}
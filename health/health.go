@@ -0,0 +1,123 @@
+// Package health runs the checks behind the liveness and readiness
+// endpoints. Results are cached for a short time, so load balancers
+// probing every instance often do not turn into a steady load on the
+// database.
+package health
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check is a single named check
+type Check struct {
+	Name  string
+	Check func(ctx context.Context) error
+	// Optional checks are reported but do not fail the report
+	Optional bool
+}
+
+// Result is the outcome of one check. Reports are served publicly, so the
+// error of a failed check, which may name hosts and addresses, is only
+// logged.
+type Result struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Optional   bool   `json:"optional,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// Report is the outcome of all checks of a Checker
+type Report struct {
+	Status    string    `json:"status"`
+	Checks    []Result  `json:"checks"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// OK reports whether every required check passed
+func (r Report) OK() bool {
+	return r.Status == StatusOK
+}
+
+// Checker runs a set of checks concurrently, each bounded by the timeout,
+// and reuses the report for ttl
+type Checker struct {
+	timeout time.Duration
+	ttl     time.Duration
+
+	mu     sync.Mutex
+	checks []Check
+	report *Report
+}
+
+func NewChecker(timeout, ttl time.Duration) *Checker {
+	return &Checker{timeout: timeout, ttl: ttl}
+}
+
+// Add registers a check. Checks are reported in the order they were added.
+func (c *Checker) Add(check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checks = append(c.checks, check)
+	c.report = nil
+}
+
+// Run returns the cached report, or runs the checks when it is older than
+// the ttl. Concurrent callers wait for the same run instead of starting
+// their own.
+func (c *Checker) Run() Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.report != nil && time.Since(c.report.CheckedAt) < c.ttl {
+		return *c.report
+	}
+
+	report := Report{Status: StatusOK, Checks: make([]Result, len(c.checks)), CheckedAt: time.Now()}
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			report.Checks[i] = c.run(check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusOK && !result.Optional {
+			report.Status = StatusFail
+		}
+	}
+	c.report = &report
+	return report
+}
+
+func (c *Checker) run(check Check) Result {
+	// Probes are cached for everyone, so the caller going away must not
+	// cut a check short
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Check(ctx)
+	result := Result{
+		Name:       check.Name,
+		Status:     StatusOK,
+		Optional:   check.Optional,
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		result.Status = StatusFail
+		slog.Warn("Health check failed", "check", check.Name, "optional", check.Optional, "err", err)
+	}
+	return result
+}
@@ -0,0 +1,24 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+)
+
+// DominantColor returns the average opaque color of img as #rrggbb. It is
+// meant as a solid placeholder while the real image loads, so a plain
+// average is good enough.
+func DominantColor(img *image.NRGBA) string {
+	var r, g, b, n uint64
+	for i := 0; i+3 < len(img.Pix); i += 4 {
+		a := uint64(img.Pix[i+3])
+		r += uint64(img.Pix[i]) * a
+		g += uint64(img.Pix[i+1]) * a
+		b += uint64(img.Pix[i+2]) * a
+		n += a
+	}
+	if n == 0 {
+		return "#000000"
+	}
+	return fmt.Sprintf("#%02x%02x%02x", r/n, g/n, b/n)
+}
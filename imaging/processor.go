@@ -0,0 +1,342 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	_ "image/gif"
+
+	"tma/health"
+	"tma/metrics"
+	"tma/storage"
+)
+
+// Asset processing states stored in assets.status
+const (
+	StatusPending     = "pending"
+	StatusProcessing  = "processing"
+	StatusReady       = "ready"
+	StatusFailed      = "failed"
+	StatusUnsupported = "unsupported"
+)
+
+// VariantWidths are the responsive widths generated for every image
+var VariantWidths = []int{320, 640, 1280}
+
+const (
+	// maxWidth caps the stored original, phones easily produce 4000px+
+	maxWidth = 2048
+	// maxPixels guards against decompression bombs
+	maxPixels = 50_000_000
+
+	jpegQuality        = 82
+	placeholderWidth   = 32
+	pollInterval       = 30 * time.Second
+	staleProcessingAge = 10 * time.Minute
+	// jobTimeout bounds the storage and database calls of one asset, well
+	// below staleProcessingAge so that no other instance claims it meanwhile
+	jobTimeout = 5 * time.Minute
+	// statusTimeout bounds recording the failure of a job, which may have
+	// run out of time itself
+	statusTimeout = 10 * time.Second
+)
+
+var (
+	jobs        = metrics.NewCounter("tma_asset_jobs_total", "Processed assets by resulting status.", "status")
+	jobDuration = metrics.NewHistogram("tma_asset_job_duration_seconds", "Time spent processing an asset.", metrics.DefaultBuckets)
+)
+
+// Processor optimizes uploaded images in the background. Work is claimed
+// from the assets table, so pending assets survive restarts and several
+// instances can run processors side by side.
+type Processor struct {
+	db    *sql.DB
+	store storage.Storage
+
+	heartbeat health.Heartbeat
+	wake      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	once      sync.Once
+}
+
+func NewProcessor(db *sql.DB, store storage.Storage) *Processor {
+	return &Processor{
+		db:    db,
+		store: store,
+		wake:  make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// Enqueue signals that a new asset is waiting. The asset itself is found
+// through its pending status, so a missed signal only delays processing.
+func (p *Processor) Enqueue() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run processes pending assets until Close is called
+func (p *Processor) Run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		p.heartbeat.Beat()
+		for p.processNext() {
+			p.heartbeat.Beat()
+			select {
+			case <-p.stop:
+				return
+			default:
+			}
+		}
+
+		select {
+		case <-p.stop:
+			return
+		case <-p.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Heartbeat returns when the loop last ran, for health checks
+func (p *Processor) Heartbeat() time.Time {
+	return p.heartbeat.Last()
+}
+
+// Close stops the processor after the asset currently being processed
+func (p *Processor) Close() {
+	p.once.Do(func() {
+		close(p.stop)
+		<-p.done
+	})
+}
+
+type job struct {
+	id          int
+	key         string
+	contentType string
+}
+
+// processNext claims and processes a single asset. It reports whether an
+// asset was found, so the caller keeps going until the queue is drained.
+func (p *Processor) processNext() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+	defer cancel()
+
+	var j job
+	err := p.db.QueryRowContext(ctx, `
+		UPDATE assets SET status = $1, processing_started_at = CURRENT_TIMESTAMP
+		WHERE id = (
+			SELECT id FROM assets
+			WHERE status = $2
+			OR (status = $1 AND processing_started_at < CURRENT_TIMESTAMP - $3::interval)
+			ORDER BY id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, storage_key, content_type
+	`, StatusProcessing, StatusPending, fmt.Sprintf("%d seconds", int(staleProcessingAge.Seconds()))).Scan(&j.id, &j.key, &j.contentType)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	if err != nil {
+		slog.Error("Failed to claim asset", "err", err)
+		return false
+	}
+
+	start := time.Now()
+	status := StatusReady
+	defer func() {
+		jobDuration.Observe(time.Since(start).Seconds())
+		jobs.Inc(status)
+	}()
+	if err := p.process(ctx, j); err != nil {
+		status = StatusFailed
+		if err == errUnsupported {
+			status = StatusUnsupported
+		} else {
+			slog.Warn("Failed to process asset", "asset_id", j.id, "err", err)
+		}
+		p.setStatus(j.id, status)
+	}
+	return true
+}
+
+func (p *Processor) setStatus(id int, status string) {
+	ctx, cancel := context.WithTimeout(context.Background(), statusTimeout)
+	defer cancel()
+	if _, err := p.db.ExecContext(ctx, `UPDATE assets SET status = $1 WHERE id = $2`, status, id); err != nil {
+		slog.Error("Failed to update asset status", "asset_id", id, "err", err)
+	}
+}
+
+var errUnsupported = errors.New("unsupported image format")
+
+type variant struct {
+	width, height int
+	key           string
+	contentType   string
+	size          int
+}
+
+func (p *Processor) process(ctx context.Context, j job) error {
+	r, err := p.store.Open(ctx, j.key)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		// Anything without a decoder in the standard library
+		return errUnsupported
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return fmt.Errorf("image too large: %dx%d", cfg.Width, cfg.Height)
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	img := ToNRGBA(decoded)
+	if format == "jpeg" {
+		img = ApplyOrientation(img, Orientation(data))
+	}
+
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	key, size := j.key, len(data)
+	variants := make([]variant, 0, len(VariantWidths))
+
+	// GIFs may be animated, re-encoding would keep only the first frame,
+	// so they only get dimensions and placeholders
+	if format == "jpeg" || format == "png" {
+		// Re-encoding drops EXIF (including GPS) and PNG text chunks along
+		// with other metadata, so every JPEG and PNG goes through it. The
+		// result is stored under a new key that replaces the original
+		// together with its size below.
+		w, h := Fit(width, height, maxWidth)
+		original := Resize(img, w, h)
+		encoded, err := encode(original, format)
+		if err != nil {
+			return err
+		}
+		key = ProcessedKey(j.key)
+		if err := p.store.Put(ctx, key, bytes.NewReader(encoded), int64(len(encoded)), j.contentType); err != nil {
+			return err
+		}
+		img, width, height, size = original, w, h, len(encoded)
+
+		for _, vw := range VariantWidths {
+			if vw >= width {
+				break
+			}
+			w, h := Fit(width, height, vw)
+			encoded, err := encode(Resize(img, w, h), format)
+			if err != nil {
+				return err
+			}
+			key := VariantKey(j.key, w)
+			if err := p.store.Put(ctx, key, bytes.NewReader(encoded), int64(len(encoded)), j.contentType); err != nil {
+				return err
+			}
+			variants = append(variants, variant{width: w, height: h, key: key, contentType: j.contentType, size: len(encoded)})
+		}
+	}
+
+	pw, ph := Fit(width, height, placeholderWidth)
+	small := Resize(img, pw, ph)
+	hash, err := Blurhash(small, 4, 3)
+	if err != nil {
+		return err
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM asset_variants WHERE asset_id = $1`, j.id); err != nil {
+		return err
+	}
+	for _, v := range variants {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO asset_variants (asset_id, width, height, storage_key, content_type, size)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, j.id, v.width, v.height, v.key, v.contentType, v.size)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE assets
+		SET storage_key = $1, width = $2, height = $3, size = $4, blurhash = $5, dominant_color = $6, status = $7
+		WHERE id = $8
+	`, key, width, height, size, hash, DominantColor(small), StatusReady, j.id)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if key != j.key {
+		if err := p.store.Delete(ctx, j.key); err != nil {
+			slog.Warn("Failed to delete asset original", "asset_id", j.id, "err", err)
+		}
+	}
+	return nil
+}
+
+// ProcessedKey derives the storage key of the re-encoded original
+func ProcessedKey(key string) string {
+	ext := path.Ext(key)
+	return fmt.Sprintf("%s_p%s", strings.TrimSuffix(key, ext), ext)
+}
+
+// VariantKey derives the storage key of a resized variant from the original
+func VariantKey(key string, width int) string {
+	ext := path.Ext(key)
+	return fmt.Sprintf("%s_w%d%s", strings.TrimSuffix(key, ext), width, ext)
+}
+
+func encode(img image.Image, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	case "png":
+		enc := png.Encoder{CompressionLevel: png.BestCompression}
+		err = enc.Encode(&buf, img)
+	default:
+		err = errUnsupported
+	}
+	return buf.Bytes(), err
+}
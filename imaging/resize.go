@@ -0,0 +1,108 @@
+package imaging
+
+import (
+	"image"
+	"math"
+)
+
+// Fit returns the size of an image scaled down to at most maxWidth wide,
+// keeping the aspect ratio. Images are never scaled up.
+func Fit(width, height, maxWidth int) (int, int) {
+	if width <= maxWidth || width == 0 {
+		return width, height
+	}
+	h := int(math.Round(float64(height) * float64(maxWidth) / float64(width)))
+	if h < 1 {
+		h = 1
+	}
+	return maxWidth, h
+}
+
+// Resize scales src to w x h using area averaging, which gives good results
+// for the downscaling done here. Colors are averaged premultiplied by alpha
+// so transparent pixels do not bleed into their neighbours.
+func Resize(src *image.NRGBA, w, h int) *image.NRGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	if sw == w && sh == h {
+		return src
+	}
+
+	// Horizontal pass: sw x sh -> w x sh
+	tmp := make([]float64, w*sh*4)
+	xw := weights(sw, w)
+	for y := 0; y < sh; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x, ws := range xw {
+			var r, g, b, a float64
+			for _, wt := range ws {
+				i := wt.index * 4
+				alpha := float64(row[i+3]) * wt.weight
+				r += float64(row[i]) * alpha
+				g += float64(row[i+1]) * alpha
+				b += float64(row[i+2]) * alpha
+				a += alpha
+			}
+			o := (y*w + x) * 4
+			tmp[o], tmp[o+1], tmp[o+2], tmp[o+3] = r, g, b, a
+		}
+	}
+
+	// Vertical pass: w x sh -> w x h
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	yw := weights(sh, h)
+	for y, ws := range yw {
+		for x := 0; x < w; x++ {
+			var r, g, b, a float64
+			for _, wt := range ws {
+				i := (wt.index*w + x) * 4
+				r += tmp[i] * wt.weight
+				g += tmp[i+1] * wt.weight
+				b += tmp[i+2] * wt.weight
+				a += tmp[i+3] * wt.weight
+			}
+			o := dst.PixOffset(x, y)
+			if a > 0 {
+				dst.Pix[o] = clamp8(r / a)
+				dst.Pix[o+1] = clamp8(g / a)
+				dst.Pix[o+2] = clamp8(b / a)
+			}
+			dst.Pix[o+3] = clamp8(a)
+		}
+	}
+	return dst
+}
+
+type weight struct {
+	index  int
+	weight float64
+}
+
+// weights computes, for every destination pixel, the source pixels it
+// covers and the fraction each contributes
+func weights(src, dst int) [][]weight {
+	scale := float64(src) / float64(dst)
+	out := make([][]weight, dst)
+	for i := range out {
+		start := float64(i) * scale
+		end := start + scale
+		for j := int(start); j < src && float64(j) < end; j++ {
+			lo := math.Max(start, float64(j))
+			hi := math.Min(end, float64(j+1))
+			if hi > lo {
+				out[i] = append(out[i], weight{index: j, weight: (hi - lo) / scale})
+			}
+		}
+	}
+	return out
+}
+
+func clamp8(v float64) uint8 {
+	v = math.Round(v)
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
@@ -0,0 +1,218 @@
+package logging
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Redacted replaces everything the redaction layer removes
+const Redacted = "[REDACTED]"
+
+// maxRedactDepth is how deep values logged as any are walked; anything
+// nested deeper is dropped
+const maxRedactDepth = 8
+
+// sensitiveKeys are attribute keys, struct field and map key names whose
+// values are never logged
+var sensitiveKeys = map[string]bool{
+	"authorization": true,
+	"body":          true,
+	"content":       true,
+	"hash":          true,
+	"init_data":     true,
+	"initdata":      true,
+	"json_data":     true,
+	"jsondata":      true,
+	"password":      true,
+	"payload":       true,
+	"secret":        true,
+	"signature":     true,
+	"token":         true,
+}
+
+var (
+	// jwtPattern matches JSON Web Tokens, whose header always starts with {"
+	jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	// botTokenPattern matches Telegram bot tokens, also inside API URLs
+	botTokenPattern = regexp.MustCompile(`\d{5,}:[A-Za-z0-9_-]{30,}`)
+	// initDataPattern matches the fields of Telegram init data, raw or
+	// URL-encoded once
+	initDataPattern = regexp.MustCompile(`(?i)\b(query_id|user|receiver|chat|auth_date|hash|signature|chat_instance)(=|%3D)[^&\s"]*`)
+	// bearerPattern matches Authorization header values
+	bearerPattern = regexp.MustCompile(`(?i)\bbearer\s+\S+`)
+)
+
+// RedactString removes tokens and init data from free text such as log
+// messages and error strings
+func RedactString(s string) string {
+	if !strings.ContainsAny(s, ".:=%") && !strings.Contains(strings.ToLower(s), "bearer") {
+		return s
+	}
+	s = jwtPattern.ReplaceAllString(s, Redacted)
+	s = botTokenPattern.ReplaceAllString(s, Redacted)
+	s = bearerPattern.ReplaceAllString(s, "Bearer "+Redacted)
+	s = initDataPattern.ReplaceAllString(s, "${1}${2}"+Redacted)
+	return s
+}
+
+// RedactHandler removes sensitive values from records before passing
+// them on: the values of sensitive keys, also as the fields and map keys
+// of structs and maps logged as any, and tokens and init data found in
+// messages, strings and errors
+type RedactHandler struct {
+	next slog.Handler
+}
+
+func NewRedactHandler(next slog.Handler) *RedactHandler {
+	return &RedactHandler{next: next}
+}
+
+func (h *RedactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *RedactHandler) Handle(ctx context.Context, r slog.Record) error {
+	redacted := slog.NewRecord(r.Time, r.Level, RedactString(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *RedactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = redactAttr(a)
+	}
+	return &RedactHandler{next: h.next.WithAttrs(redacted)}
+}
+
+func (h *RedactHandler) WithGroup(name string) slog.Handler {
+	return &RedactHandler{next: h.next.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, Redacted)
+	}
+
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, RedactString(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		redacted := make([]any, len(group))
+		for i, ga := range group {
+			redacted[i] = redactAttr(ga)
+		}
+		return slog.Group(a.Key, redacted...)
+	case slog.KindAny:
+		return slog.Attr{Key: a.Key, Value: redactValue(reflect.ValueOf(v.Any()), 0)}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// redactValue walks a value logged as any. Structs, maps and slices
+// become groups, so their sensitive fields and keys are redacted like
+// attributes are.
+func redactValue(v reflect.Value, depth int) slog.Value {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return slog.AnyValue(nil)
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return slog.AnyValue(nil)
+	}
+	if depth > maxRedactDepth {
+		return slog.StringValue(Redacted)
+	}
+	// Raw bodies and JSON documents are never logged
+	if (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() == reflect.Uint8 {
+		return slog.StringValue(Redacted)
+	}
+
+	if v.CanInterface() {
+		switch x := v.Interface().(type) {
+		case error:
+			return slog.StringValue(RedactString(x.Error()))
+		case slog.LogValuer:
+			return redactAttr(slog.Any("", x.LogValue())).Value
+		case encoding.TextMarshaler:
+			text, err := x.MarshalText()
+			if err != nil {
+				return slog.StringValue(Redacted)
+			}
+			return slog.StringValue(RedactString(string(text)))
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return slog.StringValue(RedactString(v.String()))
+	case reflect.Struct:
+		t := v.Type()
+		attrs := make([]slog.Attr, 0, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, ok := fieldName(f)
+			if !ok {
+				continue
+			}
+			attrs = append(attrs, redactField(name, v.Field(i), depth))
+		}
+		return slog.GroupValue(attrs...)
+	case reflect.Map:
+		attrs := make([]slog.Attr, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			attrs = append(attrs, redactField(fmt.Sprint(iter.Key().Interface()), iter.Value(), depth))
+		}
+		sort.Slice(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+		return slog.GroupValue(attrs...)
+	case reflect.Slice, reflect.Array:
+		attrs := make([]slog.Attr, v.Len())
+		for i := range attrs {
+			attrs[i] = slog.Attr{Key: fmt.Sprint(i), Value: redactValue(v.Index(i), depth+1)}
+		}
+		return slog.GroupValue(attrs...)
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return slog.StringValue(v.Type().String())
+	}
+	if !v.CanInterface() {
+		return slog.StringValue(Redacted)
+	}
+	return slog.AnyValue(v.Interface())
+}
+
+func redactField(name string, v reflect.Value, depth int) slog.Attr {
+	if sensitiveKeys[strings.ToLower(name)] {
+		return slog.String(name, Redacted)
+	}
+	return slog.Attr{Key: name, Value: redactValue(v, depth+1)}
+}
+
+// fieldName returns the name a struct field is logged under, its JSON name
+// when it has one, and false for fields that are not logged
+func fieldName(f reflect.StructField) (string, bool) {
+	if !f.IsExported() {
+		return "", false
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return "", false
+	case "":
+		return f.Name, true
+	}
+	return name, true
+}
@@ -1,42 +1,265 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"time"
 
+	"tma/access"
+	"tma/analytics"
 	"tma/auth"
+	"tma/bot"
+	"tma/collab"
 	"tma/config"
 	"tma/database"
+	"tma/events"
 	"tma/handlers"
+	"tma/health"
+	"tma/imaging"
+	"tma/logging"
+	"tma/metrics"
+	"tma/notify"
+	"tma/payments"
+	"tma/preview"
+	"tma/referral"
+	"tma/repository"
 	"tma/routes"
+	"tma/storage"
+	"tma/telegram"
 )
 
+// workerStallAge is how long a background worker may go without a
+// heartbeat before the liveness check fails
+const workerStallAge = 5 * time.Minute
+
 func main() {
 	// Load configuration
 	cfg := config.Load()
 
+	// The standard log package writes through the same redacting handler
+	slog.SetDefault(logging.New(os.Stderr, logging.Options{
+		Environment: cfg.Environment,
+		Level:       cfg.LogLevel,
+		Format:      cfg.LogFormat,
+	}))
+
+	// "tma migrate ..." manages the schema instead of starting the server
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(cfg, os.Args[2:]))
+	}
+
+	if err := run(cfg); err != nil {
+		slog.Error("Server failed", "err", err)
+		os.Exit(1)
+	}
+	slog.Info("Server stopped")
+}
+
+// run serves until SIGINT or SIGTERM. Background workers are stopped by
+// the deferred Close calls once the server has drained, in reverse order
+// of starting, so each one still has the database and everything started
+// before it while it finishes. The database is closed last.
+func run(cfg *config.Config) error {
 	// Initialize database
-	db, err := database.New(cfg.DatabaseURL)
+	db, err := database.New(cfg.DatabaseURL, dbOptions(cfg))
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		return fmt.Errorf("failed to connect to database: %w", err)
 	}
 	defer db.Close()
 
+	// Liveness watches the background workers; readiness the database and
+	// the services requests depend on
+	liveChecks := health.NewChecker(cfg.HealthCheckTimeout, cfg.HealthCacheTTL)
+	readyChecks := health.NewChecker(cfg.HealthCheckTimeout, cfg.HealthCacheTTL)
+	migrator, err := database.NewMigrator(db.DB)
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %w", err)
+	}
+	readyChecks.Add(health.Check{Name: "database", Check: health.Database(db.DB)})
+	readyChecks.Add(health.Check{Name: "migrations", Check: health.Migrations(migrator)})
+	database.RegisterMetrics(db.DB)
+
+	// Workers are watched by the liveness check and exposed in metrics
+	workers := make(map[string]health.Worker)
+	watch := func(name string, w health.Worker) {
+		workers[name] = w
+		liveChecks.Add(health.Check{Name: name, Check: health.Alive(w, workerStallAge)})
+	}
+
 	// Initialize JWT manager
 	jwtManager := auth.NewJWTManager(cfg.JWTSecret)
 
+	// Page roles for owners and the users pages are shared with
+	authz := access.NewAuthorizer(db.DB)
+
+	// Bot API client, also used outside the bot for invoices and sharing
+	botClient := telegram.NewClient(cfg.TelegramBotToken, telegram.Options{BaseURL: cfg.TelegramAPIURL})
+	// Paid page access in Telegram Stars
+	paymentsService := payments.NewService(db.DB, botClient, authz)
+
+	// Signed start_param values of referral links
+	startParamSecret := cfg.StartParamSecret
+	if startParamSecret == "" {
+		startParamSecret = cfg.JWTSecret
+	}
+	startParams := referral.NewSigner(startParamSecret)
+
+	// Users, pages, assets and sessions, for the handlers
+	repos := repository.NewPostgres(db.DB)
+
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(db.DB, jwtManager, cfg.TelegramBotToken)
-	pagesHandler := handlers.NewPagesHandler(db.DB)
+	authHandler := handlers.NewAuthHandler(repos, repos, jwtManager, cfg.TelegramBotToken, startParams)
+	// Page views are recorded in the background so GetPage never waits on writes
+	viewRecorder := analytics.NewRecorder(db.DB, cfg.ViewDedupWindow, cfg.ViewRetention)
+	go viewRecorder.Run()
+	defer viewRecorder.Close()
+	watch("analytics", viewRecorder)
+
+	store, err := newStorage(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize storage: %w", err)
+	}
+
+	pagesHandler := handlers.NewPagesHandler(repos, authz, viewRecorder, store, paymentsService)
+	// Uploaded images are optimized in the background
+	imageProcessor := imaging.NewProcessor(db.DB, store)
+	go imageProcessor.Run()
+	defer imageProcessor.Close()
+	watch("imaging", imageProcessor)
+
+	assetsHandler := handlers.NewAssetsHandler(repos, authz, store, imageProcessor, cfg.AssetMaxSize, cfg.AssetUserQuota)
+	exportHandler := handlers.NewExportHandler(repos, repos, store, imageProcessor, cfg.AssetMaxSize, cfg.AssetUserQuota)
+	statsHandler := handlers.NewStatsHandler(db.DB, authz)
+
+	previews, err := preview.NewCache(cfg.PreviewCacheDir, cfg.PreviewCacheSize)
+	if err != nil {
+		return fmt.Errorf("failed to initialize preview cache: %w", err)
+	}
+	renderHandler := handlers.NewRenderHandler(repos, previews, cfg.PublicURL)
+
+	// Collaborative editing rooms, flushed to the database while in use
+	collabHub := collab.NewHub(db.DB)
+	defer collabHub.Close()
+	collabHandler := handlers.NewCollabHandler(db.DB, authz, collabHub, jwtManager)
+
+	// Page change notifications from every instance, for SSE clients
+	eventBroker := events.NewBroker(db.DB, cfg.DatabaseURL)
+	go eventBroker.Run()
+	defer eventBroker.Close()
+	watch("events", eventBroker)
+	eventsHandler := handlers.NewEventsHandler(db.DB, eventBroker, jwtManager)
+	notifier := notify.NewNotifier(cfg.NotifyDigestInterval)
+	membersHandler := handlers.NewMembersHandler(db.DB, authz, notifier)
+	workspacesHandler := handlers.NewWorkspacesHandler(db.DB, repos, authz, jwtManager, notifier)
+	notificationsHandler := handlers.NewNotificationsHandler(db.DB)
+	paymentsHandler := handlers.NewPaymentsHandler(repos, authz, paymentsService)
+	referralsHandler := handlers.NewReferralsHandler(db.DB, authz, startParams, cfg.MiniAppLink)
+
+	// Telegram bot: updates arrive through the webhook, or in polling mode
+	// from getUpdates, and go through the same dispatcher
+	botRouter := bot.NewRouter()
+	if botClient.Enabled() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if me, err := botClient.GetMe(ctx); err != nil {
+			slog.Warn("Failed to fetch bot info", "err", err)
+		} else {
+			botRouter.Username = me.Username
+		}
+		cancel()
+	}
+	bot.NewCommands(db.DB, botClient, cfg.MiniAppURL, cfg.PublicURL).Register(botRouter)
+	paymentsService.Register(botRouter)
+	botDispatcher := bot.NewDispatcher(db.DB, botRouter)
+	shareHandler := handlers.NewShareHandler(repos, authz, botClient, cfg.MiniAppURL, cfg.PublicURL)
+	telegramHandler := handlers.NewTelegramHandler(botDispatcher, cfg.TelegramWebhookSecret)
+	switch cfg.TelegramUpdatesMode {
+	case "polling":
+		if !botClient.Enabled() {
+			return errors.New("TELEGRAM_UPDATES_MODE=polling requires TELEGRAM_BOT_TOKEN")
+		}
+		poller := bot.NewPoller(db.DB, botClient, botDispatcher)
+		go poller.Run()
+		defer poller.Close()
+		watch("bot_poller", poller)
+	case "webhook":
+	default:
+		return fmt.Errorf("unknown TELEGRAM_UPDATES_MODE %q", cfg.TelegramUpdatesMode)
+	}
+
+	// Notifications stay queued in the outbox while the bot is not configured
+	if botClient.Enabled() {
+		sender := notify.NewSender(db.DB, botClient, cfg.MiniAppURL, cfg.PublicURL)
+		go sender.Run()
+		defer sender.Close()
+		watch("notifications", sender)
+	}
+
+	// Requests work without the bot, so it does not fail readiness
+	if cfg.HealthCheckBot && botClient.Enabled() {
+		readyChecks.Add(health.Check{Name: "bot_api", Optional: true, Check: func(ctx context.Context) error {
+			_, err := botClient.GetMe(ctx)
+			return err
+		}})
+	}
+
+	// Readiness fails while shutting down so load balancers stop routing here
+	healthHandler := handlers.NewHealthHandler(liveChecks, readyChecks)
+
+	registerWorkerMetrics(workers)
+	// Metrics go on the main router unless they have an address of their own
+	var metricsHandler http.Handler = metrics.Handler(cfg.MetricsUser, cfg.MetricsPassword)
+	if cfg.MetricsAddr != "" {
+		stopMetrics := serveMetrics(cfg.MetricsAddr, metricsHandler)
+		defer stopMetrics()
+		metricsHandler = nil
+	} else if cfg.MetricsUser == "" && cfg.Environment == "production" {
+		slog.Warn("Metrics are public, set METRICS_USER or METRICS_ADDR")
+	}
 
 	// Setup routes
-	router := routes.SetupRoutes(authHandler, pagesHandler, jwtManager)
+	router := routes.SetupRoutes(authHandler, pagesHandler, exportHandler, renderHandler, statsHandler, assetsHandler, collabHandler, eventsHandler, membersHandler, workspacesHandler, notificationsHandler, shareHandler, paymentsHandler, referralsHandler, telegramHandler, healthHandler, metricsHandler, authz, jwtManager)
+
+	server := newServer(cfg, router)
+	// SSE streams and WebSockets never finish on their own
+	server.RegisterOnShutdown(eventBroker.Close)
+	server.RegisterOnShutdown(collabHub.Close)
 
 	// Start server
-	log.Printf("Starting server on port %s", cfg.Port)
-	log.Printf("Environment: %s", cfg.Environment)
-	
-	if err := http.ListenAndServe(":"+cfg.Port, router); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	slog.Info("Starting server", "port", cfg.Port, "environment", cfg.Environment)
+
+	return serve(server, healthHandler, cfg.ShutdownDrainPeriod, cfg.ShutdownTimeout)
+}
+
+// dbOptions returns the connection pool settings of the configuration
+func dbOptions(cfg *config.Config) database.Options {
+	return database.Options{
+		MaxOpenConns:     cfg.DBMaxOpenConns,
+		MaxIdleConns:     cfg.DBMaxIdleConns,
+		ConnMaxLifetime:  cfg.DBConnMaxLifetime,
+		ConnMaxIdleTime:  cfg.DBConnMaxIdleTime,
+		StatementTimeout: cfg.DBStatementTimeout,
+	}
+}
+
+// newStorage creates the asset storage backend selected by STORAGE_BACKEND
+func newStorage(cfg *config.Config) (storage.Storage, error) {
+	switch cfg.StorageBackend {
+	case "local":
+		return storage.NewLocal(cfg.StorageDir)
+	case "s3":
+		return storage.NewS3(storage.S3Config{
+			Endpoint:        cfg.S3Endpoint,
+			Region:          cfg.S3Region,
+			Bucket:          cfg.S3Bucket,
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+			PathStyle:       cfg.S3PathStyle,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.StorageBackend)
 	}
 }
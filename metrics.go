@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"tma/health"
+	"tma/metrics"
+)
+
+// registerWorkerMetrics exposes when each background worker last showed
+// signs of life, the same heartbeat the liveness checks look at
+func registerWorkerMetrics(workers map[string]health.Worker) {
+	names := make([]string, 0, len(workers))
+	for name := range workers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	metrics.NewGaugeFunc("tma_worker_heartbeat_timestamp_seconds", "Unix time of the last heartbeat of each background worker.", []string{"worker"},
+		func(emit func(float64, ...string)) {
+			for _, name := range names {
+				if last := workers[name].Heartbeat(); !last.IsZero() {
+					emit(float64(last.UnixMilli())/1000, name)
+				}
+			}
+		})
+}
+
+// serveMetrics serves handler at /metrics on its own address, so it can
+// be kept off the public port. The returned function stops the server.
+func serveMetrics(addr string, handler http.Handler) func() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+
+	go func() {
+		slog.Info("Serving metrics", "addr", addr)
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server failed", "err", err)
+		}
+	}()
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}
+}
@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"io"
+	"net/http"
+)
+
+// Handler serves the default registry. With a non-empty user, requests
+// must carry matching basic auth credentials.
+func Handler(user, password string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user != "" {
+			u, p, ok := r.BasicAuth()
+			if !ok || subtle.ConstantTimeCompare([]byte(u), []byte(user)) != 1 || subtle.ConstantTimeCompare([]byte(p), []byte(password)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		io.WriteString(w, Default.Text())
+	})
+}
@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Counter is a monotonically increasing value per label set
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	value  float64
+}
+
+// NewCounter creates and registers a counter with the given label names
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{metricName: name, help: help, labels: labels}, series: make(map[string]*counterSeries)}
+	Default.register(c)
+	return c
+}
+
+// Inc adds 1 to the series of the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the series of the label values
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := c.key(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{values: append([]string(nil), labelValues...)}
+		c.series[key] = s
+	}
+	s.value += v
+}
+
+func (c *Counter) write(b *strings.Builder) {
+	c.header(b, "counter")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		fmt.Fprintf(b, "%s%s %s\n", c.metricName, c.labelPairs(s.values), formatFloat(s.value))
+	}
+}
+
+// Histogram counts observations into cumulative buckets per label set
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates and registers a histogram with the given upper
+// bucket bounds and label names
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &Histogram{desc: desc{metricName: name, help: help, labels: labels}, buckets: buckets, series: make(map[string]*histogramSeries)}
+	Default.register(h)
+	return h
+}
+
+// Observe records v in the series of the label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{values: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *Histogram) write(b *strings.Builder) {
+	h.header(b, "histogram")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(b, "%s_bucket%s %d\n", h.metricName, h.labelPairs(s.values, "le", formatFloat(bound)), s.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", h.metricName, h.labelPairs(s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", h.metricName, h.labelPairs(s.values), formatFloat(s.sum))
+		fmt.Fprintf(b, "%s_count%s %d\n", h.metricName, h.labelPairs(s.values), s.count)
+	}
+}
+
+// Func is a gauge or counter whose values are read when metrics are
+// written, for state owned elsewhere such as connection pool statistics
+type Func struct {
+	desc
+	typ     string
+	collect func(emit func(value float64, labelValues ...string))
+}
+
+// NewGaugeFunc creates and registers a gauge read by collect, which calls
+// emit once per label set
+func NewGaugeFunc(name, help string, labels []string, collect func(emit func(value float64, labelValues ...string))) *Func {
+	f := &Func{desc: desc{metricName: name, help: help, labels: labels}, typ: "gauge", collect: collect}
+	Default.register(f)
+	return f
+}
+
+// NewCounterFunc is NewGaugeFunc for values that only grow
+func NewCounterFunc(name, help string, labels []string, collect func(emit func(value float64, labelValues ...string))) *Func {
+	f := &Func{desc: desc{metricName: name, help: help, labels: labels}, typ: "counter", collect: collect}
+	Default.register(f)
+	return f
+}
+
+func (f *Func) write(b *strings.Builder) {
+	f.header(b, f.typ)
+	f.collect(func(value float64, labelValues ...string) {
+		f.key(labelValues)
+		fmt.Fprintf(b, "%s%s %s\n", f.metricName, f.labelPairs(labelValues), formatFloat(value))
+	})
+}
@@ -1,21 +1,19 @@
 package middleware
 
 import (
-	"log"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"tma/auth"
+	"tma/logging"
 )
 
 func AuthMiddleware(jwtManager *auth.JWTManager) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		log.Printf("AuthMiddleware: Processing request to %s", c.Request.URL.Path)
-		
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			log.Printf("AuthMiddleware: No Authorization header")
+			auth.CountAttempt(auth.KindToken, "missing")
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
 			c.Abort()
 			return
@@ -24,30 +22,37 @@ func AuthMiddleware(jwtManager *auth.JWTManager) gin.HandlerFunc {
 		// Check if the header starts with "Bearer "
 		tokenParts := strings.Split(authHeader, " ")
 		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-			log.Printf("AuthMiddleware: Invalid authorization header format")
+			auth.CountAttempt(auth.KindToken, "malformed_header")
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
 			c.Abort()
 			return
 		}
 
-		tokenString := tokenParts[1]
-		log.Printf("AuthMiddleware: Token received: %s...", tokenString[:10])
-
 		// Validate the token
-		claims, err := jwtManager.ValidateToken(tokenString)
+		claims, err := jwtManager.ValidateToken(tokenParts[1])
 		if err != nil {
-			log.Printf("AuthMiddleware: Token validation failed: %v", err)
+			logging.FromContext(c.Request.Context()).Debug("Token validation failed", "err", err)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
 
-		log.Printf("AuthMiddleware: Token validated successfully for user ID: %d", claims.UserID)
-
-		// Set user information in context
-		c.Set("user_id", claims.UserID)
-		c.Set("telegram_id", claims.TelegramID)
+		setUser(c, claims)
+		c.Next()
+	}
+}
 
+// OptionalAuthMiddleware identifies the user of a public route when the
+// request carries a valid token, and lets it through anonymously
+// otherwise
+func OptionalAuthMiddleware(jwtManager *auth.JWTManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if ok {
+			if claims, err := jwtManager.ValidateToken(tokenString); err == nil {
+				setUser(c, claims)
+			}
+		}
 		c.Next()
 	}
 }
@@ -57,7 +62,8 @@ func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Credentials", "true")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Workspace-ID, X-Request-ID")
+		c.Header("Access-Control-Expose-Headers", "X-Request-ID")
 		c.Header("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"tma/auth"
+	"tma/logging"
+)
+
+// RequestIDHeader carries the ID that ties a response to its log lines.
+// Clients and proxies may set it; otherwise one is generated.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds IDs accepted from clients
+const maxRequestIDLength = 128
+
+// RequestLogger assigns every request an ID, gives it a logger carrying
+// the ID, method and route, which handlers get through
+// logging.FromContext, and writes one access log line when it is done.
+// JSON error bodies get the ID in a request_id field. It must be the
+// first middleware so the line and the field cover everything else.
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set("request_id", id)
+		c.Header(RequestIDHeader, id)
+		c.Writer = newRequestIDWriter(c.Writer, id)
+
+		ctx := logging.With(c.Request.Context(), "request_id", id, "method", c.Request.Method, "route", routeTemplate(c))
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		// Auth replaces the request, so this logger also carries user_id
+		logging.FromContext(c.Request.Context()).LogAttrs(c.Request.Context(), level, "Request",
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+			slog.String("client_ip", c.ClientIP()),
+		)
+	}
+}
+
+// Recovery turns a panic into a 500 and logs it with the request logger.
+// gin's own recovery dumps the request, query string included, past the
+// redaction of the logger.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, err any) {
+		logging.FromContext(c.Request.Context()).Error("Panic", "panic", fmt.Sprint(err), "stack", string(debug.Stack()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	})
+}
+
+// requestIDWriter adds the request ID to JSON error responses, so every
+// error body can be matched to its log lines without each handler
+// remembering to include it. Bodies are objects written in one piece, as
+// gin's JSON renderer does.
+type requestIDWriter struct {
+	gin.ResponseWriter
+	field   []byte
+	written bool
+}
+
+func newRequestIDWriter(w gin.ResponseWriter, id string) *requestIDWriter {
+	quoted, _ := json.Marshal(id)
+	return &requestIDWriter{ResponseWriter: w, field: append([]byte(`"request_id":`), quoted...)}
+}
+
+func (w *requestIDWriter) Write(b []byte) (int, error) {
+	first := !w.written
+	w.written = true
+	if !first || w.Status() < 400 || len(b) < 2 || b[0] != '{' ||
+		!strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		return w.ResponseWriter.Write(b)
+	}
+
+	body := make([]byte, 0, len(b)+len(w.field)+1)
+	body = append(body, '{')
+	body = append(body, w.field...)
+	if b[1] != '}' {
+		body = append(body, ',')
+	}
+	body = append(body, b[1:]...)
+	if _, err := w.ResponseWriter.Write(body); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (w *requestIDWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Unwrap lets http.ResponseController reach the connection, which
+// streaming handlers need to lift the write deadline
+func (w *requestIDWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// validRequestID accepts IDs that are safe to echo and to log
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// setUser stores the authenticated user in the context and adds it to
+// the request logger
+func setUser(c *gin.Context, claims *auth.Claims) {
+	c.Set("user_id", claims.UserID)
+	c.Set("telegram_id", claims.TelegramID)
+	c.Set("token_workspace_id", claims.WorkspaceID)
+	c.Request = c.Request.WithContext(logging.With(c.Request.Context(), "user_id", claims.UserID))
+}
@@ -0,0 +1,80 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"tma/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestIDInErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.RequestLogger(), middleware.Recovery())
+	router.GET("/ok", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"ok": true}) })
+	router.GET("/bad", func(c *gin.Context) { c.JSON(http.StatusBadRequest, gin.H{"error": "Bad"}) })
+	router.GET("/empty", func(c *gin.Context) { c.JSON(http.StatusNotFound, gin.H{}) })
+	router.GET("/panic", func(c *gin.Context) { panic("boom") })
+
+	tests := []struct {
+		path   string
+		status int
+		id     bool
+	}{
+		{"/ok", http.StatusOK, false},
+		{"/bad", http.StatusBadRequest, true},
+		{"/empty", http.StatusNotFound, true},
+		{"/panic", http.StatusInternalServerError, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set(middleware.RequestIDHeader, "req-1")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d", rec.Code, tt.status)
+			}
+			var body map[string]any
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body %q: %v", rec.Body, err)
+			}
+			if id, ok := body["request_id"]; ok != tt.id || (ok && id != "req-1") {
+				t.Errorf("body %q, want request_id %t", rec.Body, tt.id)
+			}
+			if rec.Header().Get(middleware.RequestIDHeader) != "req-1" {
+				t.Errorf("header %q, want req-1", rec.Header().Get(middleware.RequestIDHeader))
+			}
+		})
+	}
+}
+
+func TestRequestIDWriterUnwraps(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.RequestLogger())
+	var deadlineErr error
+	router.GET("/stream", func(c *gin.Context) {
+		deadlineErr = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+		c.Status(http.StatusOK)
+	})
+
+	// A recorder has no connection, so this needs a real server
+	server := httptest.NewServer(router)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if deadlineErr != nil {
+		t.Errorf("SetWriteDeadline: %v", deadlineErr)
+	}
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"tma/metrics"
+)
+
+var (
+	httpRequests = metrics.NewCounter("tma_http_requests_total",
+		"HTTP requests by method, route template and status.", "method", "route", "status")
+	httpDuration = metrics.NewHistogram("tma_http_request_duration_seconds",
+		"HTTP request latency by method and route template.", metrics.DefaultBuckets, "method", "route")
+)
+
+// Metrics counts requests and their latency by route template, so the
+// number of series does not grow with page IDs
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		method, route := metricMethod(c.Request.Method), routeTemplate(c)
+		httpRequests.Inc(method, route, strconv.Itoa(c.Writer.Status()))
+		httpDuration.Observe(time.Since(start).Seconds(), method, route)
+	}
+}
+
+// metricMethod keeps made-up methods from adding series
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
+
+// routeTemplate is the matched route, such as /api/v1/pages/:id
+func routeTemplate(c *gin.Context) string {
+	if route := c.FullPath(); route != "" {
+		return route
+	}
+	return "unmatched"
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"tma/access"
+	"tma/database"
+)
+
+// WorkspaceHeader selects the active workspace for a single request,
+// overriding the workspace stored in the token
+const WorkspaceHeader = "X-Workspace-ID"
+
+// WorkspaceMiddleware resolves the active workspace of an authenticated
+// request and stores its ID and the user's role in the context as
+// "workspace_id" and "workspace_role". It must run after AuthMiddleware.
+//
+// An explicitly requested workspace the user is not a member of is
+// rejected. A workspace from the token that is no longer accessible
+// falls back to the personal workspace, so old tokens keep working.
+func WorkspaceMiddleware(authz *access.Authorizer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("user_id")
+
+		if header := c.GetHeader(WorkspaceHeader); header != "" {
+			workspaceID, err := strconv.Atoi(header)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid " + WorkspaceHeader + " header"})
+				return
+			}
+			role, err := authz.WorkspaceRole(c.Request.Context(), workspaceID, userID)
+			if err != nil {
+				c.AbortWithStatusJSON(database.HTTPStatus(c.Request.Context(), err), gin.H{"error": "Failed to resolve workspace"})
+				return
+			}
+			if role == access.RoleNone {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Not a member of this workspace"})
+				return
+			}
+			setWorkspace(c, workspaceID, role)
+			return
+		}
+
+		if workspaceID := c.GetInt("token_workspace_id"); workspaceID != 0 {
+			role, err := authz.WorkspaceRole(c.Request.Context(), workspaceID, userID)
+			if err != nil {
+				c.AbortWithStatusJSON(database.HTTPStatus(c.Request.Context(), err), gin.H{"error": "Failed to resolve workspace"})
+				return
+			}
+			if role != access.RoleNone {
+				setWorkspace(c, workspaceID, role)
+				return
+			}
+		}
+
+		workspaceID, err := authz.EnsurePersonalWorkspace(c.Request.Context(), userID)
+		if err != nil {
+			c.AbortWithStatusJSON(database.HTTPStatus(c.Request.Context(), err), gin.H{"error": "Failed to resolve workspace"})
+			return
+		}
+		setWorkspace(c, workspaceID, access.RoleOwner)
+	}
+}
+
+func setWorkspace(c *gin.Context, workspaceID int, role access.Role) {
+	c.Set("workspace_id", workspaceID)
+	c.Set("workspace_role", role)
+	c.Next()
+}
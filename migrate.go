@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"tma/config"
+	"tma/database"
+)
+
+const migrateUsage = `Usage: tma migrate <command>
+
+Commands:
+  up        apply all pending migrations
+  down [N]  roll back the last N migrations (default 1)
+  status    list migrations and whether they are applied
+  redo      roll back the last migration and apply it again`
+
+// runMigrate implements the migrate subcommand and returns the exit code
+func runMigrate(cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	steps := 1
+	switch args[0] {
+	case "up", "status", "redo":
+		if len(args) > 1 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+	case "down":
+		if len(args) > 2 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				fmt.Fprintf(os.Stderr, "Invalid number of migrations %q\n", args[1])
+				return 2
+			}
+			steps = n
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	db, err := database.Open(cfg.DatabaseURL, dbOptions(cfg))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	migrator, err := database.NewMigrator(db.DB)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load migrations: %v\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		var n int
+		if n, err = migrator.Up(ctx); err == nil {
+			fmt.Printf("Applied %d migrations\n", n)
+		}
+	case "down":
+		var n int
+		if n, err = migrator.Down(ctx, steps); err == nil {
+			fmt.Printf("Rolled back %d migrations\n", n)
+		}
+	case "redo":
+		err = migrator.Redo(ctx)
+	case "status":
+		var statuses []database.MigrationStatus
+		if statuses, err = migrator.Status(ctx); err == nil {
+			printMigrationStatus(statuses)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+func printMigrationStatus(statuses []database.MigrationStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+	for _, s := range statuses {
+		appliedAt := "-"
+		if s.AppliedAt != nil {
+			appliedAt = s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, s.State, appliedAt)
+	}
+	w.Flush()
+}
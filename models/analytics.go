@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+type StatsPoint struct {
+	Bucket time.Time `json:"bucket"`
+	Views  int64     `json:"views"`
+}
+
+type StatsCount struct {
+	Value string `json:"value"`
+	Views int64  `json:"views"`
+}
+
+// PageStats is returned by GET /pages/:id/stats
+type PageStats struct {
+	PageID       int          `json:"page_id"`
+	From         time.Time    `json:"from"`
+	To           time.Time    `json:"to"`
+	Granularity  string       `json:"granularity"`
+	TotalViews   int64        `json:"total_views"`
+	Series       []StatsPoint `json:"series"`
+	TopReferrers []StatsCount `json:"top_referrers"`
+	Platforms    []StatsCount `json:"platforms"`
+}
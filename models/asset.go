@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+type Asset struct {
+	ID            int            `json:"id" db:"id"`
+	UserID        int            `json:"user_id" db:"user_id"`
+	StorageKey    string         `json:"-" db:"storage_key"`
+	Filename      string         `json:"filename" db:"filename"`
+	ContentType   string         `json:"content_type" db:"content_type"`
+	Size          int64          `json:"size" db:"size"`
+	Width         int            `json:"width" db:"width"`
+	Height        int            `json:"height" db:"height"`
+	Blurhash      string         `json:"blurhash,omitempty" db:"blurhash"`
+	DominantColor string         `json:"dominant_color,omitempty" db:"dominant_color"`
+	Status        string         `json:"status" db:"status"`
+	URL           string         `json:"url" db:"-"`
+	Variants      []AssetVariant `json:"variants" db:"-"`
+	CreatedAt     time.Time      `json:"created_at" db:"created_at"`
+}
+
+// AssetVariant is a resized copy of an image asset
+type AssetVariant struct {
+	Width       int    `json:"width" db:"width"`
+	Height      int    `json:"height" db:"height"`
+	StorageKey  string `json:"-" db:"storage_key"`
+	ContentType string `json:"content_type" db:"content_type"`
+	Size        int64  `json:"size" db:"size"`
+	URL         string `json:"url" db:"-"`
+}
@@ -0,0 +1,78 @@
+package models
+
+import "time"
+
+// ExportFormat identifies archives produced by GET /pages/export
+const ExportFormat = "tma-pages"
+
+// ExportVersion is bumped whenever the archive layout changes
+const ExportVersion = 2
+
+// ExportManifest is stored as manifest.json at the root of the archive
+type ExportManifest struct {
+	Format     string               `json:"format"`
+	Version    int                  `json:"version"`
+	ExportedAt time.Time            `json:"exported_at"`
+	Pages      []ExportManifestItem `json:"pages"`
+	// Assets are the files linked to the exported pages, added in version 2
+	Assets []ExportManifestAsset `json:"assets,omitempty"`
+}
+
+type ExportManifestItem struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	File  string `json:"file"`
+}
+
+// ExportManifestAsset describes an asset whose content is stored as File
+type ExportManifestAsset struct {
+	ID          int    `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	File        string `json:"file"`
+}
+
+// PageExport is the portable representation of a single page
+type PageExport struct {
+	ID          int       `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	CoverImage  string    `json:"cover_image,omitempty"`
+	JSONData    JSONData  `json:"json_data"`
+	PriceStars  int       `json:"price_stars,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// Assets are the IDs of the manifest assets linked to the page
+	Assets []int `json:"assets,omitempty"`
+	// Revisions are the earlier contents of the page, newest first
+	Revisions []RevisionExport `json:"revisions,omitempty"`
+}
+
+// RevisionExport is the portable representation of a page revision
+type RevisionExport struct {
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	CoverImage  string    `json:"cover_image,omitempty"`
+	JSONData    JSONData  `json:"json_data"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type ImportedPage struct {
+	OldID int    `json:"old_id"`
+	NewID int    `json:"new_id"`
+	Title string `json:"title"`
+}
+
+type SkippedPage struct {
+	OldID  int    `json:"old_id"`
+	Title  string `json:"title"`
+	Reason string `json:"reason"`
+}
+
+// ImportReport describes the outcome of POST /pages/import
+type ImportReport struct {
+	Created     []ImportedPage `json:"created"`
+	Overwritten []ImportedPage `json:"overwritten"`
+	Skipped     []SkippedPage  `json:"skipped"`
+}
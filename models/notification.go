@@ -0,0 +1,14 @@
+package models
+
+// NotificationSettings describes how the bot notifies a user
+type NotificationSettings struct {
+	// AllowsWriteToPM is false until the user lets the bot message them,
+	// in which case nothing is delivered whatever the preferences say
+	AllowsWriteToPM bool `json:"allows_write_to_pm"`
+	// Delivery maps every notification kind to instant, digest or off
+	Delivery map[string]string `json:"delivery"`
+}
+
+type UpdateNotificationSettingsRequest struct {
+	Delivery map[string]string `json:"delivery" binding:"required"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// Purchase is an entry in the ledger of paid page access. PageID is nil
+// once the page is deleted; Title keeps what was bought and WorkspaceID
+// the workspace the page was in.
+type Purchase struct {
+	ID          int        `json:"id"`
+	UserID      *int       `json:"user_id,omitempty"`
+	PageID      *int       `json:"page_id"`
+	WorkspaceID *int       `json:"workspace_id,omitempty"`
+	Title       string     `json:"title"`
+	Amount      int        `json:"amount"`
+	Currency    string     `json:"currency"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	PaidAt      *time.Time `json:"paid_at,omitempty"`
+	RefundedAt  *time.Time `json:"refunded_at,omitempty"`
+}
+
+// Invoice is returned to the Mini App, which opens Link with openInvoice
+type Invoice struct {
+	PurchaseID int    `json:"purchase_id"`
+	Link       string `json:"invoice_link"`
+	Amount     int    `json:"amount"`
+	Currency   string `json:"currency"`
+}
+
+type SetPriceRequest struct {
+	// PriceStars is the price in Telegram Stars, 0 makes the page free
+	PriceStars int `json:"price_stars"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// StartParam is the decoded content of a signed start_param
+type StartParam struct {
+	PageID   int    `json:"page_id,omitempty"`
+	Referral string `json:"referral,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+}
+
+type CreateReferralLinkRequest struct {
+	PageID   int    `json:"page_id"`
+	Campaign string `json:"campaign"`
+}
+
+// ReferralLink is a link to the Mini App attributed to its creator. Link
+// is empty when no Mini App link is configured; StartParam can then be
+// appended to any t.me link as ?startapp=.
+type ReferralLink struct {
+	StartParam string `json:"start_param"`
+	Link       string `json:"link,omitempty"`
+}
+
+// ReferralStats counts the signups a user referred, in total and per
+// campaign. Signups without a campaign are listed under an empty one.
+type ReferralStats struct {
+	ReferralCode string          `json:"referral_code"`
+	Signups      int             `json:"signups"`
+	Campaigns    []CampaignStats `json:"campaigns"`
+}
+
+type CampaignStats struct {
+	Campaign     string    `json:"campaign"`
+	Signups      int       `json:"signups"`
+	LastSignupAt time.Time `json:"last_signup_at"`
+}
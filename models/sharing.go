@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// PageMember is a user with access to a page, either through the page's
+// workspace or because the page was shared with them
+type PageMember struct {
+	UserID     int       `json:"user_id"`
+	TelegramID int64     `json:"telegram_id"`
+	Username   string    `json:"username"`
+	FirstName  string    `json:"first_name"`
+	LastName   string    `json:"last_name"`
+	Role       string    `json:"role"`
+	Via        string    `json:"via"` // "workspace" or "page"
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// AddMemberRequest identifies the user to share with by exactly one of
+// the internal ID, Telegram ID or Telegram username
+type AddMemberRequest struct {
+	UserID     int    `json:"user_id"`
+	TelegramID int64  `json:"telegram_id"`
+	Username   string `json:"username"`
+	Role       string `json:"role" binding:"required"`
+}
+
+type UpdateMemberRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// PageInvite is a shareable link granting a role on a page
+type PageInvite struct {
+	ID        int        `json:"id"`
+	PageID    int        `json:"page_id"`
+	Token     string     `json:"token"`
+	Role      string     `json:"role"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	MaxUses   *int       `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type CreateInviteRequest struct {
+	Role string `json:"role" binding:"required"`
+	// ExpiresIn is the lifetime of the invite in seconds, 0 for no expiry
+	ExpiresIn int  `json:"expires_in"`
+	MaxUses   *int `json:"max_uses"`
+}
+
+// SharedPage is a page shared with the user together with their role
+type SharedPage struct {
+	Page
+	Role string `json:"role"`
+}
@@ -3,7 +3,6 @@ package models
 import (
 	"database/sql/driver"
 	"encoding/json"
-	"fmt"
 	"time"
 )
 
@@ -15,14 +14,6 @@ func (j JSONData) Value() (driver.Value, error) {
 	if j == nil {
 		return nil, nil
 	}
-
-	// Логируем, что отправляется в БД
-	value := string(j)
-	if len(value) > 100 {
-		value = value[:100] + "..."
-	}
-	fmt.Printf("JSONData.Value(): Sending to DB: %s\n", value)
-
 	return string(j), nil
 }
 
@@ -77,20 +68,25 @@ func (j *JSONData) UnmarshalJSON(data []byte) error {
 }
 
 type User struct {
-	ID         int       `json:"id" db:"id"`
-	TelegramID int64     `json:"telegram_id" db:"telegram_id"`
-	Username   string    `json:"username" db:"username"`
-	FirstName  string    `json:"first_name" db:"first_name"`
-	LastName   string    `json:"last_name" db:"last_name"`
-	CreatedAt  time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+	ID         int    `json:"id" db:"id"`
+	TelegramID int64  `json:"telegram_id" db:"telegram_id"`
+	Username   string `json:"username" db:"username"`
+	FirstName  string `json:"first_name" db:"first_name"`
+	LastName   string `json:"last_name" db:"last_name"`
+	// AllowsWriteToPM reports whether the bot may send the user messages
+	AllowsWriteToPM bool      `json:"allows_write_to_pm" db:"allows_write_to_pm"`
+	CreatedAt       time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at" db:"updated_at"`
 }
 
 type TelegramUser struct {
-	ID        int64  `json:"id"`
-	Username  string `json:"username"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
+	ID              int64  `json:"id"`
+	Username        string `json:"username"`
+	FirstName       string `json:"first_name"`
+	LastName        string `json:"last_name"`
+	AllowsWriteToPM bool   `json:"allows_write_to_pm"`
+	// StartParam is the start_param of the link the Mini App was opened with
+	StartParam string `json:"start_param,omitempty"`
 }
 
 type AuthRequest struct {
@@ -100,23 +96,62 @@ type AuthRequest struct {
 type AuthResponse struct {
 	Token string `json:"token"`
 	User  User   `json:"user"`
+	// StartParam is set when the Mini App was opened with a signed link,
+	// so the client can open the referenced page
+	StartParam *StartParam `json:"start_param,omitempty"`
 }
 
-type Page struct {
+// Session is a token issued to a user. Only a hash of the token is kept.
+type Session struct {
 	ID        int       `json:"id" db:"id"`
 	UserID    int       `json:"user_id" db:"user_id"`
-	Title     string    `json:"title" db:"title"`
-	JSONData  JSONData  `json:"json_data" db:"json_data"`
+	TokenHash string    `json:"-" db:"token_hash"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type Page struct {
+	ID          int       `json:"id" db:"id"`
+	UserID      int       `json:"user_id" db:"user_id"`
+	WorkspaceID int       `json:"workspace_id" db:"workspace_id"`
+	Title       string    `json:"title" db:"title"`
+	Description string    `json:"description" db:"description"`
+	CoverImage  string    `json:"cover_image" db:"cover_image"`
+	JSONData    JSONData  `json:"json_data" db:"json_data"`
+	PriceStars  int       `json:"price_stars" db:"price_stars"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// PageRevision is the content a page had before an edit
+type PageRevision struct {
+	ID          int64     `json:"id"`
+	PageID      int       `json:"page_id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	CoverImage  string    `json:"cover_image"`
+	JSONData    JSONData  `json:"json_data"`
+	CreatedAt   time.Time `json:"created_at"`
 }
 
 type CreatePageRequest struct {
-	Title    string   `json:"title" binding:"required"`
-	JSONData JSONData `json:"json_data"`
+	Title       string   `json:"title" binding:"required"`
+	Description string   `json:"description"`
+	CoverImage  string   `json:"cover_image"`
+	JSONData    JSONData `json:"json_data"`
 }
 
 type UpdatePageRequest struct {
-	Title    string   `json:"title"`
-	JSONData JSONData `json:"json_data"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	CoverImage  string   `json:"cover_image"`
+	JSONData    JSONData `json:"json_data"`
+}
+
+// PageChange is an entry of the page change feed
+type PageChange struct {
+	ID        int64     `json:"id" db:"id"`
+	PageID    int       `json:"page_id" db:"page_id"`
+	Kind      string    `json:"kind" db:"kind"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// Workspace owns pages and is shared by its members. Personal workspaces
+// are created for every user and cannot be deleted or left.
+type Workspace struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Personal  bool      `json:"personal"`
+	Role      string    `json:"role"`
+	Current   bool      `json:"current"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WorkspaceMember struct {
+	UserID     int       `json:"user_id"`
+	TelegramID int64     `json:"telegram_id"`
+	Username   string    `json:"username"`
+	FirstName  string    `json:"first_name"`
+	LastName   string    `json:"last_name"`
+	Role       string    `json:"role"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type CreateWorkspaceRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type UpdateWorkspaceRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// SwitchWorkspaceResponse carries a token bound to the selected workspace
+type SwitchWorkspaceResponse struct {
+	Token     string    `json:"token"`
+	Workspace Workspace `json:"workspace"`
+}
+
+type MovePageRequest struct {
+	WorkspaceID int `json:"workspace_id" binding:"required"`
+}
@@ -0,0 +1,180 @@
+// Package notify tells users about things that happen to them while they
+// are away, as Telegram messages from the bot. Notifications are written
+// to the notification_outbox table, in the same transaction as the change
+// that caused them when there is one, and a Sender delivers them in the
+// background.
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Notification kinds. Users choose a delivery for each.
+const (
+	// KindPageShared is sent to a user a page was shared with
+	KindPageShared = "page_shared"
+	// KindWorkspaceInvite is sent to a user added to a workspace
+	KindWorkspaceInvite = "workspace_invite"
+	// KindInviteAccepted is sent to the creator of an invite link when
+	// someone joins a page through it
+	KindInviteAccepted = "invite_accepted"
+)
+
+// Kinds lists every notification kind
+var Kinds = []string{KindPageShared, KindWorkspaceInvite, KindInviteAccepted}
+
+// Deliveries stored in notification_preferences.delivery
+const (
+	DeliveryInstant = "instant"
+	DeliveryDigest  = "digest"
+	DeliveryOff     = "off"
+)
+
+// batchWindow delays instant notifications a little, so a burst of them
+// goes out as one message
+const batchWindow = 15 * time.Second
+
+// Execer runs a statement; both *sql.DB and *sql.Tx implement it
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Notification is a message for a single user
+type Notification struct {
+	UserID int
+	Kind   string
+	Text   string
+	// PageID, if set, adds a button opening the page
+	PageID int
+}
+
+// Notifier queues notifications according to the preferences of their
+// recipients
+type Notifier struct {
+	digestInterval time.Duration
+}
+
+// NewNotifier creates a notifier that collects digest notifications into
+// one message every digestInterval
+func NewNotifier(digestInterval time.Duration) *Notifier {
+	if digestInterval <= 0 {
+		digestInterval = 24 * time.Hour
+	}
+	return &Notifier{digestInterval: digestInterval}
+}
+
+// Notify queues a notification. Pass the transaction of the change that
+// caused it, so the notification is only sent if the change is committed.
+// Notifications the recipient turned off are dropped.
+func (n *Notifier) Notify(ctx context.Context, db Execer, notification Notification) error {
+	var pageID interface{}
+	if notification.PageID != 0 {
+		pageID = notification.PageID
+	}
+
+	// Digests of the same period share one send time and go out together
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO notification_outbox (user_id, kind, text, page_id, send_after)
+		SELECT $1, $2, $3, $4, CASE
+			WHEN d.delivery = $5 THEN to_timestamp((floor(extract(epoch FROM CURRENT_TIMESTAMP) / $6::float8) + 1) * $6::float8)
+			ELSE CURRENT_TIMESTAMP + $7::interval
+		END
+		FROM (
+			SELECT COALESCE(
+				(SELECT delivery FROM notification_preferences WHERE user_id = $1 AND kind = $2), $8
+			) AS delivery
+		) d
+		WHERE d.delivery <> $9
+	`, notification.UserID, notification.Kind, notification.Text, pageID,
+		DeliveryDigest, int64(n.digestInterval.Seconds()), fmt.Sprintf("%d seconds", int(batchWindow.Seconds())),
+		DeliveryInstant, DeliveryOff)
+	if err != nil {
+		return fmt.Errorf("queue %s notification for user %d: %w", notification.Kind, notification.UserID, err)
+	}
+	return nil
+}
+
+// Preferences returns the delivery of every kind for a user
+func Preferences(ctx context.Context, db *sql.DB, userID int) (map[string]string, error) {
+	prefs := make(map[string]string, len(Kinds))
+	for _, kind := range Kinds {
+		prefs[kind] = DeliveryInstant
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT kind, delivery FROM notification_preferences WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind, delivery string
+		if err := rows.Scan(&kind, &delivery); err != nil {
+			return nil, err
+		}
+		if _, ok := prefs[kind]; ok {
+			prefs[kind] = delivery
+		}
+	}
+	return prefs, rows.Err()
+}
+
+// ValidatePreferences checks that prefs only names known kinds and
+// deliveries
+func ValidatePreferences(prefs map[string]string) error {
+	for kind, delivery := range prefs {
+		if !validKind(kind) {
+			return fmt.Errorf("unknown notification kind %q", kind)
+		}
+		if delivery != DeliveryInstant && delivery != DeliveryDigest && delivery != DeliveryOff {
+			return fmt.Errorf("unknown delivery %q for %s, expected instant, digest or off", delivery, kind)
+		}
+	}
+	return nil
+}
+
+// SetPreferences stores the delivery of the given kinds, which must have
+// passed ValidatePreferences
+func SetPreferences(ctx context.Context, db *sql.DB, userID int, prefs map[string]string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for kind, delivery := range prefs {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO notification_preferences (user_id, kind, delivery) VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, kind) DO UPDATE SET delivery = EXCLUDED.delivery
+		`, userID, kind, delivery)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func validKind(kind string) bool {
+	for _, k := range Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// DisplayName formats a user's name for notification texts
+func DisplayName(firstName, lastName, username string) string {
+	name := strings.TrimSpace(firstName + " " + lastName)
+	if name != "" {
+		return name
+	}
+	if username != "" {
+		return "@" + username
+	}
+	return "Someone"
+}
@@ -0,0 +1,347 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"tma/health"
+	"tma/metrics"
+	"tma/telegram"
+)
+
+// Notification states stored in notification_outbox.status
+const (
+	StatusPending = "pending"
+	StatusSending = "sending"
+	StatusSent    = "sent"
+	// StatusSkipped marks notifications for users the bot may not message
+	StatusSkipped = "skipped"
+	StatusFailed  = "failed"
+)
+
+const (
+	pollInterval = 5 * time.Second
+	sendTimeout  = 30 * time.Second
+	// dbTimeout bounds each outbox update; they do not use the sender
+	// context so a batch sent during shutdown is still marked
+	dbTimeout = 10 * time.Second
+	// staleSendingAge is after how long a claimed notification is assumed
+	// to belong to a crashed sender and claimed again
+	staleSendingAge = 5 * time.Minute
+	maxAttempts     = 5
+	retryBackoff    = 30 * time.Second
+	// maxBatchItems caps the notifications listed in one message
+	maxBatchItems = 20
+	// retention is how long delivered and dropped notifications are kept
+	retention = 30 * 24 * time.Hour
+)
+
+// notifications counts outcomes per notification: its final status, or
+// retried when it went back into the queue
+var notifications = metrics.NewCounter("tma_notifications_total", "Notification delivery outcomes.", "status")
+
+// Sender delivers queued notifications. All notifications of a user that
+// are due are claimed together and sent as one message. Users who did not
+// allow the bot to message them, or who blocked it, are skipped.
+type Sender struct {
+	db     *sql.DB
+	client *telegram.Client
+	// miniAppURL and publicURL are used for the buttons opening pages
+	miniAppURL string
+	publicURL  string
+
+	lastPrune time.Time
+
+	heartbeat health.Heartbeat
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+func NewSender(db *sql.DB, client *telegram.Client, miniAppURL, publicURL string) *Sender {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Sender{
+		db:         db,
+		client:     client,
+		miniAppURL: strings.TrimRight(miniAppURL, "/"),
+		publicURL:  strings.TrimRight(publicURL, "/"),
+		ctx:        ctx,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+}
+
+// Run sends notifications until Close is called
+func (s *Sender) Run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.heartbeat.Beat()
+		for s.ctx.Err() == nil && s.sendNext() {
+			s.heartbeat.Beat()
+		}
+		s.prune()
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Heartbeat returns when the loop last ran, for health checks
+func (s *Sender) Heartbeat() time.Time {
+	return s.heartbeat.Last()
+}
+
+// Close stops the sender after the message being sent, if any
+func (s *Sender) Close() {
+	s.cancel()
+	<-s.done
+}
+
+type queued struct {
+	id       int64
+	text     string
+	pageID   sql.NullInt64
+	attempts int
+}
+
+// sendNext claims and sends the due notifications of one user. It
+// reports whether any were found, so the caller keeps going until the
+// queue is drained.
+func (s *Sender) sendNext() bool {
+	stale := fmt.Sprintf("%d seconds", int(staleSendingAge.Seconds()))
+	rows, err := s.db.QueryContext(s.ctx, `
+		WITH target AS (
+			SELECT user_id FROM notification_outbox
+			WHERE (status = $2 AND send_after <= CURRENT_TIMESTAMP)
+				OR (status = $1 AND claimed_at < CURRENT_TIMESTAMP - $3::interval)
+			ORDER BY send_after
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		UPDATE notification_outbox o SET status = $1, claimed_at = CURRENT_TIMESTAMP
+		FROM target
+		WHERE o.user_id = target.user_id
+			AND ((o.status = $2 AND o.send_after <= CURRENT_TIMESTAMP)
+				OR (o.status = $1 AND o.claimed_at < CURRENT_TIMESTAMP - $3::interval))
+		RETURNING o.user_id, o.id, o.text, o.page_id, o.attempts
+	`, StatusSending, StatusPending, stale)
+	if err != nil {
+		slog.Error("Failed to claim notifications", "err", err)
+		return false
+	}
+
+	var userID int
+	var batch []queued
+	for rows.Next() {
+		var q queued
+		if err := rows.Scan(&userID, &q.id, &q.text, &q.pageID, &q.attempts); err != nil {
+			rows.Close()
+			slog.Error("Failed to scan notification", "err", err)
+			return false
+		}
+		batch = append(batch, q)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		slog.Error("Failed to claim notifications", "err", err)
+		return false
+	}
+	if len(batch) == 0 {
+		return false
+	}
+
+	// RETURNING does not keep the order of the queue
+	sort.Slice(batch, func(i, j int) bool { return batch[i].id < batch[j].id })
+	s.deliver(userID, batch)
+	return true
+}
+
+func (s *Sender) deliver(userID int, batch []queued) {
+	ids := make([]int64, len(batch))
+	for i, q := range batch {
+		ids[i] = q.id
+	}
+
+	var chatID int64
+	var allowed bool
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	err := s.db.QueryRowContext(ctx, `SELECT telegram_id, allows_write_to_pm FROM users WHERE id = $1`, userID).Scan(&chatID, &allowed)
+	cancel()
+	if err != nil {
+		slog.Error("Failed to look up notified user", "user_id", userID, "err", err)
+		s.retry(ids, batch, err)
+		return
+	}
+	if !allowed {
+		s.finish(ids, StatusSkipped, "user does not allow messages from the bot")
+		return
+	}
+
+	// Shutting down lets the message being sent go out
+	ctx, cancel = context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	_, err = s.client.SendMessage(ctx, s.message(chatID, batch))
+
+	switch {
+	case err == nil:
+		s.finish(ids, StatusSent, "")
+	case telegram.IsForbidden(err):
+		// The user blocked the bot; ask again through the Mini App
+		if err := s.disallow(userID); err != nil {
+			slog.Error("Failed to update notified user", "user_id", userID, "err", err)
+		}
+		s.finish(ids, StatusSkipped, err.Error())
+	case telegram.IsPermanent(err):
+		slog.Warn("Dropping notifications", "user_id", userID, "err", err)
+		s.finish(ids, StatusFailed, err.Error())
+	default:
+		slog.Warn("Failed to notify user", "user_id", userID, "err", err)
+		s.retry(ids, batch, err)
+	}
+}
+
+func (s *Sender) disallow(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `UPDATE users SET allows_write_to_pm = FALSE WHERE id = $1`, userID)
+	return err
+}
+
+// message renders a batch: a single notification as is, several as a list
+func (s *Sender) message(chatID int64, batch []queued) telegram.SendMessageParams {
+	params := telegram.SendMessageParams{ChatID: chatID}
+
+	if len(batch) == 1 {
+		params.Text = batch[0].text
+		if batch[0].pageID.Valid {
+			if button, ok := s.pageButton(int(batch[0].pageID.Int64)); ok {
+				params.ReplyMarkup = &telegram.InlineKeyboardMarkup{InlineKeyboard: [][]telegram.InlineKeyboardButton{{button}}}
+			}
+		}
+		return params
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "You have %d new notifications:\n", len(batch))
+	for i, q := range batch {
+		if i == maxBatchItems {
+			fmt.Fprintf(&b, "\n…and %d more", len(batch)-maxBatchItems)
+			break
+		}
+		fmt.Fprintf(&b, "\n• %s", q.text)
+	}
+	params.Text = b.String()
+	if button, ok := s.pageButton(0); ok {
+		params.ReplyMarkup = &telegram.InlineKeyboardMarkup{InlineKeyboard: [][]telegram.InlineKeyboardButton{{button}}}
+	}
+	return params
+}
+
+// pageButton opens a page, or the app when pageID is 0. Notifications go
+// to private chats, where web_app buttons work.
+func (s *Sender) pageButton(pageID int) (telegram.InlineKeyboardButton, bool) {
+	button := telegram.InlineKeyboardButton{Text: "Open app"}
+	if pageID != 0 {
+		button.Text = "Open page"
+	}
+
+	if s.miniAppURL != "" {
+		u := s.miniAppURL
+		if pageID != 0 {
+			u += "?page=" + url.QueryEscape(strconv.Itoa(pageID))
+		}
+		button.WebApp = &telegram.WebAppInfo{URL: u}
+		return button, true
+	}
+	if s.publicURL != "" && pageID != 0 {
+		button.URL = fmt.Sprintf("%s/p/%d", s.publicURL, pageID)
+		return button, true
+	}
+	return button, false
+}
+
+func (s *Sender) finish(ids []int64, status, reason string) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE notification_outbox
+		SET status = $1, last_error = NULLIF($2, ''), sent_at = CASE WHEN $1 = $3 THEN CURRENT_TIMESTAMP END
+		WHERE id = ANY($4)
+	`, status, reason, StatusSent, pq.Array(ids))
+	if err != nil {
+		slog.Error("Failed to mark notifications", "status", status, "err", err)
+		return
+	}
+	notifications.Add(float64(len(ids)), status)
+}
+
+// retry puts a batch back into the queue with a growing delay, or gives up
+// on it after maxAttempts
+func (s *Sender) retry(ids []int64, batch []queued, cause error) {
+	attempts := 0
+	for _, q := range batch {
+		attempts = max(attempts, q.attempts)
+	}
+	delay := retryBackoff << attempts
+	var apiErr *telegram.Error
+	if errors.As(cause, &apiErr) && apiErr.RetryAfter > delay {
+		delay = apiErr.RetryAfter
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE notification_outbox
+		SET attempts = attempts + 1,
+			status = CASE WHEN attempts + 1 >= $1 THEN $2 ELSE $3 END,
+			last_error = $4,
+			send_after = CURRENT_TIMESTAMP + $5::interval,
+			claimed_at = NULL
+		WHERE id = ANY($6)
+	`, maxAttempts, StatusFailed, StatusPending, cause.Error(),
+		fmt.Sprintf("%d seconds", int(delay.Seconds())), pq.Array(ids))
+	if err != nil {
+		slog.Error("Failed to requeue notifications", "err", err)
+		return
+	}
+	for _, q := range batch {
+		if q.attempts+1 >= maxAttempts {
+			notifications.Inc(StatusFailed)
+		} else {
+			notifications.Inc("retried")
+		}
+	}
+}
+
+// prune drops old delivered and dropped notifications at most once an hour
+func (s *Sender) prune() {
+	if time.Since(s.lastPrune) < time.Hour {
+		return
+	}
+	s.lastPrune = time.Now()
+
+	_, err := s.db.ExecContext(s.ctx, `
+		DELETE FROM notification_outbox
+		WHERE status IN ($1, $2, $3) AND created_at < CURRENT_TIMESTAMP - $4::interval
+	`, StatusSent, StatusSkipped, StatusFailed, fmt.Sprintf("%d seconds", int(retention.Seconds())))
+	if err != nil {
+		slog.Error("Failed to prune notifications", "err", err)
+	}
+}
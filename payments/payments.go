@@ -0,0 +1,438 @@
+// Package payments sells access to paid pages for Telegram Stars.
+//
+// A purchase starts as a pending row in the purchases ledger together with
+// an invoice link the Mini App opens. Telegram then asks the bot to
+// confirm the payment with a pre_checkout_query, and reports the charge
+// with a successful_payment message, which marks the purchase paid.
+// Refunds go back through the Bot API and are confirmed by a
+// refunded_payment message.
+package payments
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+
+	"tma/access"
+	"tma/bot"
+	"tma/models"
+	"tma/telegram"
+)
+
+// Currency is the currency of payments in Telegram Stars
+const Currency = "XTR"
+
+// MaxPriceStars is the highest price a page can have
+const MaxPriceStars = 10000
+
+// An unpaid invoice is offered again for invoiceTTL. One whose link is not
+// there after invoiceLinkTimeout was never completed.
+const (
+	invoiceTTL         = 24 * time.Hour
+	invoiceLinkTimeout = time.Minute
+)
+
+// Purchase states stored in purchases.status
+const (
+	StatusPending  = "pending"
+	StatusPaid     = "paid"
+	StatusRefunded = "refunded"
+)
+
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrNotForSale    = errors.New("page is not for sale")
+	ErrAlreadyOwned  = errors.New("page is already accessible")
+	ErrNotRefundable = errors.New("purchase is not paid")
+	// ErrInvoicePending is returned while another request creates the
+	// invoice of the same page for the same user
+	ErrInvoicePending = errors.New("invoice is being created")
+)
+
+// Service creates invoices, processes payment updates and answers whether
+// a user may read a paid page
+type Service struct {
+	db     *sql.DB
+	client *telegram.Client
+	authz  *access.Authorizer
+}
+
+func NewService(db *sql.DB, client *telegram.Client, authz *access.Authorizer) *Service {
+	return &Service{db: db, client: client, authz: authz}
+}
+
+// Register adds the payment update handlers to a router
+func (s *Service) Register(r *bot.Router) {
+	r.HandlePreCheckoutQuery(s.preCheckout)
+	r.HandlePayment(s.payment)
+}
+
+// Entitled reports whether a user may read a paid page: its members can,
+// as can everyone who bought it and was not refunded. Anonymous users,
+// with userID 0, cannot.
+func (s *Service) Entitled(ctx context.Context, pageID, userID int) (bool, error) {
+	if userID == 0 {
+		return false, nil
+	}
+
+	role, err := s.authz.PageRole(ctx, pageID, userID)
+	if err != nil {
+		return false, err
+	}
+	if role >= access.RoleViewer {
+		return true, nil
+	}
+
+	var paid bool
+	err = s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM purchases WHERE page_id = $1 AND user_id = $2 AND status = $3)
+	`, pageID, userID, StatusPaid).Scan(&paid)
+	return paid, err
+}
+
+// CreateInvoice records a pending purchase of a page and returns the
+// invoice link for it. A user has at most one pending purchase of a page:
+// an unpaid invoice at the current price is returned again, older ones are
+// replaced.
+func (s *Service) CreateInvoice(ctx context.Context, pageID, userID int, telegramID int64) (*models.Invoice, error) {
+	var title, description string
+	var price int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT title, COALESCE(description, ''), price_stars FROM pages WHERE id = $1
+	`, pageID).Scan(&title, &description, &price)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if price <= 0 {
+		return nil, ErrNotForSale
+	}
+
+	owned, err := s.Entitled(ctx, pageID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if owned {
+		return nil, ErrAlreadyOwned
+	}
+
+	invoice := &models.Invoice{Amount: price, Currency: Currency}
+	var link sql.NullString
+	err = s.db.QueryRowContext(ctx, `
+		SELECT id, invoice_link FROM purchases
+		WHERE user_id = $1 AND page_id = $2 AND status = $3 AND amount = $4 AND telegram_user_id = $5
+			AND created_at > CURRENT_TIMESTAMP - $6 * INTERVAL '1 second'
+			AND (invoice_link IS NOT NULL OR created_at > CURRENT_TIMESTAMP - $7 * INTERVAL '1 second')
+	`, userID, pageID, StatusPending, price, telegramID, int(invoiceTTL.Seconds()), int(invoiceLinkTimeout.Seconds())).Scan(&invoice.PurchaseID, &link)
+	switch {
+	case err == nil && link.Valid:
+		invoice.Link = link.String
+		return invoice, nil
+	case err == nil:
+		return nil, ErrInvoicePending
+	case err != sql.ErrNoRows:
+		return nil, err
+	}
+
+	// Whatever is still pending is stale or at another price
+	_, err = s.db.ExecContext(ctx, `
+		DELETE FROM purchases WHERE user_id = $1 AND page_id = $2 AND status = $3
+	`, userID, pageID, StatusPending)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := newPayload()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO purchases (user_id, telegram_user_id, page_id, title, amount, currency, payload)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, userID, telegramID, pageID, title, price, Currency, payload).Scan(&invoice.PurchaseID)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, ErrInvoicePending
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if description == "" {
+		description = "Access to the page " + title
+	}
+	invoice.Link, err = s.client.CreateInvoiceLink(ctx, telegram.CreateInvoiceLinkParams{
+		// Telegram limits titles to 32 and descriptions to 255 characters
+		Title:       truncate(title, 32, "Page"),
+		Description: truncate(description, 255, "Page access"),
+		Payload:     payload,
+		Currency:    Currency,
+		Prices:      []telegram.LabeledPrice{{Label: "Page access", Amount: price}},
+	})
+	if err != nil {
+		// The request may be gone by now, the pending row is dropped anyway
+		if _, derr := s.db.ExecContext(context.WithoutCancel(ctx), `DELETE FROM purchases WHERE id = $1 AND status = $2`, invoice.PurchaseID, StatusPending); derr != nil {
+			slog.Error("Failed to drop purchase", "purchase_id", invoice.PurchaseID, "err", derr)
+		}
+		return nil, fmt.Errorf("create invoice link: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, `UPDATE purchases SET invoice_link = $1 WHERE id = $2`, invoice.Link, invoice.PurchaseID)
+	if err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
+
+// purchaseColumns is the column list every purchase query selects, in
+// scanPurchase order
+const purchaseColumns = "id, user_id, page_id, workspace_id, title, amount, currency, status, created_at, paid_at, refunded_at"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPurchase(row rowScanner, p *models.Purchase) error {
+	var userID, pageID, workspaceID sql.NullInt64
+	var paidAt, refundedAt sql.NullTime
+	err := row.Scan(&p.ID, &userID, &pageID, &workspaceID, &p.Title, &p.Amount, &p.Currency, &p.Status, &p.CreatedAt, &paidAt, &refundedAt)
+	if err != nil {
+		return err
+	}
+	if userID.Valid {
+		id := int(userID.Int64)
+		p.UserID = &id
+	}
+	if pageID.Valid {
+		id := int(pageID.Int64)
+		p.PageID = &id
+	}
+	if workspaceID.Valid {
+		id := int(workspaceID.Int64)
+		p.WorkspaceID = &id
+	}
+	if paidAt.Valid {
+		p.PaidAt = &paidAt.Time
+	}
+	if refundedAt.Valid {
+		p.RefundedAt = &refundedAt.Time
+	}
+	return nil
+}
+
+// Get returns a purchase
+func (s *Service) Get(ctx context.Context, purchaseID int) (*models.Purchase, error) {
+	var p models.Purchase
+	err := scanPurchase(s.db.QueryRowContext(ctx, `SELECT `+purchaseColumns+` FROM purchases WHERE id = $1`, purchaseID), &p)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ListByUser returns the paid and refunded purchases of a user, newest
+// first. Pending purchases are invoices that were never paid.
+func (s *Service) ListByUser(ctx context.Context, userID int) ([]models.Purchase, error) {
+	return s.list(ctx, `WHERE user_id = $1 AND status <> '`+StatusPending+`'`, userID)
+}
+
+// ListByPage returns the paid and refunded purchases of a page
+func (s *Service) ListByPage(ctx context.Context, pageID int) ([]models.Purchase, error) {
+	return s.list(ctx, `WHERE page_id = $1 AND status <> '`+StatusPending+`'`, pageID)
+}
+
+func (s *Service) list(ctx context.Context, where string, args ...interface{}) ([]models.Purchase, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+purchaseColumns+` FROM purchases `+where+` ORDER BY created_at DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	purchases := make([]models.Purchase, 0)
+	for rows.Next() {
+		var p models.Purchase
+		if err := scanPurchase(rows, &p); err != nil {
+			return nil, err
+		}
+		purchases = append(purchases, p)
+	}
+	return purchases, rows.Err()
+}
+
+// Refund returns the Stars of a paid purchase to the buyer, which also
+// takes away their access to the page
+func (s *Service) Refund(ctx context.Context, purchaseID int) (*models.Purchase, error) {
+	var status, chargeID string
+	var telegramUserID int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT status, COALESCE(telegram_charge_id, ''), telegram_user_id FROM purchases WHERE id = $1
+	`, purchaseID).Scan(&status, &chargeID, &telegramUserID)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != StatusPaid {
+		return nil, ErrNotRefundable
+	}
+
+	err = s.client.RefundStarPayment(ctx, telegramUserID, chargeID)
+	// A refund made elsewhere, e.g. by Telegram support, still needs to be
+	// recorded here
+	if err != nil && !strings.Contains(err.Error(), "CHARGE_ALREADY_REFUNDED") {
+		return nil, fmt.Errorf("refund star payment: %w", err)
+	}
+
+	if _, err := s.markRefunded(ctx, chargeID); err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, purchaseID)
+}
+
+func (s *Service) markRefunded(ctx context.Context, chargeID string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE purchases SET status = $1, refunded_at = CURRENT_TIMESTAMP
+		WHERE telegram_charge_id = $2 AND status = $3
+	`, StatusRefunded, chargeID, StatusPaid)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// preCheckout approves a payment only for a pending purchase of a page
+// that still exists, at the invoiced price, by the user it was issued to
+func (s *Service) preCheckout(ctx context.Context, q *telegram.PreCheckoutQuery) error {
+	reason, err := s.checkPurchase(ctx, q)
+	if err != nil {
+		slog.Error("Failed to check pre-checkout query", "query_id", q.ID, "err", err)
+		reason = "Payment is temporarily unavailable, please try again later."
+	}
+	return s.client.AnswerPreCheckoutQuery(ctx, telegram.AnswerPreCheckoutQueryParams{
+		PreCheckoutQueryID: q.ID,
+		OK:                 reason == "",
+		ErrorMessage:       reason,
+	})
+}
+
+// checkPurchase returns why a payment must be rejected, or "" to accept it
+func (s *Service) checkPurchase(ctx context.Context, q *telegram.PreCheckoutQuery) (string, error) {
+	var userID, pageID sql.NullInt64
+	var telegramUserID int64
+	var amount int
+	var currency, status string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT user_id, page_id, telegram_user_id, amount, currency, status FROM purchases WHERE payload = $1
+	`, q.InvoicePayload).Scan(&userID, &pageID, &telegramUserID, &amount, &currency, &status)
+	if err == sql.ErrNoRows {
+		return "This invoice is no longer valid.", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case status != StatusPending:
+		return "This invoice has already been paid.", nil
+	case telegramUserID != q.From.ID:
+		return "This invoice was issued to another user.", nil
+	case currency != q.Currency || amount != q.TotalAmount:
+		return "The price of this invoice does not match.", nil
+	case !pageID.Valid || !userID.Valid:
+		return "This page no longer exists.", nil
+	}
+
+	owned, err := s.Entitled(ctx, int(pageID.Int64), int(userID.Int64))
+	if err != nil {
+		return "", err
+	}
+	if owned {
+		return "You already have access to this page.", nil
+	}
+	return "", nil
+}
+
+func (s *Service) payment(ctx context.Context, msg *telegram.Message) error {
+	if p := msg.SuccessfulPayment; p != nil {
+		return s.paid(ctx, p)
+	}
+	if p := msg.RefundedPayment; p != nil {
+		refunded, err := s.markRefunded(ctx, p.TelegramPaymentChargeID)
+		if err != nil {
+			return err
+		}
+		if !refunded {
+			slog.Warn("Refund of unknown or unpaid charge", "charge_id", p.TelegramPaymentChargeID)
+		}
+	}
+	return nil
+}
+
+func (s *Service) paid(ctx context.Context, p *telegram.SuccessfulPayment) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE purchases SET status = $1, telegram_charge_id = $2, paid_at = CURRENT_TIMESTAMP
+		WHERE payload = $3 AND status = $4
+	`, StatusPaid, p.TelegramPaymentChargeID, p.InvoicePayload, StatusPending)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		return nil
+	}
+
+	// The charge went through, so it must not be lost silently; it can
+	// still be refunded by hand with its charge ID
+	var chargeID string
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(telegram_charge_id, '') FROM purchases WHERE payload = $1
+	`, p.InvoicePayload).Scan(&chargeID)
+	if err == nil && chargeID == p.TelegramPaymentChargeID {
+		return nil
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	slog.Error("Unexpected payment",
+		"amount", p.TotalAmount, "currency", p.Currency, "invoice", p.InvoicePayload, "charge_id", p.TelegramPaymentChargeID)
+	return nil
+}
+
+func newPayload() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// truncate shortens s to at most n characters, using fallback when s is
+// empty
+func truncate(s string, n int, fallback string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return fallback
+	}
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:n-1]) + "…"
+}
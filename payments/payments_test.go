@@ -0,0 +1,401 @@
+package payments
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tma/access"
+	"tma/database"
+	"tma/telegram"
+	"tma/telegram/telegramtest"
+)
+
+// The tests run against the database in TEST_DATABASE_URL, which is
+// migrated first. Records are added next to existing data.
+
+var lastTelegramID = time.Now().UnixNano() / 1000
+
+type fixture struct {
+	db      *sql.DB
+	server  *telegramtest.Server
+	service *Service
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := database.New(url, database.Options{})
+	if err != nil {
+		t.Fatalf("database.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	server := telegramtest.NewServer("123456:test-token")
+	t.Cleanup(server.Close)
+	return &fixture{
+		db:      db.DB,
+		server:  server,
+		service: NewService(db.DB, server.Client(), access.NewAuthorizer(db.DB)),
+	}
+}
+
+// buyer is a user with their Telegram account
+type buyer struct {
+	id int
+	tg telegram.User
+}
+
+func (f *fixture) user(t *testing.T) buyer {
+	t.Helper()
+	tg := telegram.User{ID: atomic.AddInt64(&lastTelegramID, 1), FirstName: "Buyer"}
+	var id int
+	if err := f.db.QueryRow(`INSERT INTO users (telegram_id, first_name) VALUES ($1, $2) RETURNING id`, tg.ID, tg.FirstName).Scan(&id); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return buyer{id: id, tg: tg}
+}
+
+// page creates a page for sale in a new workspace of owner
+func (f *fixture) page(t *testing.T, owner buyer, price int) (pageID, workspaceID int) {
+	t.Helper()
+	err := f.db.QueryRow(`INSERT INTO workspaces (name, owner_id) VALUES ('payments', $1) RETURNING id`, owner.id).Scan(&workspaceID)
+	if err != nil {
+		t.Fatalf("create workspace: %v", err)
+	}
+	if _, err := f.db.Exec(`INSERT INTO workspace_members (workspace_id, user_id, role) VALUES ($1, $2, 'owner')`, workspaceID, owner.id); err != nil {
+		t.Fatalf("add workspace owner: %v", err)
+	}
+	err = f.db.QueryRow(`
+		INSERT INTO pages (user_id, workspace_id, title, price_stars) VALUES ($1, $2, 'For sale', $3) RETURNING id
+	`, owner.id, workspaceID, price).Scan(&pageID)
+	if err != nil {
+		t.Fatalf("create page: %v", err)
+	}
+	return pageID, workspaceID
+}
+
+// lastMessage confirms the queued updates and returns the message of the
+// last one
+func (f *fixture) lastMessage(t *testing.T) *telegram.Message {
+	t.Helper()
+	client := f.server.Client()
+	updates, err := client.GetUpdates(context.Background(), telegram.GetUpdatesParams{})
+	if err != nil || len(updates) == 0 || updates[len(updates)-1].Message == nil {
+		t.Fatalf("GetUpdates returned %v, %v, want a message last", updates, err)
+	}
+	last := updates[len(updates)-1]
+	if _, err := client.GetUpdates(context.Background(), telegram.GetUpdatesParams{Offset: last.UpdateID + 1}); err != nil {
+		t.Fatalf("confirm updates: %v", err)
+	}
+	return last.Message
+}
+
+// buy pays for a page the way Telegram does and returns the purchase ID
+func (f *fixture) buy(t *testing.T, pageID int, b buyer) int {
+	t.Helper()
+	ctx := context.Background()
+	invoice, err := f.service.CreateInvoice(ctx, pageID, b.id, b.tg.ID)
+	if err != nil {
+		t.Fatalf("CreateInvoice: %v", err)
+	}
+	update, err := f.server.Pay(invoice.Link, b.tg)
+	if err != nil {
+		t.Fatalf("Pay: %v", err)
+	}
+	if err := f.service.preCheckout(ctx, update.PreCheckoutQuery); err != nil {
+		t.Fatalf("preCheckout: %v", err)
+	}
+	msg := f.lastMessage(t)
+	if msg.SuccessfulPayment == nil {
+		t.Fatalf("payment was rejected, got %+v", msg)
+	}
+	if err := f.service.payment(ctx, msg); err != nil {
+		t.Fatalf("payment: %v", err)
+	}
+	return invoice.PurchaseID
+}
+
+func (f *fixture) status(t *testing.T, purchaseID int) string {
+	t.Helper()
+	p, err := f.service.Get(context.Background(), purchaseID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	return p.Status
+}
+
+func TestEntitled(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	owner, reader := f.user(t), f.user(t)
+	pageID, _ := f.page(t, owner, 50)
+
+	entitled := func(userID int) bool {
+		t.Helper()
+		ok, err := f.service.Entitled(ctx, pageID, userID)
+		if err != nil {
+			t.Fatalf("Entitled: %v", err)
+		}
+		return ok
+	}
+
+	if entitled(0) {
+		t.Error("anonymous user is entitled")
+	}
+	if !entitled(owner.id) {
+		t.Error("workspace owner is not entitled")
+	}
+	if entitled(reader.id) {
+		t.Error("user is entitled before paying")
+	}
+
+	purchaseID := f.buy(t, pageID, reader)
+	if !entitled(reader.id) {
+		t.Error("user is not entitled after paying")
+	}
+
+	if _, err := f.service.Refund(ctx, purchaseID); err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	if entitled(reader.id) {
+		t.Error("user is still entitled after a refund")
+	}
+}
+
+func TestCheckPurchase(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	owner, reader, other := f.user(t), f.user(t), f.user(t)
+	pageID, _ := f.page(t, owner, 50)
+
+	invoice, err := f.service.CreateInvoice(ctx, pageID, reader.id, reader.tg.ID)
+	if err != nil {
+		t.Fatalf("CreateInvoice: %v", err)
+	}
+	var payload string
+	if err := f.db.QueryRow(`SELECT payload FROM purchases WHERE id = $1`, invoice.PurchaseID).Scan(&payload); err != nil {
+		t.Fatalf("read payload: %v", err)
+	}
+	query := func(from buyer, amount int, payload string) *telegram.PreCheckoutQuery {
+		return &telegram.PreCheckoutQuery{ID: "q", From: from.tg, Currency: Currency, TotalAmount: amount, InvoicePayload: payload}
+	}
+
+	tests := []struct {
+		name   string
+		query  *telegram.PreCheckoutQuery
+		reject bool
+	}{
+		{"valid", query(reader, 50, payload), false},
+		{"unknown invoice", query(reader, 50, "unknown"), true},
+		{"another user", query(other, 50, payload), true},
+		{"another amount", query(reader, 40, payload), true},
+	}
+	for _, tt := range tests {
+		reason, err := f.service.checkPurchase(ctx, tt.query)
+		if err != nil {
+			t.Fatalf("%s: checkPurchase: %v", tt.name, err)
+		}
+		if (reason != "") != tt.reject {
+			t.Errorf("%s: checkPurchase returned %q", tt.name, reason)
+		}
+	}
+
+	// A paid invoice is not paid twice
+	f.buy(t, pageID, reader)
+	var paidPayload string
+	if err := f.db.QueryRow(`SELECT payload FROM purchases WHERE page_id = $1 AND user_id = $2 AND status = $3`, pageID, reader.id, StatusPaid).Scan(&paidPayload); err != nil {
+		t.Fatalf("read paid purchase: %v", err)
+	}
+	if reason, err := f.service.checkPurchase(ctx, query(reader, 50, paidPayload)); err != nil || reason == "" {
+		t.Errorf("paid invoice: checkPurchase returned %q, %v", reason, err)
+	}
+
+	// Nor is the invoice of a deleted page
+	invoice, err = f.service.CreateInvoice(ctx, pageID, other.id, other.tg.ID)
+	if err != nil {
+		t.Fatalf("CreateInvoice: %v", err)
+	}
+	if err := f.db.QueryRow(`SELECT payload FROM purchases WHERE id = $1`, invoice.PurchaseID).Scan(&payload); err != nil {
+		t.Fatalf("read payload: %v", err)
+	}
+	if _, err := f.db.Exec(`DELETE FROM pages WHERE id = $1`, pageID); err != nil {
+		t.Fatalf("delete page: %v", err)
+	}
+	if reason, err := f.service.checkPurchase(ctx, query(other, 50, payload)); err != nil || reason == "" {
+		t.Errorf("deleted page: checkPurchase returned %q, %v", reason, err)
+	}
+}
+
+func TestPaid(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	owner, reader := f.user(t), f.user(t)
+	pageID, _ := f.page(t, owner, 50)
+
+	invoice, err := f.service.CreateInvoice(ctx, pageID, reader.id, reader.tg.ID)
+	if err != nil {
+		t.Fatalf("CreateInvoice: %v", err)
+	}
+	var payload string
+	if err := f.db.QueryRow(`SELECT payload FROM purchases WHERE id = $1`, invoice.PurchaseID).Scan(&payload); err != nil {
+		t.Fatalf("read payload: %v", err)
+	}
+
+	payment := &telegram.SuccessfulPayment{Currency: Currency, TotalAmount: 50, InvoicePayload: payload, TelegramPaymentChargeID: "charge-" + payload}
+	// Telegram may deliver the message twice
+	for i := 0; i < 2; i++ {
+		if err := f.service.paid(ctx, payment); err != nil {
+			t.Fatalf("paid %d: %v", i, err)
+		}
+	}
+	p, err := f.service.Get(ctx, invoice.PurchaseID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if p.Status != StatusPaid || p.PaidAt == nil {
+		t.Errorf("purchase is %+v, want paid", p)
+	}
+
+	// A charge that matches no pending purchase is logged, not retried
+	unknown := &telegram.SuccessfulPayment{Currency: Currency, TotalAmount: 50, InvoicePayload: "unknown", TelegramPaymentChargeID: "charge-unknown-" + payload}
+	if err := f.service.paid(ctx, unknown); err != nil {
+		t.Errorf("paid of an unknown invoice: %v", err)
+	}
+	other := &telegram.SuccessfulPayment{Currency: Currency, TotalAmount: 50, InvoicePayload: payload, TelegramPaymentChargeID: "charge-other-" + payload}
+	if err := f.service.paid(ctx, other); err != nil {
+		t.Errorf("second charge of a paid invoice: %v", err)
+	}
+	if p, _ := f.service.Get(ctx, invoice.PurchaseID); p.Status != StatusPaid {
+		t.Errorf("purchase is %q after a second charge, want paid", p.Status)
+	}
+}
+
+func TestRefund(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	owner, reader := f.user(t), f.user(t)
+	pageID, workspaceID := f.page(t, owner, 50)
+
+	if _, err := f.service.Refund(ctx, -1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Refund of a missing purchase returned %v, want ErrNotFound", err)
+	}
+
+	invoice, err := f.service.CreateInvoice(ctx, pageID, reader.id, reader.tg.ID)
+	if err != nil {
+		t.Fatalf("CreateInvoice: %v", err)
+	}
+	if _, err := f.service.Refund(ctx, invoice.PurchaseID); !errors.Is(err, ErrNotRefundable) {
+		t.Errorf("Refund of a pending purchase returned %v, want ErrNotRefundable", err)
+	}
+
+	purchaseID := f.buy(t, pageID, reader)
+	if purchaseID != invoice.PurchaseID {
+		t.Errorf("bought purchase %d, want the open invoice %d", purchaseID, invoice.PurchaseID)
+	}
+	p, err := f.service.Refund(ctx, purchaseID)
+	if err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	if p.Status != StatusRefunded || p.RefundedAt == nil {
+		t.Errorf("refunded purchase is %+v", p)
+	}
+	if calls := f.server.Calls("refundStarPayment"); len(calls) != 1 {
+		t.Errorf("%d refundStarPayment calls, want 1", len(calls))
+	}
+	// The refunded_payment message that follows changes nothing
+	if err := f.service.payment(ctx, f.lastMessage(t)); err != nil {
+		t.Errorf("payment of the refund message: %v", err)
+	}
+	if _, err := f.service.Refund(ctx, purchaseID); !errors.Is(err, ErrNotRefundable) {
+		t.Errorf("second Refund returned %v, want ErrNotRefundable", err)
+	}
+
+	// A refund made outside the API is still recorded
+	purchaseID = f.buy(t, pageID, reader)
+	var chargeID string
+	if err := f.db.QueryRow(`SELECT telegram_charge_id FROM purchases WHERE id = $1`, purchaseID).Scan(&chargeID); err != nil {
+		t.Fatalf("read charge: %v", err)
+	}
+	if err := f.server.Client().RefundStarPayment(ctx, reader.tg.ID, chargeID); err != nil {
+		t.Fatalf("RefundStarPayment: %v", err)
+	}
+	if _, err := f.service.Refund(ctx, purchaseID); err != nil {
+		t.Fatalf("Refund of a charge refunded elsewhere: %v", err)
+	}
+	if status := f.status(t, purchaseID); status != StatusRefunded {
+		t.Errorf("purchase is %q, want refunded", status)
+	}
+
+	// Purchases keep the workspace of deleted pages
+	purchaseID = f.buy(t, pageID, reader)
+	if _, err := f.db.Exec(`DELETE FROM pages WHERE id = $1`, pageID); err != nil {
+		t.Fatalf("delete page: %v", err)
+	}
+	p, err = f.service.Get(ctx, purchaseID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if p.PageID != nil || p.WorkspaceID == nil || *p.WorkspaceID != workspaceID {
+		t.Errorf("purchase of a deleted page is %+v, want workspace %d", p, workspaceID)
+	}
+	if _, err := f.service.Refund(ctx, purchaseID); err != nil {
+		t.Errorf("Refund of a deleted page: %v", err)
+	}
+}
+
+func TestCreateInvoiceOnce(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	owner, reader := f.user(t), f.user(t)
+	pageID, _ := f.page(t, owner, 50)
+
+	first, err := f.service.CreateInvoice(ctx, pageID, reader.id, reader.tg.ID)
+	if err != nil {
+		t.Fatalf("CreateInvoice: %v", err)
+	}
+	second, err := f.service.CreateInvoice(ctx, pageID, reader.id, reader.tg.ID)
+	if err != nil {
+		t.Fatalf("second CreateInvoice: %v", err)
+	}
+	if second.PurchaseID != first.PurchaseID || second.Link != first.Link {
+		t.Errorf("second invoice is %+v, want the open one %+v", second, first)
+	}
+	if calls := f.server.Calls("createInvoiceLink"); len(calls) != 1 {
+		t.Errorf("%d createInvoiceLink calls, want 1", len(calls))
+	}
+
+	// A new price replaces the open invoice
+	if _, err := f.db.Exec(`UPDATE pages SET price_stars = 70 WHERE id = $1`, pageID); err != nil {
+		t.Fatalf("update price: %v", err)
+	}
+	third, err := f.service.CreateInvoice(ctx, pageID, reader.id, reader.tg.ID)
+	if err != nil {
+		t.Fatalf("CreateInvoice at a new price: %v", err)
+	}
+	if third.PurchaseID == first.PurchaseID || third.Amount != 70 {
+		t.Errorf("invoice at a new price is %+v", third)
+	}
+	var pending int
+	if err := f.db.QueryRow(`SELECT COUNT(*) FROM purchases WHERE page_id = $1 AND user_id = $2 AND status = $3`, pageID, reader.id, StatusPending).Scan(&pending); err != nil {
+		t.Fatalf("count pending: %v", err)
+	}
+	if pending != 1 {
+		t.Errorf("%d pending purchases, want 1", pending)
+	}
+
+	// An invoice still being created is not created twice
+	if _, err := f.db.Exec(`UPDATE purchases SET invoice_link = NULL WHERE id = $1`, third.PurchaseID); err != nil {
+		t.Fatalf("clear link: %v", err)
+	}
+	if _, err := f.service.CreateInvoice(ctx, pageID, reader.id, reader.tg.ID); !errors.Is(err, ErrInvoicePending) {
+		t.Errorf("CreateInvoice while another is created returned %v, want ErrInvoicePending", err)
+	}
+}
@@ -0,0 +1,181 @@
+package preview
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Cache stores rendered preview images on disk. Files are keyed by the
+// caller-supplied version string, so a changed page simply produces a new
+// file instead of requiring invalidation. Outdated versions are never asked
+// for again and age out: once the files exceed maxBytes the least recently
+// used ones are removed.
+type Cache struct {
+	dir      string
+	maxBytes int64
+
+	mu    sync.Mutex
+	size  int64
+	order *list.List // of *cacheEntry, most recently used first
+	files map[string]*list.Element
+	// rendering holds the previews being rendered, so concurrent requests
+	// for one wait for it while other lookups go on
+	rendering map[string]*pendingRender
+}
+
+type cacheEntry struct {
+	name string
+	size int64
+}
+
+type pendingRender struct {
+	done chan struct{}
+	err  error
+}
+
+// NewCache opens the cache in dir, picking up the previews already there.
+// A maxBytes of 0 or less leaves the cache unbounded
+func NewCache(dir string, maxBytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create preview cache dir: %w", err)
+	}
+	c := &Cache{
+		dir:       dir,
+		maxBytes:  maxBytes,
+		order:     list.New(),
+		files:     make(map[string]*list.Element),
+		rendering: make(map[string]*pendingRender),
+	}
+	if err := c.load(); err != nil {
+		return nil, fmt.Errorf("failed to read preview cache dir: %w", err)
+	}
+	return c, nil
+}
+
+// load indexes the previews left by an earlier run, oldest last
+func (c *Cache) load() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	var infos []os.FileInfo
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !strings.HasSuffix(e.Name(), ".png") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ModTime().After(infos[j].ModTime()) })
+
+	for _, info := range infos {
+		c.files[info.Name()] = c.order.PushBack(&cacheEntry{name: info.Name(), size: info.Size()})
+		c.size += info.Size()
+	}
+	c.evict()
+	return nil
+}
+
+// Path returns the file holding the preview for key, rendering it first
+// when it is not cached yet
+func (c *Cache) Path(key string, card Card) (string, error) {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:16]) + ".png"
+	path := filepath.Join(c.dir, name)
+
+	c.mu.Lock()
+	if el, ok := c.files[name]; ok {
+		if _, err := os.Stat(path); err == nil {
+			c.order.MoveToFront(el)
+			c.mu.Unlock()
+			return path, nil
+		}
+		// Removed behind our back, render it again
+		c.remove(el)
+	}
+	if pending, ok := c.rendering[name]; ok {
+		c.mu.Unlock()
+		<-pending.done
+		if pending.err != nil {
+			return "", pending.err
+		}
+		return path, nil
+	}
+	pending := &pendingRender{done: make(chan struct{})}
+	c.rendering[name] = pending
+	c.mu.Unlock()
+
+	// Rendering is slow, so it runs without holding the lock
+	size, err := c.write(path, card)
+
+	c.mu.Lock()
+	delete(c.rendering, name)
+	if err == nil {
+		c.files[name] = c.order.PushFront(&cacheEntry{name: name, size: size})
+		c.size += size
+		c.evict()
+	}
+	c.mu.Unlock()
+
+	pending.err = err
+	close(pending.done)
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (c *Cache) write(path string, card Card) (int64, error) {
+	tmp, err := os.CreateTemp(c.dir, "preview-*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := png.Encode(tmp, Render(card)); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// evict removes the least recently used previews until the cache fits,
+// always keeping the most recent one so the caller can still serve it
+func (c *Cache) evict() {
+	if c.maxBytes <= 0 {
+		return
+	}
+	for c.size > c.maxBytes && c.order.Len() > 1 {
+		el := c.order.Back()
+		os.Remove(filepath.Join(c.dir, el.Value.(*cacheEntry).name))
+		c.remove(el)
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	e := c.order.Remove(el).(*cacheEntry)
+	delete(c.files, e.name)
+	c.size -= e.size
+}
@@ -0,0 +1,87 @@
+package preview
+
+import (
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestCacheEvicts(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewCache(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := cache.Path("a", Card{Title: "A"})
+	if err != nil {
+		t.Fatalf("Path a: %v", err)
+	}
+	info, err := os.Stat(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Room for about two previews
+	cache, err = NewCache(dir, 2*info.Size()+info.Size()/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := cache.Path("b", Card{Title: "A"})
+	if _, err := cache.Path("a", Card{Title: "A"}); err != nil {
+		t.Fatalf("Path a: %v", err)
+	}
+	c, _ := cache.Path("c", Card{Title: "A"})
+
+	// b was used least recently
+	for path, want := range map[string]bool{a: true, b: false, c: true} {
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("%s exists %t, want %t", path, err == nil, want)
+		}
+	}
+	if cache.size > cache.maxBytes || cache.order.Len() != 2 {
+		t.Errorf("cache holds %d files of %d bytes, want 2 within %d", cache.order.Len(), cache.size, cache.maxBytes)
+	}
+
+	// A removed preview is rendered again
+	if _, err := cache.Path("b", Card{Title: "A"}); err != nil {
+		t.Fatalf("Path b: %v", err)
+	}
+	if _, err := os.Stat(b); err != nil {
+		t.Errorf("b was not rendered again: %v", err)
+	}
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	cache, err := NewCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	paths := make([]string, 8)
+	for i := range paths {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := "a"
+			if i%2 == 1 {
+				key = "b"
+			}
+			path, err := cache.Path(key, Card{Title: key})
+			if err != nil {
+				t.Errorf("Path %s: %v", key, err)
+			}
+			paths[i] = path
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 2; i < len(paths); i++ {
+		if paths[i] != paths[i%2] {
+			t.Errorf("Path returned %s and %s for one key", paths[i], paths[i%2])
+		}
+	}
+	if cache.order.Len() != 2 || len(cache.rendering) != 0 {
+		t.Errorf("cache holds %d previews with %d rendering, want 2 and none", cache.order.Len(), len(cache.rendering))
+	}
+}
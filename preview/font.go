@@ -0,0 +1,111 @@
+package preview
+
+// glyphs is a 5x7 bitmap font covering digits, basic punctuation, Latin and
+// Cyrillic capitals. Lowercase letters are drawn with their capital forms.
+// Each glyph is seven rows of five cells, '#' marking a filled cell.
+var glyphs = map[rune][7]string{
+	' ':  {".....", ".....", ".....", ".....", ".....", ".....", "....."},
+	'!':  {"..#..", "..#..", "..#..", "..#..", "..#..", ".....", "..#.."},
+	'"':  {".#.#.", ".#.#.", ".....", ".....", ".....", ".....", "....."},
+	'#':  {".#.#.", "#####", ".#.#.", ".#.#.", ".#.#.", "#####", ".#.#."},
+	'%':  {"##..#", "##..#", "...#.", "..#..", ".#...", "#..##", "#..##"},
+	'&':  {".##..", "#..#.", "#.#..", ".#...", "#.#.#", "#..#.", ".##.#"},
+	'\'': {"..#..", "..#..", ".....", ".....", ".....", ".....", "....."},
+	'(':  {"...#.", "..#..", ".#...", ".#...", ".#...", "..#..", "...#."},
+	')':  {".#...", "..#..", "...#.", "...#.", "...#.", "..#..", ".#..."},
+	'*':  {".....", "..#..", "#.#.#", ".###.", "#.#.#", "..#..", "....."},
+	'+':  {".....", "..#..", "..#..", "#####", "..#..", "..#..", "....."},
+	',':  {".....", ".....", ".....", ".....", "..##.", "...#.", "..#.."},
+	'-':  {".....", ".....", ".....", "#####", ".....", ".....", "....."},
+	'.':  {".....", ".....", ".....", ".....", ".....", ".##..", ".##.."},
+	'/':  {"....#", "....#", "...#.", "..#..", ".#...", "#....", "#...."},
+	':':  {".....", ".##..", ".##..", ".....", ".##..", ".##..", "....."},
+	';':  {".....", ".##..", ".##..", ".....", ".##..", "..#..", ".#..."},
+	'?':  {".###.", "#...#", "....#", "...#.", "..#..", ".....", "..#.."},
+	'@':  {".###.", "#...#", "#.###", "#.#.#", "#.###", "#....", ".###."},
+	'_':  {".....", ".....", ".....", ".....", ".....", ".....", "#####"},
+	'«':  {".....", "..#.#", ".#.#.", "#.#..", ".#.#.", "..#.#", "....."},
+	'»':  {".....", "#.#..", ".#.#.", "..#.#", ".#.#.", "#.#..", "....."},
+	'—':  {".....", ".....", ".....", "#####", ".....", ".....", "....."},
+	'…':  {".....", ".....", ".....", ".....", ".....", ".....", "#.#.#"},
+
+	'0': {".###.", "#...#", "#..##", "#.#.#", "##..#", "#...#", ".###."},
+	'1': {"..#..", ".##..", "..#..", "..#..", "..#..", "..#..", ".###."},
+	'2': {".###.", "#...#", "....#", "...#.", "..#..", ".#...", "#####"},
+	'3': {"#####", "...#.", "..#..", "...#.", "....#", "#...#", ".###."},
+	'4': {"...#.", "..##.", ".#.#.", "#..#.", "#####", "...#.", "...#."},
+	'5': {"#####", "#....", "####.", "....#", "....#", "#...#", ".###."},
+	'6': {"..##.", ".#...", "#....", "####.", "#...#", "#...#", ".###."},
+	'7': {"#####", "....#", "...#.", "..#..", ".#...", ".#...", ".#..."},
+	'8': {".###.", "#...#", "#...#", ".###.", "#...#", "#...#", ".###."},
+	'9': {".###.", "#...#", "#...#", ".####", "....#", "...#.", ".##.."},
+
+	'A': {".###.", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+	'B': {"####.", "#...#", "#...#", "####.", "#...#", "#...#", "####."},
+	'C': {".###.", "#...#", "#....", "#....", "#....", "#...#", ".###."},
+	'D': {"###..", "#..#.", "#...#", "#...#", "#...#", "#..#.", "###.."},
+	'E': {"#####", "#....", "#....", "####.", "#....", "#....", "#####"},
+	'F': {"#####", "#....", "#....", "####.", "#....", "#....", "#...."},
+	'G': {".###.", "#...#", "#....", "#.###", "#...#", "#...#", ".####"},
+	'H': {"#...#", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+	'I': {".###.", "..#..", "..#..", "..#..", "..#..", "..#..", ".###."},
+	'J': {"..###", "...#.", "...#.", "...#.", "...#.", "#..#.", ".##.."},
+	'K': {"#...#", "#..#.", "#.#..", "##...", "#.#..", "#..#.", "#...#"},
+	'L': {"#....", "#....", "#....", "#....", "#....", "#....", "#####"},
+	'M': {"#...#", "##.##", "#.#.#", "#.#.#", "#...#", "#...#", "#...#"},
+	'N': {"#...#", "#...#", "##..#", "#.#.#", "#..##", "#...#", "#...#"},
+	'O': {".###.", "#...#", "#...#", "#...#", "#...#", "#...#", ".###."},
+	'P': {"####.", "#...#", "#...#", "####.", "#....", "#....", "#...."},
+	'Q': {".###.", "#...#", "#...#", "#...#", "#.#.#", "#..#.", ".##.#"},
+	'R': {"####.", "#...#", "#...#", "####.", "#.#..", "#..#.", "#...#"},
+	'S': {".####", "#....", "#....", ".###.", "....#", "....#", "####."},
+	'T': {"#####", "..#..", "..#..", "..#..", "..#..", "..#..", "..#.."},
+	'U': {"#...#", "#...#", "#...#", "#...#", "#...#", "#...#", ".###."},
+	'V': {"#...#", "#...#", "#...#", "#...#", "#...#", ".#.#.", "..#.."},
+	'W': {"#...#", "#...#", "#...#", "#.#.#", "#.#.#", "#.#.#", ".#.#."},
+	'X': {"#...#", "#...#", ".#.#.", "..#..", ".#.#.", "#...#", "#...#"},
+	'Y': {"#...#", "#...#", ".#.#.", "..#..", "..#..", "..#..", "..#.."},
+	'Z': {"#####", "....#", "...#.", "..#..", ".#...", "#....", "#####"},
+
+	'Б': {"#####", "#....", "#....", "####.", "#...#", "#...#", "####."},
+	'Г': {"#####", "#....", "#....", "#....", "#....", "#....", "#...."},
+	'Д': {"..##.", ".#.#.", ".#.#.", ".#.#.", ".#.#.", "#####", "#...#"},
+	'Ё': {".#.#.", "#####", "#....", "####.", "#....", "#....", "#####"},
+	'Ж': {"#.#.#", "#.#.#", ".###.", "..#..", ".###.", "#.#.#", "#.#.#"},
+	'З': {".###.", "#...#", "....#", "..##.", "....#", "#...#", ".###."},
+	'И': {"#...#", "#...#", "#..##", "#.#.#", "##..#", "#...#", "#...#"},
+	'Й': {".#.#.", "..#..", "#...#", "#..##", "#.#.#", "##..#", "#...#"},
+	'Л': {"..###", ".#..#", ".#..#", ".#..#", ".#..#", ".#..#", "#...#"},
+	'П': {"#####", "#...#", "#...#", "#...#", "#...#", "#...#", "#...#"},
+	'У': {"#...#", "#...#", "#...#", ".####", "....#", "#...#", ".###."},
+	'Ф': {"..#..", ".###.", "#.#.#", "#.#.#", "#.#.#", ".###.", "..#.."},
+	'Ц': {"#..#.", "#..#.", "#..#.", "#..#.", "#..#.", "#####", "....#"},
+	'Ч': {"#...#", "#...#", "#...#", ".####", "....#", "....#", "....#"},
+	'Ш': {"#.#.#", "#.#.#", "#.#.#", "#.#.#", "#.#.#", "#.#.#", "#####"},
+	'Щ': {"#.#.#", "#.#.#", "#.#.#", "#.#.#", "#.#.#", "#####", "....#"},
+	'Ъ': {"##...", ".#...", ".#...", ".###.", ".#..#", ".#..#", ".###."},
+	'Ы': {"#...#", "#...#", "#...#", "##..#", "#.#.#", "#.#.#", "##..#"},
+	'Ь': {"#....", "#....", "#....", "####.", "#...#", "#...#", "####."},
+	'Э': {".###.", "#...#", "....#", "..###", "....#", "#...#", ".###."},
+	'Ю': {"#..#.", "#.#.#", "#.#.#", "###.#", "#.#.#", "#.#.#", "#..#."},
+	'Я': {".####", "#...#", "#...#", ".####", "..#.#", ".#..#", "#...#"},
+}
+
+// aliases maps letters that look identical to glyphs already defined above
+var aliases = map[rune]rune{
+	'А': 'A', 'В': 'B', 'Е': 'E', 'К': 'K', 'М': 'M', 'Н': 'H',
+	'О': 'O', 'Р': 'P', 'С': 'C', 'Т': 'T', 'Х': 'X',
+}
+
+// fallbackGlyph is drawn for any rune the font does not cover
+var fallbackGlyph = [7]string{"#####", "#...#", "#...#", "#...#", "#...#", "#...#", "#####"}
+
+func glyphFor(r rune) [7]string {
+	if g, ok := glyphs[r]; ok {
+		return g
+	}
+	if a, ok := aliases[r]; ok {
+		return glyphs[a]
+	}
+	return fallbackGlyph
+}
@@ -0,0 +1,142 @@
+package preview
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"unicode"
+)
+
+// Size of generated images, matching the Open Graph recommended 1.91:1 ratio
+const (
+	Width  = 1200
+	Height = 630
+)
+
+const (
+	margin        = 80
+	titleScale    = 10
+	subtitleScale = 5
+	maxTitleLines = 3
+	maxSubLines   = 2
+)
+
+// Card describes the content and colors of a preview image
+type Card struct {
+	Title      string
+	Subtitle   string
+	Background color.RGBA
+	Foreground color.RGBA
+	Hint       color.RGBA
+	Accent     color.RGBA
+}
+
+// Render draws the card into a new image
+func Render(card Card) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, Width, Height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{card.Background}, image.Point{}, draw.Src)
+
+	// Accent stripe along the left edge
+	draw.Draw(img, image.Rect(0, 0, margin/4, Height), &image.Uniform{card.Accent}, image.Point{}, draw.Src)
+
+	y := margin
+	for _, line := range wrap(card.Title, charsPerLine(titleScale), maxTitleLines) {
+		drawText(img, margin, y, titleScale, line, card.Foreground)
+		y += lineHeight(titleScale)
+	}
+
+	y += lineHeight(subtitleScale) / 2
+	for _, line := range wrap(card.Subtitle, charsPerLine(subtitleScale), maxSubLines) {
+		drawText(img, margin, y, subtitleScale, line, card.Hint)
+		y += lineHeight(subtitleScale)
+	}
+
+	return img
+}
+
+// ParseHexColor parses #rgb and #rrggbb colors
+func ParseHexColor(s string) (color.RGBA, error) {
+	c := color.RGBA{A: 0xff}
+	var err error
+	switch len(s) {
+	case 7:
+		_, err = fmt.Sscanf(s, "#%02x%02x%02x", &c.R, &c.G, &c.B)
+	case 4:
+		_, err = fmt.Sscanf(s, "#%1x%1x%1x", &c.R, &c.G, &c.B)
+		c.R *= 17
+		c.G *= 17
+		c.B *= 17
+	default:
+		err = fmt.Errorf("invalid color %q", s)
+	}
+	return c, err
+}
+
+func advance(scale int) int    { return 6 * scale }
+func lineHeight(scale int) int { return 10 * scale }
+
+func charsPerLine(scale int) int {
+	return (Width - 2*margin) / advance(scale)
+}
+
+func drawText(img *image.RGBA, x, y, scale int, text string, c color.RGBA) {
+	src := &image.Uniform{c}
+	for _, r := range text {
+		g := glyphFor(unicode.ToUpper(r))
+		for row, bits := range g {
+			for col, bit := range bits {
+				if bit != '#' {
+					continue
+				}
+				px := x + col*scale
+				py := y + row*scale
+				draw.Draw(img, image.Rect(px, py, px+scale, py+scale), src, image.Point{}, draw.Src)
+			}
+		}
+		x += advance(scale)
+	}
+}
+
+// wrap splits text into at most maxLines lines of width runes, breaking on
+// spaces where possible and ending with an ellipsis when truncated
+func wrap(text string, width, maxLines int) []string {
+	words := strings.Fields(text)
+	lines := make([]string, 0, maxLines)
+	current := make([]rune, 0, width)
+
+	flush := func() {
+		if len(current) > 0 {
+			lines = append(lines, string(current))
+			current = current[:0]
+		}
+	}
+
+	for _, word := range words {
+		w := []rune(word)
+		for len(w) > width {
+			flush()
+			lines = append(lines, string(w[:width]))
+			w = w[width:]
+		}
+		if len(current) > 0 && len(current)+1+len(w) > width {
+			flush()
+		}
+		if len(current) > 0 {
+			current = append(current, ' ')
+		}
+		current = append(current, w...)
+	}
+	flush()
+
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		last := []rune(lines[maxLines-1])
+		if len(last) >= width {
+			last = last[:width-1]
+		}
+		lines[maxLines-1] = string(last) + "…"
+	}
+	return lines
+}
@@ -0,0 +1,94 @@
+package referral
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"tma/models"
+)
+
+const (
+	codeLength = 8
+	// codeAlphabet leaves out characters that are easily confused
+	codeAlphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+	// codeAttempts bounds retries after a generated code collides
+	codeAttempts = 3
+)
+
+// Code returns the referral code of a user, creating it on first use
+func Code(ctx context.Context, db *sql.DB, userID int) (string, error) {
+	var code sql.NullString
+	if err := db.QueryRowContext(ctx, `SELECT referral_code FROM users WHERE id = $1`, userID).Scan(&code); err != nil {
+		return "", err
+	}
+	if code.Valid {
+		return code.String, nil
+	}
+
+	for attempt := 0; attempt < codeAttempts; attempt++ {
+		candidate, err := NewCode()
+		if err != nil {
+			return "", err
+		}
+		// A concurrent request may have set a code first; its code wins
+		err = db.QueryRowContext(ctx, `
+			UPDATE users SET referral_code = COALESCE(referral_code, $1) WHERE id = $2
+			RETURNING referral_code
+		`, candidate, userID).Scan(&code)
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return code.String, nil
+	}
+	return "", fmt.Errorf("no unique referral code after %d attempts", codeAttempts)
+}
+
+// Stats counts the signups referred by a user
+func Stats(ctx context.Context, db *sql.DB, userID int) (*models.ReferralStats, error) {
+	code, err := Code(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT COALESCE(signup_campaign, ''), COUNT(*), MAX(created_at)
+		FROM users
+		WHERE referred_by = $1
+		GROUP BY 1
+		ORDER BY 2 DESC, 1
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := &models.ReferralStats{ReferralCode: code, Campaigns: make([]models.CampaignStats, 0)}
+	for rows.Next() {
+		var cs models.CampaignStats
+		if err := rows.Scan(&cs.Campaign, &cs.Signups, &cs.LastSignupAt); err != nil {
+			return nil, err
+		}
+		stats.Signups += cs.Signups
+		stats.Campaigns = append(stats.Campaigns, cs)
+	}
+	return stats, rows.Err()
+}
+
+// NewCode returns a random referral code
+func NewCode() (string, error) {
+	buf := make([]byte, codeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = codeAlphabet[int(b)%len(codeAlphabet)]
+	}
+	return string(buf), nil
+}
@@ -0,0 +1,125 @@
+// Package referral attributes signups to the users and campaigns that
+// brought them in. Links carry a signed start_param, which Telegram hands
+// to the Mini App in init_data when it is opened through them.
+package referral
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	"tma/models"
+)
+
+// start_param is limited to 64 characters out of A-Z, a-z, 0-9, _ and -.
+// A signed value is a list of fields separated by "_", each a one-letter
+// key followed by its value, and ends with "_" and the signature:
+//
+//	p12_rk3x9za2b_cspring_Xv3dY0aQ-1E
+//
+// references page 12, referral code k3x9za2b and campaign "spring".
+const (
+	maxStartParamLength = 64
+	fieldSeparator      = "_"
+	// sigLength is the length of 8 bytes of HMAC in unpadded base64url
+	sigLength = 11
+
+	keyPage     = 'p'
+	keyReferral = 'r'
+	keyCampaign = 'c'
+)
+
+var (
+	ErrUnsigned     = errors.New("start_param is not signed")
+	ErrBadSignature = errors.New("start_param signature does not match")
+	ErrInvalidValue = errors.New("start_param values may only contain letters and digits")
+	ErrTooLong      = errors.New("start_param would exceed 64 characters")
+)
+
+// Signer encodes and verifies signed start_param values
+type Signer struct {
+	secret []byte
+}
+
+func NewSigner(secret string) *Signer {
+	return &Signer{secret: []byte(secret)}
+}
+
+// Encode builds a signed start_param. Referral codes and campaigns may only
+// contain letters and digits.
+func (s *Signer) Encode(sp models.StartParam) (string, error) {
+	var fields []string
+	if sp.PageID > 0 {
+		fields = append(fields, string(keyPage)+strconv.Itoa(sp.PageID))
+	}
+	for _, f := range []struct {
+		key   byte
+		value string
+	}{{keyReferral, sp.Referral}, {keyCampaign, sp.Campaign}} {
+		if f.value == "" {
+			continue
+		}
+		if !alphanumeric(f.value) {
+			return "", ErrInvalidValue
+		}
+		fields = append(fields, string(f.key)+f.value)
+	}
+
+	payload := strings.Join(fields, fieldSeparator)
+	value := payload + fieldSeparator + s.sign(payload)
+	if len(value) > maxStartParamLength {
+		return "", ErrTooLong
+	}
+	return value, nil
+}
+
+// Decode verifies a signed start_param and returns its fields. Unknown
+// field keys are skipped, so newer links still decode.
+func (s *Signer) Decode(value string) (models.StartParam, error) {
+	var sp models.StartParam
+	if len(value) < sigLength+1 || value[len(value)-sigLength-1:len(value)-sigLength] != fieldSeparator {
+		return sp, ErrUnsigned
+	}
+	payload, sig := value[:len(value)-sigLength-1], value[len(value)-sigLength:]
+	if !hmac.Equal([]byte(sig), []byte(s.sign(payload))) {
+		return sp, ErrBadSignature
+	}
+
+	if payload == "" {
+		return sp, nil
+	}
+	for _, field := range strings.Split(payload, fieldSeparator) {
+		if field == "" {
+			continue
+		}
+		switch field[0] {
+		case keyPage:
+			if id, err := strconv.Atoi(field[1:]); err == nil && id > 0 {
+				sp.PageID = id
+			}
+		case keyReferral:
+			sp.Referral = field[1:]
+		case keyCampaign:
+			sp.Campaign = field[1:]
+		}
+	}
+	return sp, nil
+}
+
+func (s *Signer) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte("start_param:" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:8])
+}
+
+func alphanumeric(s string) bool {
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package render
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/url"
+	"strings"
+)
+
+// Block types understood by the renderer. Anything else is rendered with
+// the fallback template so that newer clients never break older servers.
+const (
+	BlockHeading = "heading"
+	BlockText    = "text"
+	BlockImage   = "image"
+	BlockButton  = "button"
+	BlockLink    = "link"
+)
+
+// Document is the block model stored in pages.json_data
+type Document struct {
+	Theme  string  `json:"theme"`
+	Blocks []Block `json:"blocks"`
+}
+
+// Block is a single content element of a page
+type Block struct {
+	Type  string `json:"type"`
+	Level int    `json:"level,omitempty"`
+	Text  string `json:"text,omitempty"`
+	Src   string `json:"src,omitempty"`
+	Alt   string `json:"alt,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+// ParseDocument decodes json_data into a Document. Empty or null data
+// yields an empty document; malformed data is an error.
+func ParseDocument(data []byte) (*Document, error) {
+	doc := &Document{}
+	trimmed := strings.TrimSpace(string(data))
+	if trimmed == "" || trimmed == "null" {
+		return doc, nil
+	}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// blockView is a block as rendered, its URLs checked by safeURL
+type blockView struct {
+	Block
+	Src template.URL
+	URL template.URL
+}
+
+// normalize clamps values coming from user content to something safe to render
+func (b Block) normalize() blockView {
+	if b.Type == BlockHeading {
+		if b.Level < 1 {
+			b.Level = 1
+		}
+		if b.Level > 3 {
+			b.Level = 3
+		}
+	}
+	return blockView{Block: b, Src: safeURL(b.Src), URL: safeURL(b.URL)}
+}
+
+// safeURL drops any URL whose scheme is not explicitly allowed, which
+// rejects javascript:, data: and other schemes the WebView could act on.
+// The result is marked safe because html/template only trusts http, https
+// and mailto on its own and would replace tg: links.
+func safeURL(raw string) template.URL {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "tg":
+		return template.URL(u.String())
+	default:
+		return ""
+	}
+}
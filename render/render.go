@@ -0,0 +1,152 @@
+package render
+
+import (
+	"embed"
+	"html/template"
+	"io"
+	"strings"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var pageTemplate = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+// Theme is a set of CSS custom properties applied to the rendered page
+type Theme struct {
+	Name       string
+	Background string
+	Text       string
+	Hint       string
+	Link       string
+	Button     string
+	ButtonText string
+}
+
+// DefaultTheme is used when neither the request nor the page picks a known theme
+const DefaultTheme = "light"
+
+var themes = map[string]Theme{
+	"light": {
+		Name:       "light",
+		Background: "#ffffff",
+		Text:       "#000000",
+		Hint:       "#707579",
+		Link:       "#3390ec",
+		Button:     "#3390ec",
+		ButtonText: "#ffffff",
+	},
+	"dark": {
+		Name:       "dark",
+		Background: "#212121",
+		Text:       "#ffffff",
+		Hint:       "#aaaaaa",
+		Link:       "#8774e1",
+		Button:     "#8774e1",
+		ButtonText: "#ffffff",
+	},
+	"sepia": {
+		Name:       "sepia",
+		Background: "#f4ecd8",
+		Text:       "#433422",
+		Hint:       "#7a6a53",
+		Link:       "#9c5b1c",
+		Button:     "#9c5b1c",
+		ButtonText: "#ffffff",
+	},
+}
+
+// LookupTheme returns the named theme or the default one
+func LookupTheme(name string) Theme {
+	if theme, ok := themes[name]; ok {
+		return theme
+	}
+	return themes[DefaultTheme]
+}
+
+// maxDescriptionLength limits descriptions derived from page content
+const maxDescriptionLength = 200
+
+// PageData is the subset of a page the renderer needs
+type PageData struct {
+	Title       string
+	Description string
+	JSONData    []byte
+}
+
+// Options controls how a page is rendered
+type Options struct {
+	// Theme overrides the theme stored in the document when non-empty
+	Theme string
+	// URL is the canonical absolute URL of the rendered page
+	URL string
+	// ImageURL is the absolute URL of the link preview image
+	ImageURL string
+}
+
+// Meta holds the values emitted as Open Graph and Twitter card tags
+type Meta struct {
+	Title       string
+	Description string
+	URL         template.URL
+	Image       template.URL
+}
+
+type pageView struct {
+	Title  string
+	Meta   Meta
+	Theme  Theme
+	Blocks []blockView
+}
+
+// Page renders a page as a standalone HTML document. All user content goes
+// through html/template, so it is escaped according to the context it ends
+// up in.
+func Page(w io.Writer, page PageData, opts Options) error {
+	doc, err := ParseDocument(page.JSONData)
+	if err != nil {
+		return err
+	}
+
+	themeName := doc.Theme
+	if opts.Theme != "" {
+		themeName = opts.Theme
+	}
+
+	view := pageView{
+		Title: page.Title,
+		Meta: Meta{
+			Title:       page.Title,
+			Description: Description(page.Description, doc),
+			URL:         safeURL(opts.URL),
+			Image:       safeURL(opts.ImageURL),
+		},
+		Theme:  LookupTheme(themeName),
+		Blocks: make([]blockView, 0, len(doc.Blocks)),
+	}
+	for _, block := range doc.Blocks {
+		view.Blocks = append(view.Blocks, block.normalize())
+	}
+
+	return pageTemplate.ExecuteTemplate(w, "page.html", view)
+}
+
+// Description returns the explicit description when set, otherwise the
+// first text block of the document, shortened for use in link previews
+func Description(description string, doc *Document) string {
+	description = strings.TrimSpace(description)
+	if description == "" {
+		for _, block := range doc.Blocks {
+			if block.Type == BlockText && strings.TrimSpace(block.Text) != "" {
+				description = strings.TrimSpace(block.Text)
+				break
+			}
+		}
+	}
+
+	description = strings.Join(strings.Fields(description), " ")
+	if runes := []rune(description); len(runes) > maxDescriptionLength {
+		description = strings.TrimSpace(string(runes[:maxDescriptionLength-1])) + "…"
+	}
+	return description
+}
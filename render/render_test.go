@@ -0,0 +1,43 @@
+package render_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"tma/render"
+)
+
+func TestBlockURLs(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com/a?b=1&c=2", `href="https://example.com/a?b=1&amp;c=2"`},
+		{"tg://resolve?domain=a", `href="tg://resolve?domain=a"`},
+		{"/p/1", `href="/p/1"`},
+		{"javascript:alert(1)", ""},
+		{"data:text/html,hi", ""},
+		{`https://example.com/"><script>`, `href="https://example.com/%22%3E%3Cscript%3E"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			data, _ := json.Marshal(render.Document{Blocks: []render.Block{{Type: render.BlockButton, Text: "Open", URL: tt.url}}})
+			var buf bytes.Buffer
+			if err := render.Page(&buf, render.PageData{Title: "T", JSONData: data}, render.Options{}); err != nil {
+				t.Fatalf("Page: %v", err)
+			}
+			html := buf.String()
+			if tt.want == "" {
+				if strings.Contains(html, `class="button"`) {
+					t.Errorf("rendered a button for %q", tt.url)
+				}
+				return
+			}
+			if !strings.Contains(html, `<a class="button" `+tt.want+`>`) {
+				t.Errorf("rendered %s, want %s", html[strings.Index(html, "<main>"):], tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"tma/models"
+)
+
+// maxTitleLength is the length of pages.title in characters
+const maxTitleLength = 255
+
+// Revisions are kept at most once per revisionInterval and at most
+// maxRevisions per page, the same as the page_revisions trigger
+const (
+	revisionInterval = 5 * time.Minute
+	maxRevisions     = 50
+)
+
+// pageImporter is what ImportPages needs from a backend, run inside one
+// transaction
+type pageImporter interface {
+	// pageByTitle returns the oldest page of the workspace with the title
+	pageByTitle(ctx context.Context, workspaceID int, title string) (*models.Page, error)
+	overwritePage(ctx context.Context, id int, page models.PageExport) error
+	createPage(ctx context.Context, userID, workspaceID int, page models.PageExport) (int, error)
+	createRevision(ctx context.Context, pageID int, rev models.RevisionExport) error
+	linkAsset(ctx context.Context, pageID, assetID int) error
+}
+
+// importPages resolves conflicts the same way for every backend
+func importPages(ctx context.Context, im pageImporter, userID, workspaceID int, pages []models.PageExport, opts ImportOptions) (*models.ImportReport, error) {
+	report := &models.ImportReport{
+		Created:     make([]models.ImportedPage, 0),
+		Overwritten: make([]models.ImportedPage, 0),
+		Skipped:     make([]models.SkippedPage, 0),
+	}
+
+	for _, p := range pages {
+		if strings.TrimSpace(p.Title) == "" {
+			report.Skipped = append(report.Skipped, models.SkippedPage{OldID: p.ID, Reason: "missing title"})
+			continue
+		}
+		p.Title = clipTitle(p.Title, maxTitleLength)
+		if p.CreatedAt.IsZero() {
+			p.CreatedAt = time.Now()
+		}
+
+		existing, err := im.pageByTitle(ctx, workspaceID, p.Title)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+
+		overwriteID := 0
+		if existing != nil {
+			if samePage(existing, p) {
+				report.Skipped = append(report.Skipped, models.SkippedPage{OldID: p.ID, Title: p.Title, Reason: "identical page exists"})
+				continue
+			}
+			switch opts.OnConflict {
+			case OnConflictOverwrite:
+				overwriteID = existing.ID
+			case OnConflictRename:
+				if p.Title, err = freeTitle(ctx, im, workspaceID, p.Title); err != nil {
+					return nil, err
+				}
+			default:
+				report.Skipped = append(report.Skipped, models.SkippedPage{OldID: p.ID, Title: p.Title, Reason: "title conflict"})
+				continue
+			}
+		}
+
+		oldID := p.ID
+		if opts.Rewrite != nil {
+			p = opts.Rewrite(p)
+		}
+
+		var id int
+		if overwriteID != 0 {
+			id = overwriteID
+			if err := im.overwritePage(ctx, id, p); err != nil {
+				return nil, err
+			}
+			report.Overwritten = append(report.Overwritten, models.ImportedPage{OldID: oldID, NewID: id, Title: p.Title})
+		} else {
+			if id, err = im.createPage(ctx, userID, workspaceID, p); err != nil {
+				return nil, err
+			}
+			// Oldest first, so that IDs follow the history
+			for i := len(p.Revisions) - 1; i >= 0; i-- {
+				if err := im.createRevision(ctx, id, p.Revisions[i]); err != nil {
+					return nil, err
+				}
+			}
+			report.Created = append(report.Created, models.ImportedPage{OldID: oldID, NewID: id, Title: p.Title})
+		}
+
+		for _, archiveID := range p.Assets {
+			if assetID, ok := opts.Assets[archiveID]; ok {
+				if err := im.linkAsset(ctx, id, assetID); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// freeTitle returns the first of "title (imported)", "title (imported 2)",
+// ... that no page of the workspace has, shortening title to fit
+func freeTitle(ctx context.Context, im pageImporter, workspaceID int, title string) (string, error) {
+	for n := 1; ; n++ {
+		suffix := " (imported)"
+		if n > 1 {
+			suffix = fmt.Sprintf(" (imported %d)", n)
+		}
+		candidate := clipTitle(title, maxTitleLength-utf8.RuneCountInString(suffix)) + suffix
+
+		_, err := im.pageByTitle(ctx, workspaceID, candidate)
+		if errors.Is(err, ErrNotFound) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// clipTitle shortens title to at most n characters
+func clipTitle(title string, n int) string {
+	if utf8.RuneCountInString(title) <= n {
+		return title
+	}
+	return string([]rune(title)[:n])
+}
+
+// samePage reports whether an archive page has the content of a page.
+// The price is not compared since import leaves it alone.
+func samePage(page *models.Page, p models.PageExport) bool {
+	return page.Title == p.Title &&
+		page.Description == p.Description &&
+		page.CoverImage == p.CoverImage &&
+		sameJSON(page.JSONData, p.JSONData)
+}
+
+// sameJSON compares documents the way jsonb does, ignoring formatting
+// and key order
+func sameJSON(a, b models.JSONData) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	var av, bv interface{}
+	if json.Unmarshal(a, &av) != nil || json.Unmarshal(b, &bv) != nil {
+		return bytes.Equal(a, b)
+	}
+	return reflect.DeepEqual(av, bv)
+}
@@ -0,0 +1,639 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"tma/models"
+	"tma/referral"
+)
+
+// Memory implements the repositories in process memory. It behaves like
+// Postgres for everything the interfaces expose, but workspaces are not
+// checked to exist and asset variants only come from AddVariant.
+type Memory struct {
+	mu sync.Mutex
+
+	users     map[int]*memoryUser
+	pages     map[int]*models.Page
+	sessions  map[int]*models.Session
+	assets    map[int]*models.Asset
+	lastID    map[string]int
+	referrals map[string]int
+	// pageAssets holds the IDs of the assets linked to each page
+	pageAssets map[int]map[int]bool
+	// revisions holds the revisions of each page, oldest first
+	revisions map[int][]models.PageRevision
+}
+
+// memoryUser is a user with the columns models.User does not carry
+type memoryUser struct {
+	user           models.User
+	referralCode   string
+	referredBy     int
+	signupCampaign string
+}
+
+var (
+	_ UserRepository    = (*Memory)(nil)
+	_ PageRepository    = (*Memory)(nil)
+	_ SessionRepository = (*Memory)(nil)
+	_ AssetRepository   = (*Memory)(nil)
+)
+
+func NewMemory() *Memory {
+	return &Memory{
+		users:      make(map[int]*memoryUser),
+		pages:      make(map[int]*models.Page),
+		sessions:   make(map[int]*models.Session),
+		assets:     make(map[int]*models.Asset),
+		lastID:     make(map[string]int),
+		referrals:  make(map[string]int),
+		pageAssets: make(map[int]map[int]bool),
+		revisions:  make(map[int][]models.PageRevision),
+	}
+}
+
+func (m *Memory) UserByID(ctx context.Context, id int) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	user := u.user
+	return &user, nil
+}
+
+func (m *Memory) UserByTelegramID(ctx context.Context, telegramID int64) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.user.TelegramID == telegramID {
+			user := u.user
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) CreateUser(ctx context.Context, telegramUser *models.TelegramUser, signup Signup) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.user.TelegramID == telegramUser.ID {
+			return nil, ErrDuplicate
+		}
+	}
+
+	now := time.Now()
+	u := &memoryUser{
+		user: models.User{
+			ID:              m.nextID("users"),
+			TelegramID:      telegramUser.ID,
+			Username:        telegramUser.Username,
+			FirstName:       telegramUser.FirstName,
+			LastName:        telegramUser.LastName,
+			AllowsWriteToPM: telegramUser.AllowsWriteToPM,
+			CreatedAt:       now,
+			UpdatedAt:       now,
+		},
+		referredBy:     m.referrals[signup.ReferralCode],
+		signupCampaign: signup.Campaign,
+	}
+	m.users[u.user.ID] = u
+
+	user := u.user
+	return &user, nil
+}
+
+func (m *Memory) UpdateUser(ctx context.Context, id int, telegramUser *models.TelegramUser) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	u.user.Username = telegramUser.Username
+	u.user.FirstName = telegramUser.FirstName
+	u.user.LastName = telegramUser.LastName
+	u.user.AllowsWriteToPM = telegramUser.AllowsWriteToPM
+	u.user.UpdatedAt = time.Now()
+
+	user := u.user
+	return &user, nil
+}
+
+func (m *Memory) ReferralCode(ctx context.Context, id int) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return "", ErrNotFound
+	}
+	for u.referralCode == "" {
+		code, err := referral.NewCode()
+		if err != nil {
+			return "", err
+		}
+		if _, taken := m.referrals[code]; !taken {
+			u.referralCode = code
+			m.referrals[code] = id
+		}
+	}
+	return u.referralCode, nil
+}
+
+func (m *Memory) Page(ctx context.Context, id int) (*models.Page, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	page, ok := m.pages[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyPage(page), nil
+}
+
+func (m *Memory) PagesByWorkspace(ctx context.Context, workspaceID int) ([]models.Page, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pages := make([]models.Page, 0)
+	for _, page := range m.pages {
+		if page.WorkspaceID == workspaceID {
+			pages = append(pages, *copyPage(page))
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		if !pages[i].CreatedAt.Equal(pages[j].CreatedAt) {
+			return pages[i].CreatedAt.After(pages[j].CreatedAt)
+		}
+		return pages[i].ID > pages[j].ID
+	})
+	return pages, nil
+}
+
+func (m *Memory) CreatePage(ctx context.Context, userID, workspaceID int, req models.CreatePageRequest) (*models.Page, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	page := &models.Page{
+		ID:          m.nextID("pages"),
+		UserID:      userID,
+		WorkspaceID: workspaceID,
+		Title:       req.Title,
+		Description: req.Description,
+		CoverImage:  req.CoverImage,
+		JSONData:    copyJSON(req.JSONData),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	m.pages[page.ID] = page
+	return copyPage(page), nil
+}
+
+func (m *Memory) UpdatePage(ctx context.Context, id int, req models.UpdatePageRequest) (*models.Page, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	page, ok := m.pages[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	old := *page
+	if req.Title != "" {
+		page.Title = req.Title
+	}
+	if req.Description != "" {
+		page.Description = req.Description
+	}
+	if req.CoverImage != "" {
+		page.CoverImage = req.CoverImage
+	}
+	if req.JSONData != nil {
+		page.JSONData = copyJSON(req.JSONData)
+	}
+	page.UpdatedAt = time.Now()
+	m.recordRevision(&old, page)
+	return copyPage(page), nil
+}
+
+func (m *Memory) DeletePage(ctx context.Context, id int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.pages[id]; !ok {
+		return nil, ErrNotFound
+	}
+	delete(m.pages, id)
+
+	// Assets used only by this page go away with it
+	keys := make([]string, 0)
+	for assetID := range m.pageAssets[id] {
+		if m.linkedElsewhere(assetID, id) {
+			continue
+		}
+		keys = append(keys, m.deleteAsset(assetID)...)
+	}
+	delete(m.pageAssets, id)
+	delete(m.revisions, id)
+	return keys, nil
+}
+
+func (m *Memory) MovePage(ctx context.Context, id, workspaceID int) (*models.Page, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	page, ok := m.pages[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	page.WorkspaceID = workspaceID
+	return copyPage(page), nil
+}
+
+func (m *Memory) SetPagePrice(ctx context.Context, id, priceStars int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	page, ok := m.pages[id]
+	if !ok {
+		return ErrNotFound
+	}
+	page.PriceStars = priceStars
+	return nil
+}
+
+func (m *Memory) PageRevisions(ctx context.Context, pageID int) ([]models.PageRevision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := m.revisions[pageID]
+	revisions := make([]models.PageRevision, 0, len(stored))
+	for i := len(stored) - 1; i >= 0; i-- {
+		r := stored[i]
+		r.JSONData = copyJSON(r.JSONData)
+		revisions = append(revisions, r)
+	}
+	return revisions, nil
+}
+
+// recordRevision keeps the content a page had before an edit, like the
+// page_revisions trigger. m.mu must be held.
+func (m *Memory) recordRevision(old, page *models.Page) {
+	if old.Title == page.Title && old.Description == page.Description &&
+		old.CoverImage == page.CoverImage && sameJSON(old.JSONData, page.JSONData) {
+		return
+	}
+	revisions := m.revisions[old.ID]
+	for _, r := range revisions {
+		if r.CreatedAt.After(time.Now().Add(-revisionInterval)) {
+			return
+		}
+	}
+	m.addRevision(old.ID, models.RevisionExport{
+		Title:       old.Title,
+		Description: old.Description,
+		CoverImage:  old.CoverImage,
+		JSONData:    old.JSONData,
+		CreatedAt:   old.UpdatedAt,
+	})
+}
+
+// addRevision stores a revision, dropping the oldest beyond maxRevisions.
+// m.mu must be held.
+func (m *Memory) addRevision(pageID int, rev models.RevisionExport) {
+	revisions := append(m.revisions[pageID], models.PageRevision{
+		ID:          int64(m.nextID("page_revisions")),
+		PageID:      pageID,
+		Title:       clipTitle(rev.Title, maxTitleLength),
+		Description: rev.Description,
+		CoverImage:  rev.CoverImage,
+		JSONData:    copyJSON(rev.JSONData),
+		CreatedAt:   rev.CreatedAt,
+	})
+	sort.SliceStable(revisions, func(i, j int) bool { return revisions[i].CreatedAt.Before(revisions[j].CreatedAt) })
+	if len(revisions) > maxRevisions {
+		revisions = revisions[len(revisions)-maxRevisions:]
+	}
+	m.revisions[pageID] = revisions
+}
+
+func (m *Memory) ImportPages(ctx context.Context, userID, workspaceID int, pages []models.PageExport, opts ImportOptions) (*models.ImportReport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return importPages(ctx, memoryImporter{m}, userID, workspaceID, pages, opts)
+}
+
+// memoryImporter is the pageImporter of Memory; m.mu is held throughout
+type memoryImporter struct {
+	m *Memory
+}
+
+func (im memoryImporter) pageByTitle(ctx context.Context, workspaceID int, title string) (*models.Page, error) {
+	var found *models.Page
+	for _, page := range im.m.pages {
+		if page.WorkspaceID == workspaceID && page.Title == title && (found == nil || page.ID < found.ID) {
+			found = page
+		}
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return copyPage(found), nil
+}
+
+func (im memoryImporter) overwritePage(ctx context.Context, id int, p models.PageExport) error {
+	page, ok := im.m.pages[id]
+	if !ok {
+		return ErrNotFound
+	}
+	old := *page
+	page.Description = p.Description
+	page.CoverImage = p.CoverImage
+	page.JSONData = copyJSON(p.JSONData)
+	page.UpdatedAt = time.Now()
+	im.m.recordRevision(&old, page)
+	return nil
+}
+
+func (im memoryImporter) createPage(ctx context.Context, userID, workspaceID int, p models.PageExport) (int, error) {
+	page := &models.Page{
+		ID:          im.m.nextID("pages"),
+		UserID:      userID,
+		WorkspaceID: workspaceID,
+		Title:       p.Title,
+		Description: p.Description,
+		CoverImage:  p.CoverImage,
+		JSONData:    copyJSON(p.JSONData),
+		CreatedAt:   p.CreatedAt,
+		UpdatedAt:   time.Now(),
+	}
+	im.m.pages[page.ID] = page
+	return page.ID, nil
+}
+
+func (im memoryImporter) createRevision(ctx context.Context, pageID int, rev models.RevisionExport) error {
+	im.m.addRevision(pageID, rev)
+	return nil
+}
+
+func (im memoryImporter) linkAsset(ctx context.Context, pageID, assetID int) error {
+	if _, ok := im.m.assets[assetID]; !ok {
+		return ErrNotFound
+	}
+	if im.m.pageAssets[pageID] == nil {
+		im.m.pageAssets[pageID] = make(map[int]bool)
+	}
+	im.m.pageAssets[pageID][assetID] = true
+	return nil
+}
+
+func (m *Memory) Asset(ctx context.Context, id int) (*models.Asset, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	asset, ok := m.assets[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyAsset(asset), nil
+}
+
+func (m *Memory) AssetsByUser(ctx context.Context, userID int) ([]models.Asset, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	assets := make([]models.Asset, 0)
+	for _, asset := range m.assets {
+		if asset.UserID == userID {
+			assets = append(assets, *copyAsset(asset))
+		}
+	}
+	sort.Slice(assets, func(i, j int) bool {
+		if !assets[i].CreatedAt.Equal(assets[j].CreatedAt) {
+			return assets[i].CreatedAt.After(assets[j].CreatedAt)
+		}
+		return assets[i].ID > assets[j].ID
+	})
+	return assets, nil
+}
+
+func (m *Memory) PageAssets(ctx context.Context, pageID int) ([]models.Asset, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	assets := make([]models.Asset, 0, len(m.pageAssets[pageID]))
+	for id := range m.pageAssets[pageID] {
+		assets = append(assets, *copyAsset(m.assets[id]))
+	}
+	sort.Slice(assets, func(i, j int) bool { return assets[i].ID < assets[j].ID })
+	return assets, nil
+}
+
+func (m *Memory) Variant(ctx context.Context, assetID, width int) (*models.AssetVariant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	asset, ok := m.assets[assetID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	// Variants are kept ordered by width
+	for _, v := range asset.Variants {
+		if v.Width >= width {
+			variant := v
+			return &variant, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// AddVariant records a resized variant of an asset, as the image
+// processor does for Postgres
+func (m *Memory) AddVariant(assetID int, variant models.AssetVariant) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	asset, ok := m.assets[assetID]
+	if !ok {
+		return ErrNotFound
+	}
+	asset.Variants = append(asset.Variants, variant)
+	sort.Slice(asset.Variants, func(i, j int) bool { return asset.Variants[i].Width < asset.Variants[j].Width })
+	return nil
+}
+
+func (m *Memory) CreateAsset(ctx context.Context, asset NewAsset, quota int64) (*models.Asset, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var used int64
+	for _, a := range m.assets {
+		if a.UserID == asset.UserID {
+			used += a.Size
+			for _, v := range a.Variants {
+				used += v.Size
+			}
+		}
+	}
+	if used+asset.Size > quota {
+		return nil, &QuotaError{Used: used}
+	}
+	if asset.PageID != 0 {
+		if _, ok := m.pages[asset.PageID]; !ok {
+			return nil, ErrNotFound
+		}
+	}
+
+	created := &models.Asset{
+		ID:          m.nextID("assets"),
+		UserID:      asset.UserID,
+		StorageKey:  asset.StorageKey,
+		Filename:    asset.Filename,
+		ContentType: asset.ContentType,
+		Size:        asset.Size,
+		Status:      "pending",
+		Variants:    make([]models.AssetVariant, 0),
+		CreatedAt:   time.Now(),
+	}
+	m.assets[created.ID] = created
+	if asset.PageID != 0 {
+		if m.pageAssets[asset.PageID] == nil {
+			m.pageAssets[asset.PageID] = make(map[int]bool)
+		}
+		m.pageAssets[asset.PageID][created.ID] = true
+	}
+	return copyAsset(created), nil
+}
+
+func (m *Memory) DeleteAsset(ctx context.Context, id, userID int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	asset, ok := m.assets[id]
+	if !ok || asset.UserID != userID {
+		return nil, ErrNotFound
+	}
+	for _, linked := range m.pageAssets {
+		delete(linked, id)
+	}
+	return m.deleteAsset(id), nil
+}
+
+// deleteAsset removes an asset and returns its storage keys, variants
+// first. m.mu must be held.
+func (m *Memory) deleteAsset(id int) []string {
+	asset := m.assets[id]
+	delete(m.assets, id)
+
+	keys := make([]string, 0, len(asset.Variants)+1)
+	for _, v := range asset.Variants {
+		keys = append(keys, v.StorageKey)
+	}
+	return append(keys, asset.StorageKey)
+}
+
+// linkedElsewhere reports whether an asset is linked to a page other than
+// pageID. m.mu must be held.
+func (m *Memory) linkedElsewhere(assetID, pageID int) bool {
+	for otherID, linked := range m.pageAssets {
+		if otherID != pageID && linked[assetID] {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Memory) CreateSession(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) (*models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session := &models.Session{
+		ID:        m.nextID("sessions"),
+		UserID:    userID,
+		TokenHash: tokenHash,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+	m.sessions[session.ID] = session
+
+	copied := *session
+	return &copied, nil
+}
+
+func (m *Memory) SessionByTokenHash(ctx context.Context, tokenHash string) (*models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, session := range m.sessions {
+		if session.TokenHash == tokenHash && session.ExpiresAt.After(now) {
+			copied := *session
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) DeleteSession(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.sessions[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *Memory) DeleteExpiredSessions(ctx context.Context, userID int) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted int64
+	now := time.Now()
+	for id, session := range m.sessions {
+		if session.UserID == userID && !session.ExpiresAt.After(now) {
+			delete(m.sessions, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// nextID returns the next value of a per-table sequence. m.mu must be held.
+func (m *Memory) nextID(table string) int {
+	m.lastID[table]++
+	return m.lastID[table]
+}
+
+// copyPage keeps callers from modifying stored pages through JSONData
+func copyPage(page *models.Page) *models.Page {
+	copied := *page
+	copied.JSONData = copyJSON(page.JSONData)
+	return &copied
+}
+
+func copyAsset(asset *models.Asset) *models.Asset {
+	copied := *asset
+	copied.Variants = append([]models.AssetVariant{}, asset.Variants...)
+	return &copied
+}
+
+func copyJSON(data models.JSONData) models.JSONData {
+	if data == nil {
+		return nil
+	}
+	return append(models.JSONData(nil), data...)
+}
@@ -0,0 +1,15 @@
+package repository_test
+
+import (
+	"testing"
+
+	"tma/repository"
+	"tma/repository/repotest"
+)
+
+func TestMemory(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Subject {
+		m := repository.NewMemory()
+		return repotest.Subject{Users: m, Pages: m, Sessions: m, Assets: m, Workspace: repotest.AnyWorkspace, AddVariant: repotest.MemoryVariant(m)}
+	})
+}
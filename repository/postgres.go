@@ -0,0 +1,616 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+
+	"tma/models"
+	"tma/referral"
+)
+
+// userColumns is the column list every user query selects, in scanUser order
+const userColumns = "id, telegram_id, username, first_name, last_name, allows_write_to_pm, created_at, updated_at"
+
+// pageColumns is the column list every page query selects, in scanPage order
+const pageColumns = "id, user_id, workspace_id, title, description, cover_image, json_data, price_stars, created_at, updated_at"
+
+// assetColumns is the column list every asset query selects, in scanAsset order
+const assetColumns = "id, user_id, storage_key, filename, content_type, size, width, height, blurhash, dominant_color, status, created_at"
+
+// pageOnlyAssets selects the assets linked to page $1 and to no other page
+const pageOnlyAssets = `
+	SELECT pa.asset_id FROM page_assets pa
+	WHERE pa.page_id = $1
+	AND NOT EXISTS (
+		SELECT 1 FROM page_assets o WHERE o.asset_id = pa.asset_id AND o.page_id <> $1
+	)`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Postgres implements the repositories on top of the application database
+type Postgres struct {
+	db *sql.DB
+}
+
+var (
+	_ UserRepository    = (*Postgres)(nil)
+	_ PageRepository    = (*Postgres)(nil)
+	_ SessionRepository = (*Postgres)(nil)
+	_ AssetRepository   = (*Postgres)(nil)
+)
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+func (p *Postgres) UserByID(ctx context.Context, id int) (*models.User, error) {
+	return scanUser(p.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, id))
+}
+
+func (p *Postgres) UserByTelegramID(ctx context.Context, telegramID int64) (*models.User, error) {
+	return scanUser(p.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE telegram_id = $1`, telegramID))
+}
+
+func (p *Postgres) CreateUser(ctx context.Context, telegramUser *models.TelegramUser, signup Signup) (*models.User, error) {
+	user, err := scanUser(p.db.QueryRowContext(ctx, `
+		INSERT INTO users (telegram_id, username, first_name, last_name, allows_write_to_pm, referred_by, signup_campaign)
+		VALUES ($1, $2, $3, $4, $5, (SELECT id FROM users WHERE referral_code = NULLIF($6, '')), NULLIF($7, ''))
+		RETURNING `+userColumns,
+		telegramUser.ID, telegramUser.Username, telegramUser.FirstName, telegramUser.LastName, telegramUser.AllowsWriteToPM,
+		signup.ReferralCode, signup.Campaign,
+	))
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return nil, ErrDuplicate
+	}
+	return user, err
+}
+
+func (p *Postgres) UpdateUser(ctx context.Context, id int, telegramUser *models.TelegramUser) (*models.User, error) {
+	return scanUser(p.db.QueryRowContext(ctx, `
+		UPDATE users
+		SET username = $1, first_name = $2, last_name = $3, allows_write_to_pm = $4, updated_at = $5
+		WHERE id = $6
+		RETURNING `+userColumns,
+		telegramUser.Username, telegramUser.FirstName, telegramUser.LastName, telegramUser.AllowsWriteToPM, time.Now(), id,
+	))
+}
+
+func (p *Postgres) ReferralCode(ctx context.Context, id int) (string, error) {
+	code, err := referral.Code(ctx, p.db, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return code, err
+}
+
+func (p *Postgres) Page(ctx context.Context, id int) (*models.Page, error) {
+	return scanPage(p.db.QueryRowContext(ctx, `SELECT `+pageColumns+` FROM pages WHERE id = $1`, id))
+}
+
+func (p *Postgres) PagesByWorkspace(ctx context.Context, workspaceID int) ([]models.Page, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT `+pageColumns+`
+		FROM pages
+		WHERE workspace_id = $1
+		ORDER BY created_at DESC, id DESC
+	`, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pages := make([]models.Page, 0)
+	for rows.Next() {
+		page, err := scanPage(rows)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, *page)
+	}
+	return pages, rows.Err()
+}
+
+func (p *Postgres) CreatePage(ctx context.Context, userID, workspaceID int, req models.CreatePageRequest) (*models.Page, error) {
+	return scanPage(p.db.QueryRowContext(ctx, `
+		INSERT INTO pages (user_id, workspace_id, title, description, cover_image, json_data)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+pageColumns,
+		userID, workspaceID, req.Title, req.Description, req.CoverImage, req.JSONData,
+	))
+}
+
+func (p *Postgres) UpdatePage(ctx context.Context, id int, req models.UpdatePageRequest) (*models.Page, error) {
+	// Build dynamic query based on provided fields
+	query := `
+		UPDATE pages
+		SET updated_at = $1
+	`
+	args := []interface{}{time.Now()}
+	argIndex := 2
+
+	if req.Title != "" {
+		query += `, title = $` + strconv.Itoa(argIndex)
+		args = append(args, req.Title)
+		argIndex++
+	}
+
+	if req.Description != "" {
+		query += `, description = $` + strconv.Itoa(argIndex)
+		args = append(args, req.Description)
+		argIndex++
+	}
+
+	if req.CoverImage != "" {
+		query += `, cover_image = $` + strconv.Itoa(argIndex)
+		args = append(args, req.CoverImage)
+		argIndex++
+	}
+
+	if req.JSONData != nil {
+		query += `, json_data = $` + strconv.Itoa(argIndex)
+		args = append(args, req.JSONData)
+		argIndex++
+	}
+
+	query += ` WHERE id = $` + strconv.Itoa(argIndex)
+	args = append(args, id)
+
+	query += ` RETURNING ` + pageColumns
+
+	return scanPage(p.db.QueryRowContext(ctx, query, args...))
+}
+
+func (p *Postgres) DeletePage(ctx context.Context, id int) ([]string, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Assets used only by this page go away with it. This runs before the
+	// page row is deleted because that cascades to page_assets; if the page
+	// is gone by now the transaction is rolled back below.
+	variantKeys, err := collectKeys(tx.QueryContext(ctx, `
+		DELETE FROM asset_variants WHERE asset_id IN (`+pageOnlyAssets+`)
+		RETURNING storage_key
+	`, id))
+	if err != nil {
+		return nil, err
+	}
+	keys, err := collectKeys(tx.QueryContext(ctx, `
+		DELETE FROM assets WHERE id IN (`+pageOnlyAssets+`)
+		RETURNING storage_key
+	`, id))
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM pages WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return append(keys, variantKeys...), nil
+}
+
+func (p *Postgres) MovePage(ctx context.Context, id, workspaceID int) (*models.Page, error) {
+	return scanPage(p.db.QueryRowContext(ctx, `
+		UPDATE pages SET workspace_id = $1 WHERE id = $2
+		RETURNING `+pageColumns, workspaceID, id))
+}
+
+func (p *Postgres) SetPagePrice(ctx context.Context, id, priceStars int) error {
+	result, err := p.db.ExecContext(ctx, `UPDATE pages SET price_stars = $1 WHERE id = $2`, priceStars, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) PageRevisions(ctx context.Context, pageID int) ([]models.PageRevision, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT id, page_id, title, description, cover_image, json_data, created_at
+		FROM page_revisions
+		WHERE page_id = $1
+		ORDER BY created_at DESC, id DESC
+	`, pageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := make([]models.PageRevision, 0)
+	for rows.Next() {
+		var r models.PageRevision
+		if err := rows.Scan(&r.ID, &r.PageID, &r.Title, &r.Description, &r.CoverImage, &r.JSONData, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+
+func (p *Postgres) ImportPages(ctx context.Context, userID, workspaceID int, pages []models.PageExport, opts ImportOptions) (*models.ImportReport, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	report, err := importPages(ctx, postgresImporter{tx: tx}, userID, workspaceID, pages, opts)
+	if err != nil {
+		return nil, err
+	}
+	return report, tx.Commit()
+}
+
+// postgresImporter is the pageImporter of a transaction
+type postgresImporter struct {
+	tx *sql.Tx
+}
+
+func (im postgresImporter) pageByTitle(ctx context.Context, workspaceID int, title string) (*models.Page, error) {
+	return scanPage(im.tx.QueryRowContext(ctx, `
+		SELECT `+pageColumns+`
+		FROM pages
+		WHERE workspace_id = $1 AND title = $2
+		ORDER BY id
+		LIMIT 1
+	`, workspaceID, title))
+}
+
+func (im postgresImporter) overwritePage(ctx context.Context, id int, page models.PageExport) error {
+	_, err := im.tx.ExecContext(ctx, `
+		UPDATE pages SET description = $1, cover_image = $2, json_data = $3, updated_at = $4
+		WHERE id = $5
+	`, page.Description, page.CoverImage, page.JSONData, time.Now(), id)
+	return err
+}
+
+func (im postgresImporter) createPage(ctx context.Context, userID, workspaceID int, page models.PageExport) (int, error) {
+	var id int
+	err := im.tx.QueryRowContext(ctx, `
+		INSERT INTO pages (user_id, workspace_id, title, description, cover_image, json_data, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, userID, workspaceID, page.Title, page.Description, page.CoverImage, page.JSONData, page.CreatedAt).Scan(&id)
+	return id, err
+}
+
+func (im postgresImporter) createRevision(ctx context.Context, pageID int, rev models.RevisionExport) error {
+	_, err := im.tx.ExecContext(ctx, `
+		INSERT INTO page_revisions (page_id, title, description, cover_image, json_data, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, pageID, clipTitle(rev.Title, maxTitleLength), rev.Description, rev.CoverImage, rev.JSONData, rev.CreatedAt)
+	return err
+}
+
+func (im postgresImporter) linkAsset(ctx context.Context, pageID, assetID int) error {
+	_, err := im.tx.ExecContext(ctx, `
+		INSERT INTO page_assets (page_id, asset_id) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, pageID, assetID)
+	return err
+}
+
+func (p *Postgres) Asset(ctx context.Context, id int) (*models.Asset, error) {
+	asset, err := scanAsset(p.db.QueryRowContext(ctx, `SELECT `+assetColumns+` FROM assets WHERE id = $1`, id))
+	if err != nil {
+		return nil, err
+	}
+	assets := []models.Asset{*asset}
+	if err := p.loadVariants(ctx, assets); err != nil {
+		return nil, err
+	}
+	return &assets[0], nil
+}
+
+func (p *Postgres) AssetsByUser(ctx context.Context, userID int) ([]models.Asset, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT `+assetColumns+` FROM assets WHERE user_id = $1 ORDER BY created_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assets := make([]models.Asset, 0)
+	for rows.Next() {
+		asset, err := scanAsset(rows)
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, *asset)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return assets, p.loadVariants(ctx, assets)
+}
+
+func (p *Postgres) PageAssets(ctx context.Context, pageID int) ([]models.Asset, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT `+assetColumns+`
+		FROM assets
+		WHERE id IN (SELECT asset_id FROM page_assets WHERE page_id = $1)
+		ORDER BY id
+	`, pageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assets := make([]models.Asset, 0)
+	for rows.Next() {
+		asset, err := scanAsset(rows)
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, *asset)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return assets, p.loadVariants(ctx, assets)
+}
+
+func (p *Postgres) Variant(ctx context.Context, assetID, width int) (*models.AssetVariant, error) {
+	v := &models.AssetVariant{}
+	err := p.db.QueryRowContext(ctx, `
+		SELECT width, height, storage_key, content_type, size
+		FROM asset_variants
+		WHERE asset_id = $1 AND width >= $2
+		ORDER BY width
+		LIMIT 1
+	`, assetID, width).Scan(&v.Width, &v.Height, &v.StorageKey, &v.ContentType, &v.Size)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return v, nil
+}
+
+func (p *Postgres) CreateAsset(ctx context.Context, asset NewAsset, quota int64) (*models.Asset, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the user row so concurrent uploads cannot both pass the quota check
+	if _, err := tx.ExecContext(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, asset.UserID); err != nil {
+		return nil, err
+	}
+
+	// Resized variants count against the quota as well
+	var used int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(a.size), 0) + COALESCE((
+			SELECT SUM(v.size) FROM asset_variants v JOIN assets va ON va.id = v.asset_id WHERE va.user_id = $1
+		), 0)
+		FROM assets a
+		WHERE a.user_id = $1
+	`, asset.UserID).Scan(&used)
+	if err != nil {
+		return nil, err
+	}
+	if used+asset.Size > quota {
+		return nil, &QuotaError{Used: used}
+	}
+
+	created, err := scanAsset(tx.QueryRowContext(ctx, `
+		INSERT INTO assets (user_id, storage_key, filename, content_type, size)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+assetColumns,
+		asset.UserID, asset.StorageKey, asset.Filename, asset.ContentType, asset.Size,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	if asset.PageID != 0 {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO page_assets (page_id, asset_id) VALUES ($1, $2)`, asset.PageID, created.ID); err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+				return nil, ErrNotFound
+			}
+			return nil, err
+		}
+	}
+
+	return created, tx.Commit()
+}
+
+func (p *Postgres) DeleteAsset(ctx context.Context, id, userID int) ([]string, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	keys, err := collectKeys(tx.QueryContext(ctx, `
+		DELETE FROM asset_variants v
+		USING assets a
+		WHERE v.asset_id = a.id AND a.id = $1 AND a.user_id = $2
+		RETURNING v.storage_key
+	`, id, userID))
+	if err != nil {
+		return nil, err
+	}
+
+	var key string
+	err = tx.QueryRowContext(ctx, `DELETE FROM assets WHERE id = $1 AND user_id = $2 RETURNING storage_key`, id, userID).Scan(&key)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return append(keys, key), nil
+}
+
+// loadVariants fills in the resized variants of the given assets
+func (p *Postgres) loadVariants(ctx context.Context, assets []models.Asset) error {
+	if len(assets) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(assets))
+	index := make(map[int]int, len(assets))
+	for i, asset := range assets {
+		ids[i] = int64(asset.ID)
+		index[asset.ID] = i
+	}
+
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT asset_id, width, height, storage_key, content_type, size
+		FROM asset_variants
+		WHERE asset_id = ANY($1)
+		ORDER BY asset_id, width
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var assetID int
+		var v models.AssetVariant
+		if err := rows.Scan(&assetID, &v.Width, &v.Height, &v.StorageKey, &v.ContentType, &v.Size); err != nil {
+			return err
+		}
+		i := index[assetID]
+		assets[i].Variants = append(assets[i].Variants, v)
+	}
+	return rows.Err()
+}
+
+func (p *Postgres) CreateSession(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) (*models.Session, error) {
+	return scanSession(p.db.QueryRowContext(ctx, `
+		INSERT INTO user_sessions (user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)
+		RETURNING id, user_id, token_hash, expires_at, created_at
+	`, userID, tokenHash, expiresAt))
+}
+
+func (p *Postgres) SessionByTokenHash(ctx context.Context, tokenHash string) (*models.Session, error) {
+	return scanSession(p.db.QueryRowContext(ctx, `
+		SELECT id, user_id, token_hash, expires_at, created_at
+		FROM user_sessions
+		WHERE token_hash = $1 AND expires_at > $2
+	`, tokenHash, time.Now()))
+}
+
+func (p *Postgres) DeleteSession(ctx context.Context, id int) error {
+	result, err := p.db.ExecContext(ctx, `DELETE FROM user_sessions WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) DeleteExpiredSessions(ctx context.Context, userID int) (int64, error) {
+	result, err := p.db.ExecContext(ctx, `DELETE FROM user_sessions WHERE user_id = $1 AND expires_at <= $2`, userID, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func scanUser(row rowScanner) (*models.User, error) {
+	user := &models.User{}
+	err := row.Scan(
+		&user.ID, &user.TelegramID, &user.Username, &user.FirstName,
+		&user.LastName, &user.AllowsWriteToPM, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return user, nil
+}
+
+func scanPage(row rowScanner) (*models.Page, error) {
+	page := &models.Page{}
+	err := row.Scan(
+		&page.ID, &page.UserID, &page.WorkspaceID, &page.Title, &page.Description, &page.CoverImage,
+		&page.JSONData, &page.PriceStars, &page.CreatedAt, &page.UpdatedAt,
+	)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return page, nil
+}
+
+func scanAsset(row rowScanner) (*models.Asset, error) {
+	asset := &models.Asset{}
+	err := row.Scan(
+		&asset.ID, &asset.UserID, &asset.StorageKey, &asset.Filename,
+		&asset.ContentType, &asset.Size, &asset.Width, &asset.Height,
+		&asset.Blurhash, &asset.DominantColor, &asset.Status, &asset.CreatedAt,
+	)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	asset.Variants = make([]models.AssetVariant, 0)
+	return asset, nil
+}
+
+func scanSession(row rowScanner) (*models.Session, error) {
+	session := &models.Session{}
+	err := row.Scan(&session.ID, &session.UserID, &session.TokenHash, &session.ExpiresAt, &session.CreatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return session, nil
+}
+
+// notFound translates sql.ErrNoRows into ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// collectKeys reads a single string column from a query result
+func collectKeys(rows *sql.Rows, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]string, 0)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
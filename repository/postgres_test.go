@@ -0,0 +1,54 @@
+package repository_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"tma/database"
+	"tma/models"
+	"tma/repository"
+	"tma/repository/repotest"
+)
+
+// TestPostgres runs the suite against the database in TEST_DATABASE_URL,
+// which is migrated first. Records are added next to existing data.
+func TestPostgres(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := database.New(url, database.Options{})
+	if err != nil {
+		t.Fatalf("database.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	p := repository.NewPostgres(db.DB)
+	workspace := func(t *testing.T, ownerID int) int {
+		t.Helper()
+		var id int
+		err := db.DB.QueryRowContext(context.Background(), `
+			INSERT INTO workspaces (name, owner_id) VALUES ('repotest', $1) RETURNING id
+		`, ownerID).Scan(&id)
+		if err != nil {
+			t.Fatalf("create workspace: %v", err)
+		}
+		return id
+	}
+
+	variant := func(t *testing.T, assetID int, v models.AssetVariant) {
+		t.Helper()
+		_, err := db.DB.ExecContext(context.Background(), `
+			INSERT INTO asset_variants (asset_id, width, height, storage_key, content_type, size)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, assetID, v.Width, v.Height, v.StorageKey, v.ContentType, v.Size)
+		if err != nil {
+			t.Fatalf("create variant: %v", err)
+		}
+	}
+
+	repotest.Run(t, func(t *testing.T) repotest.Subject {
+		return repotest.Subject{Users: p, Pages: p, Sessions: p, Assets: p, Workspace: workspace, AddVariant: variant}
+	})
+}
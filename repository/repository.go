@@ -0,0 +1,138 @@
+// Package repository stores users, pages, assets and sessions behind interfaces,
+// so handlers can run against Postgres in production and against memory
+// where no database is available.
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"tma/models"
+)
+
+var (
+	// ErrNotFound is returned when the requested record does not exist
+	ErrNotFound = errors.New("record not found")
+	// ErrDuplicate is returned when a record with the same unique key
+	// already exists
+	ErrDuplicate = errors.New("record already exists")
+)
+
+// QuotaError is returned by CreateAsset when the asset does not fit into
+// the storage quota of the user
+type QuotaError struct {
+	// Used is the storage already taken by the user
+	Used int64
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("storage quota exceeded, %d bytes used", e.Used)
+}
+
+// Ways ImportPages resolves a page whose title is taken in the workspace
+const (
+	OnConflictSkip      = "skip"
+	OnConflictRename    = "rename"
+	OnConflictOverwrite = "overwrite"
+)
+
+// Signup attributes a new user to the link they signed up through
+type Signup struct {
+	// ReferralCode of the referrer; unknown codes are ignored
+	ReferralCode string
+	Campaign     string
+}
+
+type UserRepository interface {
+	UserByID(ctx context.Context, id int) (*models.User, error)
+	UserByTelegramID(ctx context.Context, telegramID int64) (*models.User, error)
+	CreateUser(ctx context.Context, telegramUser *models.TelegramUser, signup Signup) (*models.User, error)
+	// UpdateUser replaces the Telegram profile fields of a user
+	UpdateUser(ctx context.Context, id int, telegramUser *models.TelegramUser) (*models.User, error)
+	// ReferralCode returns the referral code of a user, creating it on
+	// first use
+	ReferralCode(ctx context.Context, id int) (string, error)
+}
+
+type PageRepository interface {
+	Page(ctx context.Context, id int) (*models.Page, error)
+	// PagesByWorkspace returns the pages of a workspace, newest first
+	PagesByWorkspace(ctx context.Context, workspaceID int) ([]models.Page, error)
+	CreatePage(ctx context.Context, userID, workspaceID int, req models.CreatePageRequest) (*models.Page, error)
+	// UpdatePage changes the fields set in req and leaves empty ones alone
+	UpdatePage(ctx context.Context, id int, req models.UpdatePageRequest) (*models.Page, error)
+	// DeletePage deletes a page together with the assets no other page
+	// uses, and returns the storage keys of those assets to be removed
+	// from storage
+	DeletePage(ctx context.Context, id int) ([]string, error)
+	MovePage(ctx context.Context, id, workspaceID int) (*models.Page, error)
+	SetPagePrice(ctx context.Context, id, priceStars int) error
+	// PageRevisions returns the earlier contents of a page, newest first
+	PageRevisions(ctx context.Context, pageID int) ([]models.PageRevision, error)
+	// ImportPages creates pages from an archive in one transaction. A
+	// page whose title is taken is skipped, renamed or overwrites the
+	// existing page according to opts.OnConflict; identical pages are
+	// skipped. Revisions are restored for created pages only. Prices are
+	// not imported: created pages are free and overwritten pages keep
+	// theirs, since only admins may set one.
+	ImportPages(ctx context.Context, userID, workspaceID int, pages []models.PageExport, opts ImportOptions) (*models.ImportReport, error)
+}
+
+// ImportOptions control how ImportPages writes an archive
+type ImportOptions struct {
+	// OnConflict is one of the OnConflict constants
+	OnConflict string
+	// Assets maps the IDs of archive assets to the assets created for
+	// them. Written pages are linked to theirs.
+	Assets map[int]int
+	// Rewrite, if set, adapts a page and its revisions before they are
+	// written, such as pointing asset URLs at the new assets. Conflicts
+	// are resolved against the page as it is in the archive.
+	Rewrite func(models.PageExport) models.PageExport
+}
+
+// NewAsset is an uploaded file whose content is already in storage
+type NewAsset struct {
+	UserID      int
+	StorageKey  string
+	Filename    string
+	ContentType string
+	Size        int64
+	// PageID links the asset to a page unless 0, so that deleting the
+	// page deletes the asset
+	PageID int
+}
+
+// AssetRepository records uploaded files; their content lives in storage
+type AssetRepository interface {
+	// Asset returns an asset with its variants
+	Asset(ctx context.Context, id int) (*models.Asset, error)
+	// AssetsByUser returns the assets of a user with their variants,
+	// newest first
+	AssetsByUser(ctx context.Context, userID int) ([]models.Asset, error)
+	// PageAssets returns the assets linked to a page with their variants
+	PageAssets(ctx context.Context, pageID int) ([]models.Asset, error)
+	// Variant returns the narrowest variant of an asset at least width
+	// wide
+	Variant(ctx context.Context, assetID, width int) (*models.AssetVariant, error)
+	// CreateAsset records an upload unless it exceeds quota bytes
+	// together with the assets the user already has and their variants,
+	// which is a *QuotaError
+	CreateAsset(ctx context.Context, asset NewAsset, quota int64) (*models.Asset, error)
+	// DeleteAsset deletes an asset of the user with its variants and
+	// returns the storage keys to be removed from storage
+	DeleteAsset(ctx context.Context, id, userID int) ([]string, error)
+}
+
+// SessionRepository records the tokens issued to users
+type SessionRepository interface {
+	CreateSession(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) (*models.Session, error)
+	// SessionByTokenHash returns an unexpired session
+	SessionByTokenHash(ctx context.Context, tokenHash string) (*models.Session, error)
+	DeleteSession(ctx context.Context, id int) error
+	// DeleteExpiredSessions removes the expired sessions of a user and
+	// returns how many were removed
+	DeleteExpiredSessions(ctx context.Context, userID int) (int64, error)
+}
@@ -0,0 +1,907 @@
+// Package repotest is a conformance suite for the repository interfaces.
+// Every implementation is expected to pass it:
+//
+//	func TestMemory(t *testing.T) {
+//		repotest.Run(t, func(t *testing.T) repotest.Subject {
+//			m := repository.NewMemory()
+//			return repotest.Subject{Users: m, Pages: m, Sessions: m, Assets: m, Workspace: repotest.AnyWorkspace, AddVariant: repotest.MemoryVariant(m)}
+//		})
+//	}
+//
+// Records are created with fresh Telegram IDs and token hashes, so the
+// suite can run against a database that already holds data.
+package repotest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tma/models"
+	"tma/repository"
+)
+
+// Subject is one set of repositories under test
+type Subject struct {
+	Users    repository.UserRepository
+	Pages    repository.PageRepository
+	Sessions repository.SessionRepository
+	Assets   repository.AssetRepository
+	// Workspace returns a workspace of the user that pages can be
+	// created in
+	Workspace func(t *testing.T, ownerID int) int
+	// AddVariant records a resized variant of an asset
+	AddVariant func(t *testing.T, assetID int, variant models.AssetVariant)
+}
+
+var lastWorkspace int64
+
+// AnyWorkspace returns a new workspace ID for implementations that do not
+// check that workspaces exist
+func AnyWorkspace(t *testing.T, ownerID int) int {
+	return int(atomic.AddInt64(&lastWorkspace, 1))
+}
+
+// MemoryVariant returns the AddVariant of a Memory
+func MemoryVariant(m *repository.Memory) func(t *testing.T, assetID int, variant models.AssetVariant) {
+	return func(t *testing.T, assetID int, variant models.AssetVariant) {
+		t.Helper()
+		if err := m.AddVariant(assetID, variant); err != nil {
+			t.Fatalf("AddVariant: %v", err)
+		}
+	}
+}
+
+// Run runs the suite, calling newSubject once per test
+func Run(t *testing.T, newSubject func(t *testing.T) Subject) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s Subject)
+	}{
+		{"CreateUser", testCreateUser},
+		{"DuplicateUser", testDuplicateUser},
+		{"UpdateUser", testUpdateUser},
+		{"MissingUser", testMissingUser},
+		{"ReferralCode", testReferralCode},
+		{"CreatePage", testCreatePage},
+		{"PagesByWorkspace", testPagesByWorkspace},
+		{"UpdatePage", testUpdatePage},
+		{"DeletePage", testDeletePage},
+		{"MissingPage", testMissingPage},
+		{"MovePage", testMovePage},
+		{"SetPagePrice", testSetPagePrice},
+		{"ImportPages", testImportPages},
+		{"ImportRevisionsAndAssets", testImportRevisionsAndAssets},
+		{"PageRevisions", testPageRevisions},
+		{"Assets", testAssets},
+		{"AssetQuota", testAssetQuota},
+		{"PageAssets", testPageAssets},
+		{"Sessions", testSessions},
+		{"ExpiredSessions", testExpiredSessions},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newSubject(t))
+		})
+	}
+}
+
+var lastTelegramID = time.Now().UnixNano() / 1000
+
+// telegramUser returns a profile with a Telegram ID not used before
+func telegramUser(name string) *models.TelegramUser {
+	return &models.TelegramUser{
+		ID:        atomic.AddInt64(&lastTelegramID, 1),
+		Username:  name,
+		FirstName: "First " + name,
+		LastName:  "Last " + name,
+	}
+}
+
+func createUser(t *testing.T, s Subject, name string) *models.User {
+	t.Helper()
+	user, err := s.Users.CreateUser(context.Background(), telegramUser(name), repository.Signup{})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
+
+func testCreateUser(t *testing.T, s Subject) {
+	ctx := context.Background()
+	tu := telegramUser("alice")
+	tu.AllowsWriteToPM = true
+
+	created, err := s.Users.CreateUser(ctx, tu, repository.Signup{})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if created.ID == 0 || created.TelegramID != tu.ID || created.Username != tu.Username ||
+		created.FirstName != tu.FirstName || created.LastName != tu.LastName || !created.AllowsWriteToPM {
+		t.Fatalf("CreateUser returned %+v for %+v", created, tu)
+	}
+	if created.CreatedAt.IsZero() || created.UpdatedAt.IsZero() {
+		t.Errorf("CreateUser left timestamps empty: %+v", created)
+	}
+
+	byID, err := s.Users.UserByID(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("UserByID: %v", err)
+	}
+	assertSameUser(t, byID, created)
+
+	byTelegramID, err := s.Users.UserByTelegramID(ctx, tu.ID)
+	if err != nil {
+		t.Fatalf("UserByTelegramID: %v", err)
+	}
+	assertSameUser(t, byTelegramID, created)
+}
+
+func testDuplicateUser(t *testing.T, s Subject) {
+	ctx := context.Background()
+	tu := telegramUser("bob")
+	if _, err := s.Users.CreateUser(ctx, tu, repository.Signup{}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if _, err := s.Users.CreateUser(ctx, tu, repository.Signup{}); !errors.Is(err, repository.ErrDuplicate) {
+		t.Fatalf("second CreateUser returned %v, want ErrDuplicate", err)
+	}
+}
+
+func testUpdateUser(t *testing.T, s Subject) {
+	ctx := context.Background()
+	created := createUser(t, s, "carol")
+
+	changed := &models.TelegramUser{
+		ID:              created.TelegramID,
+		Username:        "carol2",
+		FirstName:       "Caroline",
+		LastName:        "",
+		AllowsWriteToPM: true,
+	}
+	updated, err := s.Users.UpdateUser(ctx, created.ID, changed)
+	if err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if updated.ID != created.ID || updated.TelegramID != created.TelegramID ||
+		updated.Username != "carol2" || updated.FirstName != "Caroline" || updated.LastName != "" || !updated.AllowsWriteToPM {
+		t.Fatalf("UpdateUser returned %+v", updated)
+	}
+	if !updated.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("UpdateUser changed created_at from %v to %v", created.CreatedAt, updated.CreatedAt)
+	}
+	if updated.UpdatedAt.Before(created.UpdatedAt) {
+		t.Errorf("UpdateUser moved updated_at back from %v to %v", created.UpdatedAt, updated.UpdatedAt)
+	}
+
+	stored, err := s.Users.UserByID(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("UserByID: %v", err)
+	}
+	assertSameUser(t, stored, updated)
+}
+
+func testMissingUser(t *testing.T, s Subject) {
+	ctx := context.Background()
+	if _, err := s.Users.UserByID(ctx, -1); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("UserByID of a missing user returned %v, want ErrNotFound", err)
+	}
+	if _, err := s.Users.UserByTelegramID(ctx, telegramUser("nobody").ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("UserByTelegramID of a missing user returned %v, want ErrNotFound", err)
+	}
+	if _, err := s.Users.UpdateUser(ctx, -1, telegramUser("nobody")); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("UpdateUser of a missing user returned %v, want ErrNotFound", err)
+	}
+	if _, err := s.Users.ReferralCode(ctx, -1); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("ReferralCode of a missing user returned %v, want ErrNotFound", err)
+	}
+}
+
+func testReferralCode(t *testing.T, s Subject) {
+	ctx := context.Background()
+	referrer := createUser(t, s, "dave")
+	other := createUser(t, s, "erin")
+
+	code, err := s.Users.ReferralCode(ctx, referrer.ID)
+	if err != nil {
+		t.Fatalf("ReferralCode: %v", err)
+	}
+	if code == "" {
+		t.Fatal("ReferralCode returned an empty code")
+	}
+	again, err := s.Users.ReferralCode(ctx, referrer.ID)
+	if err != nil {
+		t.Fatalf("second ReferralCode: %v", err)
+	}
+	if again != code {
+		t.Errorf("ReferralCode changed from %q to %q", code, again)
+	}
+	otherCode, err := s.Users.ReferralCode(ctx, other.ID)
+	if err != nil {
+		t.Fatalf("ReferralCode: %v", err)
+	}
+	if otherCode == code {
+		t.Errorf("two users share referral code %q", code)
+	}
+
+	// Signups through known and unknown codes both succeed
+	for _, signup := range []repository.Signup{
+		{ReferralCode: code, Campaign: "spring"},
+		{ReferralCode: "unknown", Campaign: "spring"},
+	} {
+		if _, err := s.Users.CreateUser(ctx, telegramUser("frank"), signup); err != nil {
+			t.Errorf("CreateUser with %+v: %v", signup, err)
+		}
+	}
+}
+
+func testCreatePage(t *testing.T, s Subject) {
+	ctx := context.Background()
+	owner := createUser(t, s, "grace")
+	workspaceID := s.Workspace(t, owner.ID)
+
+	req := models.CreatePageRequest{
+		Title:       "Title",
+		Description: "Description",
+		CoverImage:  "https://example.com/cover.png",
+		JSONData:    models.JSONData(`{"blocks":[{"type":"text","text":"hi"}]}`),
+	}
+	created, err := s.Pages.CreatePage(ctx, owner.ID, workspaceID, req)
+	if err != nil {
+		t.Fatalf("CreatePage: %v", err)
+	}
+	if created.ID == 0 || created.UserID != owner.ID || created.WorkspaceID != workspaceID ||
+		created.Title != req.Title || created.Description != req.Description || created.CoverImage != req.CoverImage ||
+		created.PriceStars != 0 {
+		t.Fatalf("CreatePage returned %+v", created)
+	}
+	assertSameJSON(t, created.JSONData, req.JSONData)
+
+	stored, err := s.Pages.Page(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("Page: %v", err)
+	}
+	assertSamePage(t, stored, created)
+
+	// Returned pages are copies
+	stored.JSONData[0] = '['
+	again, err := s.Pages.Page(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("Page: %v", err)
+	}
+	assertSameJSON(t, again.JSONData, req.JSONData)
+}
+
+func testPagesByWorkspace(t *testing.T, s Subject) {
+	ctx := context.Background()
+	owner := createUser(t, s, "heidi")
+	workspaceID := s.Workspace(t, owner.ID)
+	otherWorkspaceID := s.Workspace(t, owner.ID)
+
+	empty, err := s.Pages.PagesByWorkspace(ctx, workspaceID)
+	if err != nil {
+		t.Fatalf("PagesByWorkspace: %v", err)
+	}
+	if empty == nil || len(empty) != 0 {
+		t.Fatalf("PagesByWorkspace of an empty workspace returned %#v, want an empty slice", empty)
+	}
+
+	var ids []int
+	for i := 0; i < 3; i++ {
+		page, err := s.Pages.CreatePage(ctx, owner.ID, workspaceID, models.CreatePageRequest{Title: fmt.Sprintf("Page %d", i)})
+		if err != nil {
+			t.Fatalf("CreatePage: %v", err)
+		}
+		ids = append([]int{page.ID}, ids...)
+	}
+	if _, err := s.Pages.CreatePage(ctx, owner.ID, otherWorkspaceID, models.CreatePageRequest{Title: "Elsewhere"}); err != nil {
+		t.Fatalf("CreatePage: %v", err)
+	}
+
+	pages, err := s.Pages.PagesByWorkspace(ctx, workspaceID)
+	if err != nil {
+		t.Fatalf("PagesByWorkspace: %v", err)
+	}
+	var got []int
+	for _, page := range pages {
+		got = append(got, page.ID)
+	}
+	if !reflect.DeepEqual(got, ids) {
+		t.Errorf("PagesByWorkspace returned pages %v, want %v", got, ids)
+	}
+}
+
+func testUpdatePage(t *testing.T, s Subject) {
+	ctx := context.Background()
+	owner := createUser(t, s, "ivan")
+	workspaceID := s.Workspace(t, owner.ID)
+
+	created, err := s.Pages.CreatePage(ctx, owner.ID, workspaceID, models.CreatePageRequest{
+		Title:       "Before",
+		Description: "Kept",
+		JSONData:    models.JSONData(`{"v":1}`),
+	})
+	if err != nil {
+		t.Fatalf("CreatePage: %v", err)
+	}
+
+	updated, err := s.Pages.UpdatePage(ctx, created.ID, models.UpdatePageRequest{Title: "After"})
+	if err != nil {
+		t.Fatalf("UpdatePage: %v", err)
+	}
+	if updated.Title != "After" || updated.Description != "Kept" {
+		t.Errorf("UpdatePage with a title returned %+v", updated)
+	}
+	assertSameJSON(t, updated.JSONData, created.JSONData)
+
+	updated, err = s.Pages.UpdatePage(ctx, created.ID, models.UpdatePageRequest{JSONData: models.JSONData(`{"v":2}`)})
+	if err != nil {
+		t.Fatalf("UpdatePage: %v", err)
+	}
+	if updated.Title != "After" {
+		t.Errorf("UpdatePage with json_data changed the title to %q", updated.Title)
+	}
+	assertSameJSON(t, updated.JSONData, models.JSONData(`{"v":2}`))
+	if !updated.CreatedAt.Equal(created.CreatedAt) || updated.UpdatedAt.Before(created.UpdatedAt) {
+		t.Errorf("UpdatePage timestamps went from %v/%v to %v/%v",
+			created.CreatedAt, created.UpdatedAt, updated.CreatedAt, updated.UpdatedAt)
+	}
+
+	stored, err := s.Pages.Page(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("Page: %v", err)
+	}
+	assertSamePage(t, stored, updated)
+}
+
+func testDeletePage(t *testing.T, s Subject) {
+	ctx := context.Background()
+	owner := createUser(t, s, "judy")
+	workspaceID := s.Workspace(t, owner.ID)
+
+	page, err := s.Pages.CreatePage(ctx, owner.ID, workspaceID, models.CreatePageRequest{Title: "Doomed"})
+	if err != nil {
+		t.Fatalf("CreatePage: %v", err)
+	}
+	keys, err := s.Pages.DeletePage(ctx, page.ID)
+	if err != nil {
+		t.Fatalf("DeletePage: %v", err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("DeletePage of a page without assets returned keys %v", keys)
+	}
+	if _, err := s.Pages.Page(ctx, page.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Page after DeletePage returned %v, want ErrNotFound", err)
+	}
+	if _, err := s.Pages.DeletePage(ctx, page.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("second DeletePage returned %v, want ErrNotFound", err)
+	}
+}
+
+func testMissingPage(t *testing.T, s Subject) {
+	ctx := context.Background()
+	if _, err := s.Pages.Page(ctx, -1); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Page of a missing page returned %v, want ErrNotFound", err)
+	}
+	if _, err := s.Pages.UpdatePage(ctx, -1, models.UpdatePageRequest{Title: "x"}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("UpdatePage of a missing page returned %v, want ErrNotFound", err)
+	}
+}
+
+func testMovePage(t *testing.T, s Subject) {
+	ctx := context.Background()
+	owner := createUser(t, s, "kate")
+	from := s.Workspace(t, owner.ID)
+	to := s.Workspace(t, owner.ID)
+
+	page, err := s.Pages.CreatePage(ctx, owner.ID, from, models.CreatePageRequest{Title: "Moving"})
+	if err != nil {
+		t.Fatalf("CreatePage: %v", err)
+	}
+	moved, err := s.Pages.MovePage(ctx, page.ID, to)
+	if err != nil {
+		t.Fatalf("MovePage: %v", err)
+	}
+	if moved.ID != page.ID || moved.WorkspaceID != to {
+		t.Errorf("MovePage returned %+v, want page %d in workspace %d", moved, page.ID, to)
+	}
+	if pages, err := s.Pages.PagesByWorkspace(ctx, from); err != nil || len(pages) != 0 {
+		t.Errorf("PagesByWorkspace of the old workspace returned %v, %v, want no pages", pages, err)
+	}
+	if _, err := s.Pages.MovePage(ctx, -1, to); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("MovePage of a missing page returned %v, want ErrNotFound", err)
+	}
+}
+
+func testSetPagePrice(t *testing.T, s Subject) {
+	ctx := context.Background()
+	owner := createUser(t, s, "leo")
+	page, err := s.Pages.CreatePage(ctx, owner.ID, s.Workspace(t, owner.ID), models.CreatePageRequest{Title: "Paid"})
+	if err != nil {
+		t.Fatalf("CreatePage: %v", err)
+	}
+
+	if err := s.Pages.SetPagePrice(ctx, page.ID, 50); err != nil {
+		t.Fatalf("SetPagePrice: %v", err)
+	}
+	stored, err := s.Pages.Page(ctx, page.ID)
+	if err != nil {
+		t.Fatalf("Page: %v", err)
+	}
+	if stored.PriceStars != 50 {
+		t.Errorf("Page after SetPagePrice has price %d, want 50", stored.PriceStars)
+	}
+	if err := s.Pages.SetPagePrice(ctx, -1, 50); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("SetPagePrice of a missing page returned %v, want ErrNotFound", err)
+	}
+}
+
+func testImportPages(t *testing.T, s Subject) {
+	ctx := context.Background()
+	owner := createUser(t, s, "mike")
+	workspaceID := s.Workspace(t, owner.ID)
+
+	existing, err := s.Pages.CreatePage(ctx, owner.ID, workspaceID, models.CreatePageRequest{Title: "Taken", JSONData: models.JSONData(`{"v":1}`)})
+	if err != nil {
+		t.Fatalf("CreatePage: %v", err)
+	}
+	same, err := s.Pages.CreatePage(ctx, owner.ID, workspaceID, models.CreatePageRequest{Title: "Same", JSONData: models.JSONData(`{"a":1,"b":2}`)})
+	if err != nil {
+		t.Fatalf("CreatePage: %v", err)
+	}
+
+	archive := []models.PageExport{
+		{ID: 1, Title: "New", JSONData: models.JSONData(`{"new":true}`), PriceStars: 100},
+		{ID: 2, Title: "Taken", JSONData: models.JSONData(`{"v":2}`), PriceStars: 100},
+		{ID: 3, Title: "Same", JSONData: models.JSONData(`{"b":2, "a":1}`)},
+		{ID: 4, Title: "  "},
+	}
+
+	report, err := s.Pages.ImportPages(ctx, owner.ID, workspaceID, archive, repository.ImportOptions{OnConflict: repository.OnConflictSkip})
+	if err != nil {
+		t.Fatalf("ImportPages: %v", err)
+	}
+	if len(report.Created) != 1 || report.Created[0].OldID != 1 || len(report.Overwritten) != 0 || len(report.Skipped) != 3 {
+		t.Fatalf("ImportPages with skip reported %+v", report)
+	}
+	created, err := s.Pages.Page(ctx, report.Created[0].NewID)
+	if err != nil {
+		t.Fatalf("Page: %v", err)
+	}
+	// Prices are set by admins, not by whoever imports an archive
+	if created.Title != "New" || created.UserID != owner.ID || created.WorkspaceID != workspaceID || created.PriceStars != 0 {
+		t.Errorf("ImportPages created %+v", created)
+	}
+	assertSameJSON(t, created.JSONData, archive[0].JSONData)
+
+	if err := s.Pages.SetPagePrice(ctx, existing.ID, 30); err != nil {
+		t.Fatalf("SetPagePrice: %v", err)
+	}
+	report, err = s.Pages.ImportPages(ctx, owner.ID, workspaceID, archive[1:2], repository.ImportOptions{OnConflict: repository.OnConflictOverwrite})
+	if err != nil {
+		t.Fatalf("ImportPages: %v", err)
+	}
+	if len(report.Overwritten) != 1 || report.Overwritten[0].NewID != existing.ID {
+		t.Fatalf("ImportPages with overwrite reported %+v", report)
+	}
+	overwritten, err := s.Pages.Page(ctx, existing.ID)
+	if err != nil {
+		t.Fatalf("Page: %v", err)
+	}
+	assertSameJSON(t, overwritten.JSONData, archive[1].JSONData)
+	if overwritten.PriceStars != 30 {
+		t.Errorf("ImportPages changed the price of an overwritten page to %d", overwritten.PriceStars)
+	}
+
+	report, err = s.Pages.ImportPages(ctx, owner.ID, workspaceID, []models.PageExport{{ID: 5, Title: "Taken"}}, repository.ImportOptions{OnConflict: repository.OnConflictRename})
+	if err != nil {
+		t.Fatalf("ImportPages: %v", err)
+	}
+	if len(report.Created) != 1 || report.Created[0].Title != "Taken (imported)" {
+		t.Fatalf("ImportPages with rename reported %+v", report)
+	}
+	report, err = s.Pages.ImportPages(ctx, owner.ID, workspaceID, []models.PageExport{{ID: 6, Title: "Taken"}}, repository.ImportOptions{OnConflict: repository.OnConflictRename})
+	if err != nil {
+		t.Fatalf("ImportPages: %v", err)
+	}
+	if len(report.Created) != 1 || report.Created[0].Title != "Taken (imported 2)" {
+		t.Errorf("ImportPages renaming twice reported %+v", report)
+	}
+
+	// Renamed titles still fit into the column
+	long := strings.Repeat("я", 255)
+	longTitles := []models.PageExport{{ID: 7, Title: long}, {ID: 8, Title: long, Description: "other"}}
+	report, err = s.Pages.ImportPages(ctx, owner.ID, workspaceID, longTitles, repository.ImportOptions{OnConflict: repository.OnConflictRename})
+	if err != nil {
+		t.Fatalf("ImportPages: %v", err)
+	}
+	if len(report.Created) != 2 || report.Created[1].Title != strings.Repeat("я", 244)+" (imported)" {
+		t.Errorf("ImportPages renaming a long title reported %+v", report)
+	}
+
+	// Identical pages are skipped whatever the policy
+	report, err = s.Pages.ImportPages(ctx, owner.ID, workspaceID, archive[2:3], repository.ImportOptions{OnConflict: repository.OnConflictOverwrite})
+	if err != nil {
+		t.Fatalf("ImportPages: %v", err)
+	}
+	if len(report.Skipped) != 1 || len(report.Overwritten) != 0 {
+		t.Errorf("ImportPages of an identical page reported %+v", report)
+	}
+	if stored, err := s.Pages.Page(ctx, same.ID); err != nil || !stored.UpdatedAt.Equal(same.UpdatedAt) {
+		t.Errorf("ImportPages of an identical page changed it: %+v, %v", stored, err)
+	}
+
+	// Pages differing in anything but json_data are not identical
+	different := models.PageExport{ID: 9, Title: "Same", Description: "changed", JSONData: models.JSONData(`{"a":1,"b":2}`)}
+	report, err = s.Pages.ImportPages(ctx, owner.ID, workspaceID, []models.PageExport{different}, repository.ImportOptions{OnConflict: repository.OnConflictOverwrite})
+	if err != nil {
+		t.Fatalf("ImportPages: %v", err)
+	}
+	if len(report.Overwritten) != 1 {
+		t.Errorf("ImportPages of a page with another description reported %+v", report)
+	}
+}
+
+func testImportRevisionsAndAssets(t *testing.T, s Subject) {
+	ctx := context.Background()
+	owner := createUser(t, s, "rita")
+	workspaceID := s.Workspace(t, owner.ID)
+
+	asset, err := s.Assets.CreateAsset(ctx, newAsset(owner.ID, 10), 1000)
+	if err != nil {
+		t.Fatalf("CreateAsset: %v", err)
+	}
+
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	archive := []models.PageExport{{
+		ID:        1,
+		Title:     "Restored",
+		JSONData:  models.JSONData(`{"image":"old-7"}`),
+		CreatedAt: created,
+		Assets:    []int{7, 8},
+		Revisions: []models.RevisionExport{
+			{Title: "Second", JSONData: models.JSONData(`{"image":"old-7"}`), CreatedAt: created.Add(2 * time.Hour)},
+			{Title: "First", JSONData: models.JSONData(`{}`), CreatedAt: created.Add(time.Hour)},
+		},
+	}}
+	var rewritten []string
+	opts := repository.ImportOptions{
+		OnConflict: repository.OnConflictSkip,
+		Assets:     map[int]int{7: asset.ID},
+		Rewrite: func(p models.PageExport) models.PageExport {
+			rewritten = append(rewritten, p.Title)
+			p.JSONData = models.JSONData(`{"image":"new"}`)
+			return p
+		},
+	}
+	report, err := s.Pages.ImportPages(ctx, owner.ID, workspaceID, archive, opts)
+	if err != nil {
+		t.Fatalf("ImportPages: %v", err)
+	}
+	if len(report.Created) != 1 {
+		t.Fatalf("ImportPages reported %+v", report)
+	}
+	pageID := report.Created[0].NewID
+
+	page, err := s.Pages.Page(ctx, pageID)
+	if err != nil {
+		t.Fatalf("Page: %v", err)
+	}
+	assertSameJSON(t, page.JSONData, models.JSONData(`{"image":"new"}`))
+
+	revisions, err := s.Pages.PageRevisions(ctx, pageID)
+	if err != nil {
+		t.Fatalf("PageRevisions: %v", err)
+	}
+	if len(revisions) != 2 || revisions[0].Title != "Second" || revisions[1].Title != "First" ||
+		!revisions[1].CreatedAt.Equal(created.Add(time.Hour)) {
+		t.Errorf("PageRevisions of an imported page returned %+v", revisions)
+	}
+
+	assets, err := s.Assets.PageAssets(ctx, pageID)
+	if err != nil {
+		t.Fatalf("PageAssets: %v", err)
+	}
+	if len(assets) != 1 || assets[0].ID != asset.ID {
+		t.Errorf("PageAssets of an imported page returned %+v, want asset %d", assets, asset.ID)
+	}
+
+	// Skipped pages are not rewritten
+	rewritten = nil
+	if _, err := s.Pages.ImportPages(ctx, owner.ID, workspaceID, archive, opts); err != nil {
+		t.Fatalf("ImportPages: %v", err)
+	}
+	if len(rewritten) != 0 {
+		t.Errorf("ImportPages rewrote skipped pages %v", rewritten)
+	}
+}
+
+func testPageRevisions(t *testing.T, s Subject) {
+	ctx := context.Background()
+	owner := createUser(t, s, "sam")
+	page, err := s.Pages.CreatePage(ctx, owner.ID, s.Workspace(t, owner.ID), models.CreatePageRequest{Title: "Draft", JSONData: models.JSONData(`{"v":1}`)})
+	if err != nil {
+		t.Fatalf("CreatePage: %v", err)
+	}
+
+	if _, err := s.Pages.UpdatePage(ctx, page.ID, models.UpdatePageRequest{Title: "Final"}); err != nil {
+		t.Fatalf("UpdatePage: %v", err)
+	}
+	// Edits right after the last revision do not add another one
+	if _, err := s.Pages.UpdatePage(ctx, page.ID, models.UpdatePageRequest{JSONData: models.JSONData(`{"v":2}`)}); err != nil {
+		t.Fatalf("UpdatePage: %v", err)
+	}
+
+	revisions, err := s.Pages.PageRevisions(ctx, page.ID)
+	if err != nil {
+		t.Fatalf("PageRevisions: %v", err)
+	}
+	if len(revisions) != 1 || revisions[0].PageID != page.ID || revisions[0].Title != "Draft" {
+		t.Fatalf("PageRevisions returned %+v, want the draft", revisions)
+	}
+	assertSameJSON(t, revisions[0].JSONData, page.JSONData)
+}
+
+var lastKey int64
+
+// newAsset returns an upload with a storage key not used before
+func newAsset(userID int, size int64) repository.NewAsset {
+	return repository.NewAsset{
+		UserID:      userID,
+		StorageKey:  fmt.Sprintf("repotest/%d-%d.png", time.Now().UnixNano(), atomic.AddInt64(&lastKey, 1)),
+		Filename:    "image.png",
+		ContentType: "image/png",
+		Size:        size,
+	}
+}
+
+func testAssets(t *testing.T, s Subject) {
+	ctx := context.Background()
+	owner := createUser(t, s, "nina")
+	other := createUser(t, s, "oscar")
+
+	first, err := s.Assets.CreateAsset(ctx, newAsset(owner.ID, 10), 1000)
+	if err != nil {
+		t.Fatalf("CreateAsset: %v", err)
+	}
+	upload := newAsset(owner.ID, 20)
+	second, err := s.Assets.CreateAsset(ctx, upload, 1000)
+	if err != nil {
+		t.Fatalf("CreateAsset: %v", err)
+	}
+	if second.ID == 0 || second.UserID != owner.ID || second.StorageKey != upload.StorageKey ||
+		second.Filename != upload.Filename || second.ContentType != upload.ContentType || second.Size != upload.Size ||
+		second.Status != "pending" || second.Variants == nil {
+		t.Fatalf("CreateAsset returned %+v for %+v", second, upload)
+	}
+
+	stored, err := s.Assets.Asset(ctx, second.ID)
+	if err != nil {
+		t.Fatalf("Asset: %v", err)
+	}
+	if stored.ID != second.ID || stored.StorageKey != second.StorageKey || len(stored.Variants) != 0 {
+		t.Errorf("Asset returned %+v, want %+v", stored, second)
+	}
+	if _, err := s.Assets.Variant(ctx, second.ID, 320); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Variant of an unprocessed asset returned %v, want ErrNotFound", err)
+	}
+
+	assets, err := s.Assets.AssetsByUser(ctx, owner.ID)
+	if err != nil {
+		t.Fatalf("AssetsByUser: %v", err)
+	}
+	if len(assets) != 2 || assets[0].ID != second.ID || assets[1].ID != first.ID {
+		t.Errorf("AssetsByUser returned %+v, want assets %d and %d", assets, second.ID, first.ID)
+	}
+
+	if _, err := s.Assets.DeleteAsset(ctx, second.ID, other.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("DeleteAsset by another user returned %v, want ErrNotFound", err)
+	}
+	keys, err := s.Assets.DeleteAsset(ctx, second.ID, owner.ID)
+	if err != nil {
+		t.Fatalf("DeleteAsset: %v", err)
+	}
+	if !reflect.DeepEqual(keys, []string{upload.StorageKey}) {
+		t.Errorf("DeleteAsset returned keys %v, want %v", keys, []string{upload.StorageKey})
+	}
+	if _, err := s.Assets.Asset(ctx, second.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Asset after DeleteAsset returned %v, want ErrNotFound", err)
+	}
+}
+
+func testAssetQuota(t *testing.T, s Subject) {
+	ctx := context.Background()
+	owner := createUser(t, s, "peggy")
+
+	asset, err := s.Assets.CreateAsset(ctx, newAsset(owner.ID, 50), 100)
+	if err != nil {
+		t.Fatalf("CreateAsset: %v", err)
+	}
+	// Variants count against the quota
+	s.AddVariant(t, asset.ID, models.AssetVariant{Width: 320, Height: 240, StorageKey: asset.StorageKey + "_320", ContentType: "image/jpeg", Size: 10})
+
+	_, err = s.Assets.CreateAsset(ctx, newAsset(owner.ID, 50), 100)
+	var quotaErr *repository.QuotaError
+	if !errors.As(err, &quotaErr) || quotaErr.Used != 60 {
+		t.Fatalf("CreateAsset over quota returned %v, want a QuotaError with 60 bytes used", err)
+	}
+	if _, err := s.Assets.CreateAsset(ctx, newAsset(owner.ID, 40), 100); err != nil {
+		t.Errorf("CreateAsset filling the quota: %v", err)
+	}
+}
+
+func testPageAssets(t *testing.T, s Subject) {
+	ctx := context.Background()
+	owner := createUser(t, s, "quinn")
+	page, err := s.Pages.CreatePage(ctx, owner.ID, s.Workspace(t, owner.ID), models.CreatePageRequest{Title: "With assets"})
+	if err != nil {
+		t.Fatalf("CreatePage: %v", err)
+	}
+
+	upload := newAsset(owner.ID, 10)
+	upload.PageID = page.ID
+	linked, err := s.Assets.CreateAsset(ctx, upload, 1000)
+	if err != nil {
+		t.Fatalf("CreateAsset: %v", err)
+	}
+	unlinked, err := s.Assets.CreateAsset(ctx, newAsset(owner.ID, 10), 1000)
+	if err != nil {
+		t.Fatalf("CreateAsset: %v", err)
+	}
+	missing := newAsset(owner.ID, 10)
+	missing.PageID = -1
+	if _, err := s.Assets.CreateAsset(ctx, missing, 1000); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("CreateAsset for a missing page returned %v, want ErrNotFound", err)
+	}
+
+	assets, err := s.Assets.PageAssets(ctx, page.ID)
+	if err != nil {
+		t.Fatalf("PageAssets: %v", err)
+	}
+	if len(assets) != 1 || assets[0].ID != linked.ID {
+		t.Errorf("PageAssets returned %+v, want asset %d", assets, linked.ID)
+	}
+
+	keys, err := s.Pages.DeletePage(ctx, page.ID)
+	if err != nil {
+		t.Fatalf("DeletePage: %v", err)
+	}
+	if !reflect.DeepEqual(keys, []string{upload.StorageKey}) {
+		t.Errorf("DeletePage returned keys %v, want %v", keys, []string{upload.StorageKey})
+	}
+	if _, err := s.Assets.Asset(ctx, linked.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Asset of a deleted page returned %v, want ErrNotFound", err)
+	}
+	if _, err := s.Assets.Asset(ctx, unlinked.ID); err != nil {
+		t.Errorf("DeletePage removed an unlinked asset: %v", err)
+	}
+}
+
+var lastToken int64
+
+// tokenHash returns a token hash not used before
+func tokenHash() string {
+	return fmt.Sprintf("repotest-%d-%d", time.Now().UnixNano(), atomic.AddInt64(&lastToken, 1))
+}
+
+func testSessions(t *testing.T, s Subject) {
+	ctx := context.Background()
+	user := createUser(t, s, "mallory")
+	hash := tokenHash()
+	expiresAt := time.Now().Add(time.Hour)
+
+	created, err := s.Sessions.CreateSession(ctx, user.ID, hash, expiresAt)
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if created.ID == 0 || created.UserID != user.ID || created.TokenHash != hash {
+		t.Fatalf("CreateSession returned %+v", created)
+	}
+
+	found, err := s.Sessions.SessionByTokenHash(ctx, hash)
+	if err != nil {
+		t.Fatalf("SessionByTokenHash: %v", err)
+	}
+	if found.ID != created.ID || found.UserID != user.ID {
+		t.Errorf("SessionByTokenHash returned %+v, want %+v", found, created)
+	}
+	if d := found.ExpiresAt.Sub(expiresAt); d > time.Millisecond || d < -time.Millisecond {
+		t.Errorf("SessionByTokenHash returned expires_at %v, want %v", found.ExpiresAt, expiresAt)
+	}
+
+	if err := s.Sessions.DeleteSession(ctx, created.ID); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+	if _, err := s.Sessions.SessionByTokenHash(ctx, hash); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("SessionByTokenHash after DeleteSession returned %v, want ErrNotFound", err)
+	}
+	if err := s.Sessions.DeleteSession(ctx, created.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("second DeleteSession returned %v, want ErrNotFound", err)
+	}
+}
+
+func testExpiredSessions(t *testing.T, s Subject) {
+	ctx := context.Background()
+	user := createUser(t, s, "niaj")
+	other := createUser(t, s, "olivia")
+
+	expired := tokenHash()
+	if _, err := s.Sessions.CreateSession(ctx, user.ID, expired, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	live := tokenHash()
+	if _, err := s.Sessions.CreateSession(ctx, user.ID, live, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	otherExpired := tokenHash()
+	if _, err := s.Sessions.CreateSession(ctx, other.ID, otherExpired, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	if _, err := s.Sessions.SessionByTokenHash(ctx, expired); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("SessionByTokenHash of an expired session returned %v, want ErrNotFound", err)
+	}
+
+	deleted, err := s.Sessions.DeleteExpiredSessions(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("DeleteExpiredSessions: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("DeleteExpiredSessions removed %d sessions, want 1", deleted)
+	}
+	if _, err := s.Sessions.SessionByTokenHash(ctx, live); err != nil {
+		t.Errorf("DeleteExpiredSessions removed a live session: %v", err)
+	}
+	if deleted, err := s.Sessions.DeleteExpiredSessions(ctx, other.ID); err != nil || deleted != 1 {
+		t.Errorf("DeleteExpiredSessions of another user returned %d, %v, want 1", deleted, err)
+	}
+}
+
+func assertSameUser(t *testing.T, got, want *models.User) {
+	t.Helper()
+	if got.ID != want.ID || got.TelegramID != want.TelegramID || got.Username != want.Username ||
+		got.FirstName != want.FirstName || got.LastName != want.LastName || got.AllowsWriteToPM != want.AllowsWriteToPM ||
+		!got.CreatedAt.Equal(want.CreatedAt) || !got.UpdatedAt.Equal(want.UpdatedAt) {
+		t.Errorf("got user %+v, want %+v", got, want)
+	}
+}
+
+func assertSamePage(t *testing.T, got, want *models.Page) {
+	t.Helper()
+	if got.ID != want.ID || got.UserID != want.UserID || got.WorkspaceID != want.WorkspaceID ||
+		got.Title != want.Title || got.Description != want.Description || got.CoverImage != want.CoverImage ||
+		got.PriceStars != want.PriceStars || !got.CreatedAt.Equal(want.CreatedAt) || !got.UpdatedAt.Equal(want.UpdatedAt) {
+		t.Errorf("got page %+v, want %+v", got, want)
+	}
+	assertSameJSON(t, got.JSONData, want.JSONData)
+}
+
+// assertSameJSON compares documents by value, since jsonb reformats them
+func assertSameJSON(t *testing.T, got, want models.JSONData) {
+	t.Helper()
+	if (got == nil) != (want == nil) {
+		t.Errorf("got json_data %s, want %s", got, want)
+		return
+	}
+	if got == nil {
+		return
+	}
+	var g, w interface{}
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Errorf("got invalid json_data %s: %v", got, err)
+		return
+	}
+	if err := json.Unmarshal(want, &w); err != nil {
+		t.Errorf("want invalid json_data %s: %v", want, err)
+		return
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("got json_data %s, want %s", got, want)
+	}
+}
@@ -1,6 +1,9 @@
 package routes
 
 import (
+	"net/http"
+
+	"tma/access"
 	"tma/auth"
 	"tma/handlers"
 	"tma/middleware"
@@ -11,20 +14,51 @@ import (
 func SetupRoutes(
 	authHandler *handlers.AuthHandler,
 	pagesHandler *handlers.PagesHandler,
+	exportHandler *handlers.ExportHandler,
+	renderHandler *handlers.RenderHandler,
+	statsHandler *handlers.StatsHandler,
+	assetsHandler *handlers.AssetsHandler,
+	collabHandler *handlers.CollabHandler,
+	eventsHandler *handlers.EventsHandler,
+	membersHandler *handlers.MembersHandler,
+	workspacesHandler *handlers.WorkspacesHandler,
+	notificationsHandler *handlers.NotificationsHandler,
+	shareHandler *handlers.ShareHandler,
+	paymentsHandler *handlers.PaymentsHandler,
+	referralsHandler *handlers.ReferralsHandler,
+	telegramHandler *handlers.TelegramHandler,
+	healthHandler *handlers.HealthHandler,
+	metricsHandler http.Handler,
+	authz *access.Authorizer,
 	jwtManager *auth.JWTManager,
 ) *gin.Engine {
-	router := gin.Default()
+	router := gin.New()
+
+	// Request IDs and access logs come first so they cover recovered
+	// panics and preflight requests too
+	router.Use(middleware.RequestLogger())
+	router.Use(middleware.Metrics())
+	router.Use(middleware.Recovery())
 
 	// Add CORS middleware
 	router.Use(middleware.CORSMiddleware())
 
-	// Health check endpoint
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"status":  "ok",
-			"message": "Telegram Mini App API is running",
-		})
-	})
+	// Health checks; /health is the readiness check under its old name
+	router.GET("/livez", healthHandler.Livez)
+	router.GET("/readyz", healthHandler.Readyz)
+	router.GET("/health", healthHandler.Readyz)
+
+	// Prometheus metrics, unless served on their own address
+	if metricsHandler != nil {
+		router.GET("/metrics", gin.WrapH(metricsHandler))
+	}
+
+	// Server-rendered public pages
+	router.GET("/p/:id", renderHandler.RenderPage)
+	router.GET("/p/:id/preview.png", renderHandler.PreviewImage)
+
+	// Telegram bot updates
+	router.POST("/telegram/webhook/:secret", telegramHandler.Webhook)
 
 	// API routes
 	api := router.Group("/api/v1")
@@ -37,25 +71,86 @@ func SetupRoutes(
 		}
 
 		// Public page route (no authentication required)
-		api.GET("/pages/:id", pagesHandler.GetPage)
+		api.GET("/pages/:id", middleware.OptionalAuthMiddleware(jwtManager), pagesHandler.GetPage)
+		api.GET("/assets/:id", assetsHandler.GetAsset)
+
+		// Streaming endpoints authenticate inside the handler, since
+		// WebSocket and EventSource clients pass the token as a query parameter
+		api.GET("/pages/:id/ws", collabHandler.EditPage)
+		api.GET("/pages/events", eventsHandler.StreamPageEvents)
 
 		// Protected routes (authentication required)
 		protected := api.Group("/")
-		protected.Use(middleware.AuthMiddleware(jwtManager))
+		protected.Use(middleware.AuthMiddleware(jwtManager), middleware.WorkspaceMiddleware(authz))
 		{
 			// User routes
 			user := protected.Group("/user")
 			{
 				user.GET("/profile", authHandler.GetProfile)
+				user.GET("/notifications", notificationsHandler.GetSettings)
+				user.PUT("/notifications", notificationsHandler.UpdateSettings)
 			}
 
 			// Protected pages routes
 			protectedPages := protected.Group("/pages")
 			{
 				protectedPages.GET("", pagesHandler.GetPages)
+				protectedPages.GET("/shared-with-me", membersHandler.SharedWithMe)
+				protectedPages.GET("/export", exportHandler.ExportPages)
+				protectedPages.POST("/import", exportHandler.ImportPages)
 				protectedPages.POST("", pagesHandler.CreatePage)
 				protectedPages.PUT("/:id", pagesHandler.UpdatePage)
 				protectedPages.DELETE("/:id", pagesHandler.DeletePage)
+				protectedPages.GET("/:id/stats", statsHandler.GetPageStats)
+
+				// Sharing
+				protectedPages.GET("/:id/members", membersHandler.ListMembers)
+				protectedPages.POST("/:id/members", membersHandler.AddMember)
+				protectedPages.PUT("/:id/members/:user_id", membersHandler.UpdateMember)
+				protectedPages.DELETE("/:id/members/:user_id", membersHandler.RemoveMember)
+				protectedPages.GET("/:id/invites", membersHandler.ListInvites)
+				protectedPages.POST("/:id/invites", membersHandler.CreateInvite)
+				protectedPages.DELETE("/:id/invites/:invite_id", membersHandler.DeleteInvite)
+				protectedPages.POST("/:id/move", workspacesHandler.MovePage)
+				protectedPages.POST("/:id/share-message", shareHandler.PrepareMessage)
+
+				// Paid access
+				protectedPages.PUT("/:id/price", paymentsHandler.SetPrice)
+				protectedPages.POST("/:id/invoice", paymentsHandler.CreateInvoice)
+				protectedPages.GET("/:id/purchases", paymentsHandler.ListPageSales)
+			}
+
+			// Workspace routes
+			workspaces := protected.Group("/workspaces")
+			{
+				workspaces.GET("", workspacesHandler.ListWorkspaces)
+				workspaces.POST("", workspacesHandler.CreateWorkspace)
+				workspaces.PUT("/:id", workspacesHandler.UpdateWorkspace)
+				workspaces.DELETE("/:id", workspacesHandler.DeleteWorkspace)
+				workspaces.POST("/:id/switch", workspacesHandler.SwitchWorkspace)
+				workspaces.GET("/:id/members", workspacesHandler.ListWorkspaceMembers)
+				workspaces.POST("/:id/members", workspacesHandler.AddWorkspaceMember)
+				workspaces.PUT("/:id/members/:user_id", workspacesHandler.UpdateWorkspaceMember)
+				workspaces.DELETE("/:id/members/:user_id", workspacesHandler.RemoveWorkspaceMember)
+			}
+
+			protected.POST("/invites/:token/accept", membersHandler.AcceptInvite)
+
+			// Purchases of paid pages
+			protected.GET("/purchases", paymentsHandler.ListPurchases)
+			protected.POST("/purchases/:id/refund", paymentsHandler.RefundPurchase)
+
+			// Referral links and the signups they brought
+			protected.POST("/referrals/link", referralsHandler.CreateLink)
+			protected.GET("/referrals/stats", referralsHandler.GetStats)
+
+			// Asset routes
+			assets := protected.Group("/assets")
+			{
+				assets.GET("", assetsHandler.ListAssets)
+				assets.POST("", assetsHandler.UploadAsset)
+				assets.GET("/:id/info", assetsHandler.GetAssetInfo)
+				assets.DELETE("/:id", assetsHandler.DeleteAsset)
 			}
 		}
 	}
@@ -0,0 +1,30 @@
+package routes_test
+
+import (
+	"net/http"
+	"testing"
+
+	"tma/routes"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// Handlers are only referenced while registering, so none are needed
+	router := routes.SetupRoutes(nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	registered := make(map[string]bool)
+	for _, route := range router.Routes() {
+		registered[route.Method+" "+route.Path] = true
+	}
+	for _, route := range []string{
+		http.MethodGet + " /api/v1/pages/shared-with-me",
+		http.MethodGet + " /api/v1/pages/:id/members",
+		http.MethodGet + " /p/:id",
+	} {
+		if !registered[route] {
+			t.Errorf("%s is not registered", route)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"tma/config"
+	"tma/handlers"
+)
+
+// newServer creates the HTTP server. The write timeout bounds ordinary
+// responses; streaming handlers lift it for their own requests.
+func newServer(cfg *config.Config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           handler,
+		ReadHeaderTimeout: min(cfg.HTTPReadTimeout, 10*time.Second),
+		ReadTimeout:       cfg.HTTPReadTimeout,
+		WriteTimeout:      cfg.HTTPWriteTimeout,
+		IdleTimeout:       cfg.HTTPIdleTimeout,
+	}
+}
+
+// serve runs the server until SIGINT or SIGTERM, then shuts it down:
+// health checks start failing, requests keep being served for the drain
+// period while load balancers notice, and in-flight requests get until
+// the shutdown timeout to finish. A second signal skips the drain.
+//
+// serve returns once every handler has, hijacked connections included,
+// since the caller closes the workers handlers use next. Handlers that
+// outlive a forced close get one more shutdown timeout.
+func serve(server *http.Server, health *handlers.HealthHandler, drain, timeout time.Duration) error {
+	active := &activeHandlers{}
+	server.Handler = active.track(server.Handler)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.ListenAndServe()
+	}()
+
+	stop := make(chan os.Signal, 2)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	select {
+	case err := <-errc:
+		return fmt.Errorf("failed to start server: %w", err)
+	case sig := <-stop:
+		slog.Info("Received signal, draining", "signal", sig.String(), "drain", drain.String())
+	}
+
+	health.Drain()
+	timer := time.NewTimer(drain)
+	select {
+	case <-timer.C:
+	case <-stop:
+		timer.Stop()
+		slog.Info("Received second signal, shutting down now")
+	}
+
+	slog.Info("Shutting down server")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("Failed to finish in-flight requests", "err", err)
+		server.Close()
+		cancel()
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+	}
+	// Shutdown does not wait for hijacked connections and Close for
+	// nothing at all
+	if err := active.wait(ctx); err != nil {
+		slog.Error("Handlers still running, stopping workers anyway", "handlers", active.n.Load())
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// activeHandlers counts the handlers that have not returned yet
+type activeHandlers struct {
+	n atomic.Int64
+}
+
+func (a *activeHandlers) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.n.Add(1)
+		defer a.n.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// wait returns once no handler is running, or ctx's error when it is done
+// first
+func (a *activeHandlers) wait(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for a.n.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"tma/storage"
+	"tma/storage/s3test"
+)
+
+func newS3(t *testing.T, server *s3test.Server, secret string) *storage.S3 {
+	t.Helper()
+	s3, err := storage.NewS3(storage.S3Config{
+		Endpoint:        server.URL,
+		Region:          server.Region,
+		Bucket:          server.Bucket,
+		AccessKeyID:     server.AccessKeyID,
+		SecretAccessKey: secret,
+		PathStyle:       true,
+	})
+	if err != nil {
+		t.Fatalf("NewS3: %v", err)
+	}
+	return s3
+}
+
+func TestS3RoundTrip(t *testing.T) {
+	server := s3test.NewServer("assets")
+	defer server.Close()
+	s3 := newS3(t, server, server.SecretAccessKey)
+	ctx := context.Background()
+
+	// Keys with characters that SigV4 encodes
+	for _, key := range []string{"1/photo.jpg", "2/my photo+final (1).png", "3/фото.jpg"} {
+		content := []byte("content of " + key)
+		if err := s3.Put(ctx, key, bytes.NewReader(content), int64(len(content)), "image/jpeg"); err != nil {
+			t.Fatalf("Put %q: %v", key, err)
+		}
+		obj, ok := server.Object(key)
+		if !ok || !bytes.Equal(obj.Body, content) || obj.ContentType != "image/jpeg" {
+			t.Fatalf("stored %q is %+v, %t", key, obj, ok)
+		}
+
+		r, err := s3.Open(ctx, key)
+		if err != nil {
+			t.Fatalf("Open %q: %v", key, err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(got, content) {
+			t.Fatalf("Open %q read %q, %v", key, got, err)
+		}
+
+		if err := s3.Delete(ctx, key); err != nil {
+			t.Fatalf("Delete %q: %v", key, err)
+		}
+		if _, err := s3.Open(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+			t.Fatalf("Open %q after Delete returned %v, want ErrNotFound", key, err)
+		}
+	}
+
+	if err := s3.Delete(ctx, "missing"); err != nil {
+		t.Errorf("Delete of a missing object: %v", err)
+	}
+	if n := server.Len(); n != 0 {
+		t.Errorf("%d objects left", n)
+	}
+}
+
+func TestS3WrongSecret(t *testing.T) {
+	server := s3test.NewServer("assets")
+	defer server.Close()
+	s3 := newS3(t, server, "wrong-secret")
+
+	err := s3.Put(context.Background(), "1/a.txt", strings.NewReader("a"), 1, "text/plain")
+	if err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Fatalf("Put with a wrong secret returned %v, want SignatureDoesNotMatch", err)
+	}
+	if n := server.Len(); n != 0 {
+		t.Errorf("%d objects stored", n)
+	}
+}
+
+func TestS3Errors(t *testing.T) {
+	server := s3test.NewServer("assets")
+	defer server.Close()
+	s3 := newS3(t, server, server.SecretAccessKey)
+	ctx := context.Background()
+
+	server.Fail(http.MethodPut, http.StatusInternalServerError)
+	if err := s3.Put(ctx, "1/a.txt", strings.NewReader("a"), 1, "text/plain"); err == nil {
+		t.Error("Put returned no error for a 500")
+	}
+	if err := s3.Put(ctx, "1/a.txt", strings.NewReader("a"), 1, "text/plain"); err != nil {
+		t.Fatalf("Put after the failure: %v", err)
+	}
+
+	server.Fail(http.MethodGet, http.StatusServiceUnavailable)
+	if _, err := s3.Open(ctx, "1/a.txt"); err == nil || errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Open returned %v for a 503, want an error other than ErrNotFound", err)
+	}
+
+	server.Fail(http.MethodDelete, http.StatusForbidden)
+	if err := s3.Delete(ctx, "1/a.txt"); err == nil {
+		t.Error("Delete returned no error for a 403")
+	}
+}
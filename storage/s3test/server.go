@@ -0,0 +1,246 @@
+// Package s3test provides an in-process fake of an S3-compatible bucket.
+// It checks the Signature Version 4 of every request, keeps objects in
+// memory and can be scripted to fail, so the S3 backend can be exercised
+// without MinIO or AWS. Objects are addressed path-style.
+package s3test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Object is a stored object
+type Object struct {
+	Body        []byte
+	ContentType string
+}
+
+// Server is a fake S3 endpoint holding a single bucket
+type Server struct {
+	*httptest.Server
+	Bucket          string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+
+	mu       sync.Mutex
+	objects  map[string]Object
+	failures map[string][]int
+}
+
+// NewServer starts a fake server on a random local port. Close it when done.
+func NewServer(bucket string) *Server {
+	s := newServer(bucket)
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// NewServerAt starts a fake server listening on addr, for running it as a
+// standalone process
+func NewServerAt(bucket, addr string) (*Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := newServer(bucket)
+	s.Server = httptest.NewUnstartedServer(s)
+	s.Server.Listener.Close()
+	s.Server.Listener = l
+	s.Server.Start()
+	return s, nil
+}
+
+func newServer(bucket string) *Server {
+	return &Server{
+		Bucket:          bucket,
+		Region:          "us-east-1",
+		AccessKeyID:     "test-access-key",
+		SecretAccessKey: "test-secret-key",
+		objects:         make(map[string]Object),
+		failures:        make(map[string][]int),
+	}
+}
+
+// Object returns the object stored under key
+func (s *Server) Object(key string) (Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[key]
+	return obj, ok
+}
+
+// Len returns the number of stored objects
+func (s *Server) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.objects)
+}
+
+// Fail makes the next request with the method fail with status. Calls
+// queue up, one failure per request.
+func (s *Server) Fail(method string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = append(s.failures[method], status)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if code, msg := s.verify(r, body); code != "" {
+		writeError(w, http.StatusForbidden, code, msg)
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != s.Bucket {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", "Missing object key")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if failures := s.failures[r.Method]; len(failures) > 0 {
+		s.failures[r.Method] = failures[1:]
+		writeError(w, failures[0], "InternalError", "Scripted failure")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		s.objects[key] = Object{Body: body, ContentType: r.Header.Get("Content-Type")}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		obj, ok := s.objects[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+			return
+		}
+		if obj.ContentType != "" {
+			w.Header().Set("Content-Type", obj.ContentType)
+		}
+		w.Write(obj.Body)
+	case http.MethodDelete:
+		// Deleting a missing object succeeds, as on S3
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed")
+	}
+}
+
+// verify checks the SigV4 authentication of r and returns an S3 error
+// code and message when it does not hold
+func (s *Server) verify(r *http.Request, body []byte) (string, string) {
+	auth := r.Header.Get("Authorization")
+	rest, ok := strings.CutPrefix(auth, "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return "AccessDenied", "Missing or unsupported authorization"
+	}
+	fields := make(map[string]string)
+	for _, part := range strings.Split(rest, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		fields[name] = value
+	}
+
+	credential := strings.Split(fields["Credential"], "/")
+	if len(credential) != 5 || credential[0] != s.AccessKeyID {
+		return "InvalidAccessKeyId", "The access key does not exist"
+	}
+	date, region := credential[1], credential[2]
+	if region != s.Region || credential[3] != "s3" || credential[4] != "aws4_request" {
+		return "AuthorizationHeaderMalformed", "Invalid credential scope"
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	if !strings.HasPrefix(amzDate, date) {
+		return "AuthorizationHeaderMalformed", "X-Amz-Date does not match the credential date"
+	}
+
+	payloadHash := sha256Hex(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != payloadHash {
+		return "XAmzContentSHA256Mismatch", "The payload hash does not match the body"
+	}
+
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	if !sort.StringsAreSorted(signed) {
+		return "AuthorizationHeaderMalformed", "Signed headers are not sorted"
+	}
+	var headers strings.Builder
+	for _, name := range signed {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		encodePath(r.URL.Path),
+		r.URL.Query().Encode(),
+		headers.String(),
+		fields["SignedHeaders"],
+		payloadHash,
+	}, "\n")
+	scope := strings.Join(credential[1:], "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	want := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	if !hmac.Equal([]byte(fields["Signature"]), []byte(want)) {
+		return "SignatureDoesNotMatch", "The request signature does not match"
+	}
+	return "", ""
+}
+
+// encodePath percent-encodes everything but unreserved characters and
+// the path separator
+func encodePath(path string) string {
+	var b strings.Builder
+	for _, c := range []byte(path) {
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<Error><Code>%s</Code><Message>%s</Message></Error>", code, message)
+}
@@ -0,0 +1,232 @@
+// Package telegram is a small client for the Telegram Bot API. It covers
+// the methods the server uses, retries transient failures and honours the
+// retry_after hint of rate-limited (429) responses.
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the public Bot API endpoint
+const DefaultBaseURL = "https://api.telegram.org"
+
+// Options configures a Client. Zero values select the defaults.
+type Options struct {
+	// BaseURL points the client at another Bot API server, such as a
+	// self-hosted one or the fake in package telegramtest
+	BaseURL    string
+	HTTPClient *http.Client
+	// MaxRetries is the number of retries after the first attempt
+	MaxRetries int
+	// MaxRetryAfter caps how long a 429 response may make the client wait;
+	// longer waits are returned as errors instead
+	MaxRetryAfter time.Duration
+}
+
+// Client calls Bot API methods for a single bot
+type Client struct {
+	token         string
+	baseURL       string
+	http          *http.Client
+	maxRetries    int
+	maxRetryAfter time.Duration
+	backoff       time.Duration
+}
+
+func NewClient(token string, opts Options) *Client {
+	c := &Client{
+		token:         token,
+		baseURL:       strings.TrimRight(opts.BaseURL, "/"),
+		http:          opts.HTTPClient,
+		maxRetries:    opts.MaxRetries,
+		maxRetryAfter: opts.MaxRetryAfter,
+		backoff:       500 * time.Millisecond,
+	}
+	if c.baseURL == "" {
+		c.baseURL = DefaultBaseURL
+	}
+	if c.http == nil {
+		// Long-polling getUpdates holds the request open, so the timeout is
+		// left to the caller's context
+		c.http = &http.Client{}
+	}
+	if c.maxRetries == 0 {
+		c.maxRetries = 3
+	}
+	if c.maxRetryAfter == 0 {
+		c.maxRetryAfter = time.Minute
+	}
+	return c
+}
+
+// Enabled reports whether the client has a token to call the API with
+func (c *Client) Enabled() bool {
+	return c.token != ""
+}
+
+// BotID returns the bot's user ID, which is the part of the token before
+// the colon, or 0 if the token is malformed
+func (c *Client) BotID() int64 {
+	id, _, _ := strings.Cut(c.token, ":")
+	n, _ := strconv.ParseInt(id, 10, 64)
+	return n
+}
+
+// Error is an unsuccessful Bot API response
+type Error struct {
+	Method      string
+	Code        int
+	Description string
+	// RetryAfter is set on 429 responses
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("telegram: %s: %d %s", e.Method, e.Code, e.Description)
+}
+
+// ErrNoToken is returned by every method of a client without a token
+var ErrNoToken = errors.New("telegram: bot token is not configured")
+
+// IsForbidden reports whether err means the bot may not message the chat,
+// typically because the user blocked it or never started it
+func IsForbidden(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden
+}
+
+// IsPermanent reports whether err is an API error that retrying cannot
+// fix, such as a bad request or a blocked bot
+func IsPermanent(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Code < http.StatusInternalServerError && apiErr.Code != http.StatusTooManyRequests
+}
+
+type response struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+	Parameters  *struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// Call invokes a Bot API method with params encoded as JSON and decodes
+// the result into result, which may be nil. Network errors and 5xx
+// responses are retried with exponential backoff, 429 responses after the
+// delay Telegram asks for.
+func (c *Client) Call(ctx context.Context, method string, params, result interface{}) error {
+	if c.token == "" {
+		return ErrNoToken
+	}
+
+	body := []byte("{}")
+	if params != nil {
+		var err error
+		if body, err = json.Marshal(params); err != nil {
+			return fmt.Errorf("telegram: %s: %w", method, err)
+		}
+	}
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		err := c.do(ctx, method, body, result)
+		if err == nil || attempt >= c.maxRetries {
+			return err
+		}
+
+		var wait time.Duration
+		var apiErr *Error
+		switch {
+		case errors.As(err, &apiErr) && apiErr.Code == http.StatusTooManyRequests:
+			if apiErr.RetryAfter > c.maxRetryAfter {
+				return err
+			}
+			wait = apiErr.RetryAfter
+		case errors.As(err, &apiErr) && apiErr.Code < http.StatusInternalServerError:
+			return err
+		case ctx.Err() != nil:
+			return err
+		default:
+			wait = backoff
+			backoff *= 2
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) do(ctx context.Context, method string, body []byte, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/bot"+c.token+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("telegram: %s: %w", method, redact(err, c.token))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		// The request URL contains the token; keep it out of errors and logs
+		return fmt.Errorf("telegram: %s: %w", method, redact(err, c.token))
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return fmt.Errorf("telegram: %s: %w", method, err)
+	}
+
+	var r response
+	if err := json.Unmarshal(data, &r); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return &Error{Method: method, Code: resp.StatusCode, Description: http.StatusText(resp.StatusCode)}
+		}
+		return fmt.Errorf("telegram: %s: invalid response: %w", method, err)
+	}
+	if !r.OK {
+		apiErr := &Error{Method: method, Code: r.ErrorCode, Description: r.Description}
+		if apiErr.Code == 0 {
+			apiErr.Code = resp.StatusCode
+		}
+		if r.Parameters != nil {
+			apiErr.RetryAfter = time.Duration(r.Parameters.RetryAfter) * time.Second
+		}
+		return apiErr
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(r.Result, result); err != nil {
+		return fmt.Errorf("telegram: %s: invalid result: %w", method, err)
+	}
+	return nil
+}
+
+// redact removes the bot token from the request URL reported by
+// *url.Error, keeping the error chain intact for errors.Is
+func redact(err error, token string) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+	copied := *urlErr
+	copied.URL = strings.ReplaceAll(copied.URL, token, "<token>")
+	return &copied
+}
@@ -0,0 +1,170 @@
+package telegram_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"tma/telegram"
+	"tma/telegram/telegramtest"
+)
+
+func TestSendMessage(t *testing.T) {
+	server := telegramtest.NewServer("123456:test-token")
+	defer server.Close()
+
+	msg, err := server.Client().SendMessage(context.Background(), telegram.SendMessageParams{ChatID: 42, Text: "hello"})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if msg.Chat.ID != 42 || msg.Text != "hello" || msg.MessageID == 0 {
+		t.Errorf("SendMessage returned %+v", msg)
+	}
+
+	calls := server.Calls("sendMessage")
+	if len(calls) != 1 {
+		t.Fatalf("%d sendMessage calls, want 1", len(calls))
+	}
+	var params telegram.SendMessageParams
+	if err := calls[0].Decode(&params); err != nil || params.ChatID != 42 || params.Text != "hello" {
+		t.Errorf("sent %+v, %v", params, err)
+	}
+}
+
+func TestPermanentErrors(t *testing.T) {
+	server := telegramtest.NewServer("123456:test-token")
+	defer server.Close()
+	client := server.Client()
+	ctx := context.Background()
+
+	// Bad requests and blocked bots are not retried
+	server.Fail("sendMessage", &telegram.Error{Code: http.StatusBadRequest, Description: "Bad Request: chat not found"})
+	_, err := client.SendMessage(ctx, telegram.SendMessageParams{ChatID: 1, Text: "a"})
+	var apiErr *telegram.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusBadRequest || apiErr.Method != "sendMessage" || apiErr.Description != "Bad Request: chat not found" {
+		t.Fatalf("SendMessage returned %v, want the 400", err)
+	}
+	if !telegram.IsPermanent(err) || telegram.IsForbidden(err) {
+		t.Errorf("400: IsPermanent %t, IsForbidden %t", telegram.IsPermanent(err), telegram.IsForbidden(err))
+	}
+
+	server.Fail("sendMessage", &telegram.Error{Code: http.StatusForbidden, Description: "Forbidden: bot was blocked by the user"})
+	_, err = client.SendMessage(ctx, telegram.SendMessageParams{ChatID: 1, Text: "a"})
+	if !telegram.IsForbidden(err) || !telegram.IsPermanent(err) {
+		t.Errorf("403 returned %v, want a permanent forbidden error", err)
+	}
+
+	if n := len(server.Calls("sendMessage")); n != 2 {
+		t.Errorf("%d sendMessage calls, want 2 without retries", n)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	server := telegramtest.NewServer("123456:test-token")
+	defer server.Close()
+
+	server.Fail("getMe", &telegram.Error{Code: http.StatusTooManyRequests, Description: "Too Many Requests: retry after 1", RetryAfter: time.Second})
+	start := time.Now()
+	me, err := server.Client().GetMe(context.Background())
+	if err != nil {
+		t.Fatalf("GetMe: %v", err)
+	}
+	if me.ID != server.Bot.ID {
+		t.Errorf("GetMe returned %+v", me)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want the 1s retry_after", elapsed)
+	}
+	if n := len(server.Calls("getMe")); n != 2 {
+		t.Errorf("%d getMe calls, want 2", n)
+	}
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	server := telegramtest.NewServer("123456:test-token")
+	defer server.Close()
+
+	// The test client waits at most 5 seconds
+	server.Fail("getMe", &telegram.Error{Code: http.StatusTooManyRequests, Description: "Too Many Requests: retry after 30", RetryAfter: 30 * time.Second})
+	_, err := server.Client().GetMe(context.Background())
+	var apiErr *telegram.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusTooManyRequests || apiErr.RetryAfter != 30*time.Second {
+		t.Fatalf("GetMe returned %v, want the 429 with its retry_after", err)
+	}
+	if telegram.IsPermanent(err) {
+		t.Error("429 is permanent")
+	}
+	if n := len(server.Calls("getMe")); n != 1 {
+		t.Errorf("%d getMe calls, want 1", n)
+	}
+}
+
+func TestRetryAfterCanceled(t *testing.T) {
+	server := telegramtest.NewServer("123456:test-token")
+	defer server.Close()
+
+	server.Fail("getMe", &telegram.Error{Code: http.StatusTooManyRequests, Description: "Too Many Requests: retry after 3", RetryAfter: 3 * time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := server.Client().GetMe(ctx); err == nil {
+		t.Fatal("GetMe succeeded after its context was done")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("GetMe returned after %s, want it to stop waiting with the context", elapsed)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	server := telegramtest.NewServer("123456:test-token")
+	defer server.Close()
+	client := telegram.NewClient(server.Token, telegram.Options{BaseURL: server.URL, MaxRetries: 1})
+	ctx := context.Background()
+
+	server.Fail("getMe", &telegram.Error{Code: http.StatusBadGateway, Description: "Bad Gateway"})
+	if _, err := client.GetMe(ctx); err != nil {
+		t.Fatalf("GetMe after one 502: %v", err)
+	}
+
+	server.Fail("getMe",
+		&telegram.Error{Code: http.StatusInternalServerError, Description: "Internal Server Error"},
+		&telegram.Error{Code: http.StatusInternalServerError, Description: "Internal Server Error"},
+	)
+	_, err := client.GetMe(ctx)
+	var apiErr *telegram.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusInternalServerError {
+		t.Fatalf("GetMe returned %v, want the 500 once retries run out", err)
+	}
+	if n := len(server.Calls("getMe")); n != 4 {
+		t.Errorf("%d getMe calls, want 4", n)
+	}
+}
+
+func TestTokenNotInErrors(t *testing.T) {
+	server := telegramtest.NewServer("123456:test-token")
+	url := server.URL
+	server.Close()
+
+	client := telegram.NewClient("123456:test-token", telegram.Options{BaseURL: url, MaxRetries: -1})
+	_, err := client.GetMe(context.Background())
+	if err == nil {
+		t.Fatal("GetMe succeeded against a closed server")
+	}
+	if strings.Contains(err.Error(), "test-token") {
+		t.Errorf("error %q contains the token", err)
+	}
+}
+
+func TestNoToken(t *testing.T) {
+	client := telegram.NewClient("", telegram.Options{})
+	if client.Enabled() {
+		t.Error("client without a token is enabled")
+	}
+	if _, err := client.GetMe(context.Background()); !errors.Is(err, telegram.ErrNoToken) {
+		t.Errorf("GetMe returned %v, want ErrNoToken", err)
+	}
+}
@@ -0,0 +1,183 @@
+package telegram
+
+import "context"
+
+func (c *Client) GetMe(ctx context.Context) (*User, error) {
+	var user User
+	if err := c.Call(ctx, "getMe", nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+type SendMessageParams struct {
+	ChatID             int64                 `json:"chat_id"`
+	Text               string                `json:"text"`
+	ParseMode          string                `json:"parse_mode,omitempty"`
+	LinkPreviewOptions *LinkPreviewOptions   `json:"link_preview_options,omitempty"`
+	ReplyMarkup        *InlineKeyboardMarkup `json:"reply_markup,omitempty"`
+}
+
+func (c *Client) SendMessage(ctx context.Context, params SendMessageParams) (*Message, error) {
+	var msg Message
+	if err := c.Call(ctx, "sendMessage", params, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+type AnswerCallbackQueryParams struct {
+	CallbackQueryID string `json:"callback_query_id"`
+	Text            string `json:"text,omitempty"`
+	ShowAlert       bool   `json:"show_alert,omitempty"`
+}
+
+func (c *Client) AnswerCallbackQuery(ctx context.Context, params AnswerCallbackQueryParams) error {
+	return c.Call(ctx, "answerCallbackQuery", params, nil)
+}
+
+// AnswerWebAppQuery sends a message on behalf of the user who opened the
+// Mini App with the given query_id
+func (c *Client) AnswerWebAppQuery(ctx context.Context, webAppQueryID string, result InlineQueryResultArticle) (*SentWebAppMessage, error) {
+	params := struct {
+		WebAppQueryID string                   `json:"web_app_query_id"`
+		Result        InlineQueryResultArticle `json:"result"`
+	}{webAppQueryID, result}
+
+	var sent SentWebAppMessage
+	if err := c.Call(ctx, "answerWebAppQuery", params, &sent); err != nil {
+		return nil, err
+	}
+	return &sent, nil
+}
+
+type AnswerInlineQueryParams struct {
+	InlineQueryID string                     `json:"inline_query_id"`
+	Results       []InlineQueryResultArticle `json:"results"`
+	CacheTime     int                        `json:"cache_time,omitempty"`
+	IsPersonal    bool                       `json:"is_personal,omitempty"`
+	NextOffset    string                     `json:"next_offset,omitempty"`
+	Button        *InlineQueryResultsButton  `json:"button,omitempty"`
+}
+
+func (c *Client) AnswerInlineQuery(ctx context.Context, params AnswerInlineQueryParams) error {
+	return c.Call(ctx, "answerInlineQuery", params, nil)
+}
+
+type SavePreparedInlineMessageParams struct {
+	UserID            int64                    `json:"user_id"`
+	Result            InlineQueryResultArticle `json:"result"`
+	AllowUserChats    bool                     `json:"allow_user_chats,omitempty"`
+	AllowBotChats     bool                     `json:"allow_bot_chats,omitempty"`
+	AllowGroupChats   bool                     `json:"allow_group_chats,omitempty"`
+	AllowChannelChats bool                     `json:"allow_channel_chats,omitempty"`
+}
+
+// SavePreparedInlineMessage stores a message a Mini App can then offer
+// the user to share with shareMessage
+func (c *Client) SavePreparedInlineMessage(ctx context.Context, params SavePreparedInlineMessageParams) (*PreparedInlineMessage, error) {
+	var prepared PreparedInlineMessage
+	if err := c.Call(ctx, "savePreparedInlineMessage", params, &prepared); err != nil {
+		return nil, err
+	}
+	return &prepared, nil
+}
+
+// SetChatMenuButton changes the menu button of a private chat, or the
+// default one when chatID is 0
+func (c *Client) SetChatMenuButton(ctx context.Context, chatID int64, button MenuButton) error {
+	params := struct {
+		ChatID     int64      `json:"chat_id,omitempty"`
+		MenuButton MenuButton `json:"menu_button"`
+	}{chatID, button}
+	return c.Call(ctx, "setChatMenuButton", params, nil)
+}
+
+func (c *Client) SetMyCommands(ctx context.Context, commands []BotCommand) error {
+	params := struct {
+		Commands []BotCommand `json:"commands"`
+	}{commands}
+	return c.Call(ctx, "setMyCommands", params, nil)
+}
+
+type SetWebhookParams struct {
+	URL            string   `json:"url"`
+	SecretToken    string   `json:"secret_token,omitempty"`
+	AllowedUpdates []string `json:"allowed_updates,omitempty"`
+	DropPending    bool     `json:"drop_pending_updates,omitempty"`
+}
+
+func (c *Client) SetWebhook(ctx context.Context, params SetWebhookParams) error {
+	return c.Call(ctx, "setWebhook", params, nil)
+}
+
+func (c *Client) DeleteWebhook(ctx context.Context, dropPending bool) error {
+	params := struct {
+		DropPending bool `json:"drop_pending_updates,omitempty"`
+	}{dropPending}
+	return c.Call(ctx, "deleteWebhook", params, nil)
+}
+
+func (c *Client) GetWebhookInfo(ctx context.Context) (*WebhookInfo, error) {
+	var info WebhookInfo
+	if err := c.Call(ctx, "getWebhookInfo", nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+type GetUpdatesParams struct {
+	Offset int64 `json:"offset,omitempty"`
+	Limit  int   `json:"limit,omitempty"`
+	// Timeout is the long-polling timeout in seconds
+	Timeout        int      `json:"timeout,omitempty"`
+	AllowedUpdates []string `json:"allowed_updates,omitempty"`
+}
+
+func (c *Client) GetUpdates(ctx context.Context, params GetUpdatesParams) ([]Update, error) {
+	var updates []Update
+	if err := c.Call(ctx, "getUpdates", params, &updates); err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
+
+// CreateInvoiceLinkParams describes an invoice. Payments in Telegram Stars
+// use currency "XTR", no provider token and exactly one price.
+type CreateInvoiceLinkParams struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	Payload     string         `json:"payload"`
+	Currency    string         `json:"currency"`
+	Prices      []LabeledPrice `json:"prices"`
+	PhotoURL    string         `json:"photo_url,omitempty"`
+}
+
+// CreateInvoiceLink returns a link a Mini App opens with openInvoice
+func (c *Client) CreateInvoiceLink(ctx context.Context, params CreateInvoiceLinkParams) (string, error) {
+	var link string
+	if err := c.Call(ctx, "createInvoiceLink", params, &link); err != nil {
+		return "", err
+	}
+	return link, nil
+}
+
+type AnswerPreCheckoutQueryParams struct {
+	PreCheckoutQueryID string `json:"pre_checkout_query_id"`
+	OK                 bool   `json:"ok"`
+	// ErrorMessage is shown to the user when OK is false
+	ErrorMessage string `json:"error_message,omitempty"`
+}
+
+func (c *Client) AnswerPreCheckoutQuery(ctx context.Context, params AnswerPreCheckoutQueryParams) error {
+	return c.Call(ctx, "answerPreCheckoutQuery", params, nil)
+}
+
+// RefundStarPayment returns a payment in Telegram Stars to the user
+func (c *Client) RefundStarPayment(ctx context.Context, userID int64, chargeID string) error {
+	params := struct {
+		UserID   int64  `json:"user_id"`
+		ChargeID string `json:"telegram_payment_charge_id"`
+	}{userID, chargeID}
+	return c.Call(ctx, "refundStarPayment", params, nil)
+}
@@ -0,0 +1,490 @@
+// Package telegramtest provides an in-process fake of the Telegram Bot
+// API. It records every call, answers the common methods with plausible
+// results, can be scripted to fail, and serves getUpdates from a queue
+// so bot code can be exercised without reaching Telegram. Payments in
+// Telegram Stars are simulated end to end, see Pay.
+package telegramtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"tma/telegram"
+)
+
+// HandlerFunc answers a method call. Returning a *telegram.Error produces
+// an unsuccessful response with its code, description and retry_after.
+type HandlerFunc func(params json.RawMessage) (interface{}, error)
+
+// Call is a recorded method call
+type Call struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// Decode unmarshals the call parameters into v
+func (c Call) Decode(v interface{}) error {
+	return json.Unmarshal(c.Params, v)
+}
+
+// Server is a fake Bot API server for a single bot token.
+//
+// Besides the Bot API it serves control endpoints for driving the fake
+// from outside the process: POST /updates queues an Update given as JSON,
+// POST /invoices/pay calls Pay with {"link": ..., "from": User} and
+// GET /calls lists the recorded calls.
+type Server struct {
+	*httptest.Server
+	Token string
+	// Bot is returned by getMe
+	Bot telegram.User
+	// OnCall, if set, is called for every Bot API call
+	OnCall func(Call)
+
+	mu        sync.Mutex
+	handlers  map[string]HandlerFunc
+	failures  map[string][]*telegram.Error
+	calls     []Call
+	updates   []telegram.Update
+	nextID    int64
+	messageID int
+	arrived   chan struct{}
+
+	invoices  map[string]telegram.CreateInvoiceLinkParams
+	checkouts map[string]checkout
+	charges   map[string]*charge
+	serial    int
+}
+
+// checkout is a pre_checkout_query waiting for answerPreCheckoutQuery
+type checkout struct {
+	from    telegram.User
+	invoice telegram.CreateInvoiceLinkParams
+}
+
+// charge is a completed payment
+type charge struct {
+	from     telegram.User
+	invoice  telegram.CreateInvoiceLinkParams
+	refunded bool
+}
+
+// NewServer starts a fake server on a random local port. Close it when done.
+func NewServer(token string) *Server {
+	s := newServer(token)
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// NewServerAt starts a fake server listening on addr, for running it as a
+// standalone process
+func NewServerAt(token, addr string) (*Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := newServer(token)
+	s.Server = httptest.NewUnstartedServer(s)
+	s.Server.Listener.Close()
+	s.Server.Listener = l
+	s.Server.Start()
+	return s, nil
+}
+
+func newServer(token string) *Server {
+	return &Server{
+		Token:    token,
+		Bot:      telegram.User{ID: 100000, IsBot: true, FirstName: "Test Bot", Username: "test_bot"},
+		handlers: make(map[string]HandlerFunc),
+		failures: make(map[string][]*telegram.Error),
+		// Update IDs keep growing across restarts of a standalone fake, so
+		// a persisted polling offset never hides new updates
+		nextID:    time.Now().Unix(),
+		arrived:   make(chan struct{}),
+		invoices:  make(map[string]telegram.CreateInvoiceLinkParams),
+		checkouts: make(map[string]checkout),
+		charges:   make(map[string]*charge),
+	}
+}
+
+// Client returns a client for the fake with retries tuned for tests
+func (s *Server) Client() *telegram.Client {
+	return telegram.NewClient(s.Token, telegram.Options{
+		BaseURL:       s.URL,
+		MaxRetryAfter: 5 * time.Second,
+	})
+}
+
+// Handle overrides the response of a method
+func (s *Server) Handle(method string, h HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[method] = h
+}
+
+// Fail makes the next calls of method fail with the given errors, in order
+func (s *Server) Fail(method string, errs ...*telegram.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = append(s.failures[method], errs...)
+}
+
+// Calls returns the recorded calls of a method, or of all methods if
+// method is empty
+func (s *Server) Calls(method string) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	calls := make([]Call, 0, len(s.calls))
+	for _, c := range s.calls {
+		if method == "" || c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// PushUpdate queues an update for getUpdates, assigning the next
+// update_id if it is not set, and returns it
+func (s *Server) PushUpdate(u telegram.Update) telegram.Update {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u.UpdateID == 0 {
+		u.UpdateID = s.nextID
+	}
+	if u.UpdateID >= s.nextID {
+		s.nextID = u.UpdateID + 1
+	}
+	s.updates = append(s.updates, u)
+	close(s.arrived)
+	s.arrived = make(chan struct{})
+	return u
+}
+
+// Pay starts paying an invoice link returned by createInvoiceLink, the way
+// the Telegram app does: it queues a pre_checkout_query update and, once
+// the bot approves it with answerPreCheckoutQuery, a message with
+// successful_payment. A refundStarPayment call queues a message with
+// refunded_payment in turn.
+func (s *Server) Pay(link string, from telegram.User) (telegram.Update, error) {
+	s.mu.Lock()
+	invoice, ok := s.invoices[link]
+	if !ok {
+		s.mu.Unlock()
+		return telegram.Update{}, fmt.Errorf("unknown invoice link %q", link)
+	}
+	s.serial++
+	queryID := fmt.Sprintf("fake-checkout-%d", s.serial)
+	s.checkouts[queryID] = checkout{from: from, invoice: invoice}
+	s.mu.Unlock()
+
+	return s.PushUpdate(telegram.Update{PreCheckoutQuery: &telegram.PreCheckoutQuery{
+		ID:             queryID,
+		From:           from,
+		Currency:       invoice.Currency,
+		TotalAmount:    invoiceTotal(invoice),
+		InvoicePayload: invoice.Payload,
+	}}), nil
+}
+
+// PendingUpdates returns the number of updates not yet confirmed through
+// the getUpdates offset
+func (s *Server) PendingUpdates() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.updates)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/updates" && r.Method == http.MethodPost:
+		var u telegram.Update
+		if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		u = s.PushUpdate(u)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(u)
+		return
+	case r.URL.Path == "/invoices/pay" && r.Method == http.MethodPost:
+		var req struct {
+			Link string        `json:"link"`
+			From telegram.User `json:"from"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		u, err := s.Pay(req.Link, req.From)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(u)
+		return
+	case r.URL.Path == "/calls" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Calls(r.URL.Query().Get("method")))
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/bot")
+	token, method, ok := strings.Cut(path, "/")
+	if !ok || token != s.Token {
+		writeResponse(w, nil, &telegram.Error{Code: http.StatusUnauthorized, Description: "Unauthorized"})
+		return
+	}
+
+	params, err := io.ReadAll(r.Body)
+	if err != nil || len(params) == 0 {
+		params = []byte("{}")
+	}
+
+	call := Call{Method: method, Params: params}
+	s.mu.Lock()
+	s.calls = append(s.calls, call)
+	onCall := s.OnCall
+	var failure *telegram.Error
+	if queued := s.failures[method]; len(queued) > 0 {
+		failure, s.failures[method] = queued[0], queued[1:]
+	}
+	h := s.handlers[method]
+	s.mu.Unlock()
+
+	if onCall != nil {
+		onCall(call)
+	}
+
+	if failure != nil {
+		writeResponse(w, nil, failure)
+		return
+	}
+
+	var result interface{}
+	if h != nil {
+		result, err = h(params)
+	} else {
+		result, err = s.defaultResult(r, method, params)
+	}
+	writeResponse(w, result, err)
+}
+
+func (s *Server) defaultResult(r *http.Request, method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "getMe":
+		return s.Bot, nil
+	case "sendMessage":
+		var p telegram.SendMessageParams
+		if err := json.Unmarshal(params, &p); err != nil || p.ChatID == 0 || p.Text == "" {
+			return nil, &telegram.Error{Code: http.StatusBadRequest, Description: "Bad Request: chat_id and text are required"}
+		}
+		s.mu.Lock()
+		s.messageID++
+		id := s.messageID
+		s.mu.Unlock()
+		return telegram.Message{
+			MessageID: id,
+			From:      &s.Bot,
+			Chat:      telegram.Chat{ID: p.ChatID, Type: "private"},
+			Date:      time.Now().Unix(),
+			Text:      p.Text,
+		}, nil
+	case "answerWebAppQuery":
+		return telegram.SentWebAppMessage{InlineMessageID: "inline-1"}, nil
+	case "savePreparedInlineMessage":
+		return telegram.PreparedInlineMessage{ID: "prepared-1", ExpirationDate: time.Now().Add(24 * time.Hour).Unix()}, nil
+	case "getWebhookInfo":
+		return telegram.WebhookInfo{}, nil
+	case "getUpdates":
+		var p telegram.GetUpdatesParams
+		json.Unmarshal(params, &p)
+		return s.getUpdates(r, p), nil
+	case "createInvoiceLink":
+		return s.createInvoiceLink(params)
+	case "answerPreCheckoutQuery":
+		return s.answerPreCheckoutQuery(params)
+	case "refundStarPayment":
+		return s.refundStarPayment(params)
+	case "answerCallbackQuery", "answerInlineQuery", "setChatMenuButton", "setMyCommands",
+		"setWebhook", "deleteWebhook":
+		return true, nil
+	default:
+		return nil, &telegram.Error{Code: http.StatusNotFound, Description: "Not Found: method not found"}
+	}
+}
+
+// getUpdates follows the Bot API semantics: updates below offset are
+// confirmed and dropped, and the call blocks up to timeout seconds while
+// nothing is pending
+func (s *Server) getUpdates(r *http.Request, p telegram.GetUpdatesParams) []telegram.Update {
+	deadline := time.NewTimer(time.Duration(p.Timeout) * time.Second)
+	defer deadline.Stop()
+
+	for {
+		s.mu.Lock()
+		if p.Offset > 0 {
+			kept := s.updates[:0]
+			for _, u := range s.updates {
+				if u.UpdateID >= p.Offset {
+					kept = append(kept, u)
+				}
+			}
+			s.updates = kept
+		}
+		if len(s.updates) > 0 || p.Timeout <= 0 {
+			limit := p.Limit
+			if limit <= 0 || limit > len(s.updates) {
+				limit = len(s.updates)
+			}
+			updates := append([]telegram.Update{}, s.updates[:limit]...)
+			s.mu.Unlock()
+			return updates
+		}
+		arrived := s.arrived
+		s.mu.Unlock()
+
+		select {
+		case <-arrived:
+		case <-deadline.C:
+			return []telegram.Update{}
+		case <-r.Context().Done():
+			return []telegram.Update{}
+		}
+	}
+}
+
+func (s *Server) createInvoiceLink(params json.RawMessage) (interface{}, error) {
+	var p telegram.CreateInvoiceLinkParams
+	if err := json.Unmarshal(params, &p); err != nil || p.Title == "" || p.Description == "" || p.Payload == "" {
+		return nil, &telegram.Error{Code: http.StatusBadRequest, Description: "Bad Request: title, description and payload are required"}
+	}
+	if p.Currency == "XTR" && len(p.Prices) != 1 {
+		return nil, &telegram.Error{Code: http.StatusBadRequest, Description: "Bad Request: exactly one price is allowed for XTR invoices"}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.serial++
+	link := fmt.Sprintf("https://t.me/$fake-invoice-%d", s.serial)
+	s.invoices[link] = p
+	return link, nil
+}
+
+func (s *Server) answerPreCheckoutQuery(params json.RawMessage) (interface{}, error) {
+	var p telegram.AnswerPreCheckoutQueryParams
+	json.Unmarshal(params, &p)
+
+	s.mu.Lock()
+	co, ok := s.checkouts[p.PreCheckoutQueryID]
+	delete(s.checkouts, p.PreCheckoutQueryID)
+	var chargeID string
+	if ok && p.OK {
+		s.serial++
+		chargeID = fmt.Sprintf("fake-charge-%d", s.serial)
+		s.charges[chargeID] = &charge{from: co.from, invoice: co.invoice}
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return nil, &telegram.Error{Code: http.StatusBadRequest, Description: "Bad Request: QUERY_ID_INVALID"}
+	}
+	if p.OK {
+		s.pushServiceMessage(co.from, func(m *telegram.Message) {
+			m.SuccessfulPayment = &telegram.SuccessfulPayment{
+				Currency:                co.invoice.Currency,
+				TotalAmount:             invoiceTotal(co.invoice),
+				InvoicePayload:          co.invoice.Payload,
+				TelegramPaymentChargeID: chargeID,
+			}
+		})
+	}
+	return true, nil
+}
+
+func (s *Server) refundStarPayment(params json.RawMessage) (interface{}, error) {
+	var p struct {
+		UserID   int64  `json:"user_id"`
+		ChargeID string `json:"telegram_payment_charge_id"`
+	}
+	json.Unmarshal(params, &p)
+
+	s.mu.Lock()
+	ch, ok := s.charges[p.ChargeID]
+	var refunded bool
+	if ok && ch.from.ID == p.UserID {
+		refunded = ch.refunded
+		ch.refunded = true
+	}
+	s.mu.Unlock()
+
+	if !ok || ch.from.ID != p.UserID {
+		return nil, &telegram.Error{Code: http.StatusBadRequest, Description: "Bad Request: CHARGE_NOT_FOUND"}
+	}
+	if refunded {
+		return nil, &telegram.Error{Code: http.StatusBadRequest, Description: "Bad Request: CHARGE_ALREADY_REFUNDED"}
+	}
+	s.pushServiceMessage(ch.from, func(m *telegram.Message) {
+		m.RefundedPayment = &telegram.RefundedPayment{
+			Currency:                ch.invoice.Currency,
+			TotalAmount:             invoiceTotal(ch.invoice),
+			InvoicePayload:          ch.invoice.Payload,
+			TelegramPaymentChargeID: p.ChargeID,
+		}
+	})
+	return true, nil
+}
+
+// pushServiceMessage queues a message in the private chat with from
+func (s *Server) pushServiceMessage(from telegram.User, fill func(*telegram.Message)) {
+	s.mu.Lock()
+	s.messageID++
+	msg := &telegram.Message{
+		MessageID: s.messageID,
+		From:      &from,
+		Chat:      telegram.Chat{ID: from.ID, Type: "private"},
+		Date:      time.Now().Unix(),
+	}
+	s.mu.Unlock()
+	fill(msg)
+	s.PushUpdate(telegram.Update{Message: msg})
+}
+
+func invoiceTotal(invoice telegram.CreateInvoiceLinkParams) int {
+	total := 0
+	for _, p := range invoice.Prices {
+		total += p.Amount
+	}
+	return total
+}
+
+func writeResponse(w http.ResponseWriter, result interface{}, err error) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err != nil {
+		apiErr, ok := err.(*telegram.Error)
+		if !ok {
+			apiErr = &telegram.Error{Code: http.StatusInternalServerError, Description: err.Error()}
+		}
+		body := map[string]interface{}{
+			"ok":          false,
+			"error_code":  apiErr.Code,
+			"description": apiErr.Description,
+		}
+		if apiErr.RetryAfter > 0 {
+			body["parameters"] = map[string]int{"retry_after": int(apiErr.RetryAfter / time.Second)}
+		}
+		w.WriteHeader(apiErr.Code)
+		json.NewEncoder(w).Encode(body)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
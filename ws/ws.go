@@ -0,0 +1,300 @@
+// Package ws implements the server side of the WebSocket protocol
+// (RFC 6455), covering what the collaborative editor needs: text and
+// binary messages, fragmentation, ping/pong and the closing handshake.
+package ws
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message opcodes
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// Close status codes
+const (
+	CloseNormal        = 1000
+	CloseGoingAway     = 1001
+	CloseProtocolError = 1002
+	CloseTooBig        = 1009
+	ClosePolicy        = 1008
+)
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// DefaultMaxMessageSize limits the size of a reassembled message
+const DefaultMaxMessageSize = 1 << 20
+
+var (
+	ErrBadHandshake = errors.New("ws: bad handshake")
+	ErrTooBig       = errors.New("ws: message too big")
+)
+
+// CloseError is returned by ReadMessage once the peer closed the connection
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("ws: closed with code %d %s", e.Code, e.Reason)
+}
+
+// Conn is a server-side WebSocket connection. ReadMessage must be called
+// from a single goroutine; WriteMessage is safe for concurrent use.
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	MaxMessageSize int64
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+// Upgrade performs the opening handshake and takes over the connection
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "WebSocket upgrade required", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "Missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, ErrBadHandshake
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Conn{
+		conn:           conn,
+		br:             rw.Reader,
+		MaxMessageSize: DefaultMaxMessageSize,
+	}, nil
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetReadDeadline sets the deadline for the next ReadMessage
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// ReadMessage returns the next data message. Pings are answered and pongs
+// are skipped transparently. A close frame is answered and reported as a
+// *CloseError.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	var (
+		opcode  int
+		message []byte
+	)
+
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case OpPing:
+			if err := c.writeFrame(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			code, reason := CloseNormal, ""
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+				reason = string(payload[2:])
+			}
+			c.WriteClose(code, "")
+			return 0, nil, &CloseError{Code: code, Reason: reason}
+		case OpText, OpBinary:
+			if opcode != 0 {
+				c.WriteClose(CloseProtocolError, "unexpected data frame")
+				return 0, nil, errors.New("ws: unexpected data frame inside fragmented message")
+			}
+			opcode = op
+		case OpContinuation:
+			if opcode == 0 {
+				c.WriteClose(CloseProtocolError, "unexpected continuation")
+				return 0, nil, errors.New("ws: unexpected continuation frame")
+			}
+		default:
+			c.WriteClose(CloseProtocolError, "unknown opcode")
+			return 0, nil, fmt.Errorf("ws: unknown opcode %d", op)
+		}
+
+		if int64(len(message)+len(payload)) > c.MaxMessageSize {
+			c.WriteClose(CloseTooBig, "")
+			return 0, nil, ErrTooBig
+		}
+		message = append(message, payload...)
+
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.br, header[:]); err != nil {
+		return
+	}
+
+	fin = header[0]&0x80 != 0
+	opcode = int(header[0] & 0x0F)
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7F)
+
+	if header[0]&0x70 != 0 {
+		c.WriteClose(CloseProtocolError, "reserved bits set")
+		return false, 0, nil, errors.New("ws: reserved bits set")
+	}
+	// Clients must mask every frame they send
+	if !masked {
+		c.WriteClose(CloseProtocolError, "unmasked frame")
+		return false, 0, nil, errors.New("ws: unmasked client frame")
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+		// The most significant bit must be 0, which keeps the length positive
+		if length < 0 {
+			c.WriteClose(CloseProtocolError, "invalid length")
+			return false, 0, nil, errors.New("ws: invalid frame length")
+		}
+	}
+
+	if opcode >= OpClose && (length > 125 || !fin) {
+		c.WriteClose(CloseProtocolError, "invalid control frame")
+		return false, 0, nil, errors.New("ws: invalid control frame")
+	}
+	if length > c.MaxMessageSize {
+		c.WriteClose(CloseTooBig, "")
+		return false, 0, nil, ErrTooBig
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// WriteMessage sends a single unfragmented message
+func (c *Conn) WriteMessage(opcode int, data []byte) error {
+	return c.writeFrame(opcode, data)
+}
+
+// WriteClose sends a close frame. The connection is not closed until Close.
+func (c *Conn) WriteClose(code int, reason string) error {
+	payload := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	copy(payload[2:], reason)
+	return c.writeFrame(OpClose, payload)
+}
+
+func (c *Conn) writeFrame(opcode int, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	header := make([]byte, 0, 10)
+	header = append(header, 0x80|byte(opcode))
+	switch n := len(payload); {
+	case n <= 125:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, byte(n>>8), byte(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	if opcode == OpClose {
+		c.closed = true
+	}
+	return nil
+}
+
+// Close closes the underlying network connection
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
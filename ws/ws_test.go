@@ -0,0 +1,63 @@
+package ws_test
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tma/ws"
+)
+
+// dial opens a WebSocket connection to a server that reads one message
+// and reports the error
+func dial(t *testing.T) (net.Conn, <-chan error) {
+	t.Helper()
+	errs := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := ws.Upgrade(w, r)
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer conn.Close()
+		_, _, err = conn.ReadMessage()
+		errs <- err
+	}))
+	t.Cleanup(server.Close)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status %d", resp.StatusCode)
+	}
+	return conn, errs
+}
+
+func TestNegativeLength(t *testing.T) {
+	conn, errs := dial(t)
+
+	// A 64-bit length with the top bit set
+	conn.Write([]byte{0x82, 0x80 | 127, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 1, 2, 3, 4})
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("ReadMessage accepted a negative length")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReadMessage did not return")
+	}
+}